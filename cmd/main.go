@@ -1,16 +1,33 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/kataras/iris/v12"
 	zkConfig "github.com/zerok-ai/zk-utils-go/config"
 	zkHttpConfig "github.com/zerok-ai/zk-utils-go/http/config"
 	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+	"net/url"
 	"os"
+	"os/signal"
 	"redis-test/config"
+	"redis-test/handlers"
+	"redis-test/internal/chaos"
+	"redis-test/internal/codec"
 	"redis-test/internal/common"
+	"redis-test/internal/embedded"
+	"redis-test/internal/history"
 	"redis-test/internal/k8s"
 	loadGenerators "redis-test/internal/load-generators"
+	"redis-test/internal/metrics"
+	"redis-test/internal/report"
+	"redis-test/model"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -20,6 +37,12 @@ var LogTag = "main"
 const (
 	redisLoadTestApi        = "/gen-redis-load"
 	redisLoadTestApiAllPods = "/gen-redis-load-all"
+	commandStatsApi         = "/command-stats"
+	chaosApi                = "/chaos"
+	latencyStatsApi         = "/latency-stats"
+	expiryStatsApi          = "/expiry-stats"
+	runsApi                 = "/runs"
+	historyApi              = "/history"
 
 	namespace     = "zk-client"
 	serviceName   = "zk-redis-test"
@@ -40,23 +63,62 @@ func main() {
 	zkHttpConfig.Init(cfg.Http.Debug)
 	zkLogger.Init(cfg.LogsConfig)
 
+	if cfg.EmbeddedRedis.Enabled {
+		redisServer, err := embedded.StartRedisServer(&cfg.Redis)
+		if err != nil {
+			panic(err)
+		}
+		defer redisServer.Close()
+	}
+
+	var chaosProxy *chaos.Proxy
+	if cfg.Chaos.Enabled {
+		var err error
+		chaosProxy, err = chaos.StartRedisProxy(&cfg.Redis, cfg.Chaos.ListenAddr)
+		if err != nil {
+			panic(err)
+		}
+		defer chaosProxy.Close()
+		if len(cfg.Chaos.Schedule) > 0 {
+			chaosProxy.RunSchedule(cfg.Chaos.Schedule)
+		}
+	}
+
 	redisLoadGenerator, err := loadGenerators.NewRedisLoadGenerator(cfg)
 	if err != nil {
 		panic(err)
 	}
-	defer redisLoadGenerator.Close()
+
+	app := newApp(cfg, redisLoadGenerator, chaosProxy)
+	go shutdownOnSignal(app, redisLoadGenerator, time.Duration(cfg.Server.ShutdownGraceSeconds)*time.Second)
 
 	configurator := iris.WithConfiguration(iris.Configuration{
 		DisablePathCorrection: true,
 		LogLevel:              cfg.LogsConfig.Level,
 	})
-	if err = newApp(cfg, redisLoadGenerator).Listen(":"+cfg.Server.Port, configurator); err != nil {
+	err = app.Listen(":"+cfg.Server.Port, configurator, iris.WithoutInterruptHandler)
+	if err != nil && !errors.Is(err, iris.ErrServerClosed) {
 		panic(err)
 	}
+}
 
+// shutdownOnSignal lets the active runs drain and flushes the pending spans before stopping the server.
+func shutdownOnSignal(app *iris.Application, redisLoadGenerator *loadGenerators.RedisLoadGenerator, gracePeriod time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	zkLogger.Info(LogTag, "received signal", sig, ", shutting down")
+
+	redisLoadGenerator.Close(gracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := app.Shutdown(ctx); err != nil {
+		zkLogger.Error(LogTag, "Error while shutting down http server ", err)
+	}
 }
 
-func newApp(cfg config.AppConfigs, redisLoadGenerator *loadGenerators.RedisLoadGenerator) *iris.Application {
+func newApp(cfg config.AppConfigs, redisLoadGenerator *loadGenerators.RedisLoadGenerator, chaosProxy *chaos.Proxy) *iris.Application {
 	app := iris.Default()
 
 	crs := func(ctx iris.Context) {
@@ -87,8 +149,19 @@ func newApp(cfg config.AppConfigs, redisLoadGenerator *loadGenerators.RedisLoadG
 
 	// add other apis
 	configureHealthAPI(app)
+	configureReadinessAPI(app, redisLoadGenerator)
 	configureRedisLoadGeneratorAPI(app, redisLoadGenerator)
 	configureRedisLoadGeneratorAPIForAllPods(app)
+	configureCommandStatsAPI(app, redisLoadGenerator)
+	configureLatencyStatsAPI(app)
+	configureExpiryStatsAPI(app, redisLoadGenerator)
+	configureRunsAPI(app, redisLoadGenerator)
+	if runHistory := redisLoadGenerator.History(); runHistory != nil {
+		configureHistoryAPI(app, runHistory)
+	}
+	if chaosProxy != nil {
+		configureChaosAPI(app, chaosProxy)
+	}
 
 	return app
 }
@@ -100,18 +173,82 @@ func configureHealthAPI(app *iris.Application) {
 	}).Describe("healthcheck")
 }
 
+func configureReadinessAPI(app *iris.Application, redisLoadGenerator *loadGenerators.RedisLoadGenerator) {
+	app.Get("/readyz", func(ctx iris.Context) {
+		stats := redisLoadGenerator.StoreStats()
+		if !stats.Healthy || redisLoadGenerator.IsClosing() {
+			ctx.StatusCode(iris.StatusServiceUnavailable)
+		}
+		err := ctx.JSON(iris.Map{"backend": stats.Backend, "healthy": stats.Healthy, "health": redisLoadGenerator.StoreHealth()})
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}).Describe("readiness check")
+}
+
 func configureRedisLoadGeneratorAPI(app *iris.Application, redisLoadGenerator *loadGenerators.RedisLoadGenerator) {
 	app.Get(redisLoadTestApi, func(ctx iris.Context) {
 
+		pressure := ctx.URLParamBoolDefault("pressure", false)
 		traceCount, err := ctx.URLParamInt("traceCount")
 		if traceCount == 0 || err != nil {
 			traceCount = 2
+			// a pressure run without a trace count writes until the overload is over
+			if pressure {
+				traceCount = 0
+			}
+		}
+		thresholds, err := runThresholds(ctx)
+		if err != nil {
+			ctx.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+
+		parameters := model.RunParameters{
+			Profile:                ctx.URLParam("profile"),
+			TraceCount:             traceCount,
+			Thresholds:             thresholds,
+			ProjectTracesPerSecond: ctx.URLParamFloat64Default("projectTracesPerSecond", 0),
+			WriteMode:              ctx.URLParam("writeMode"),
+			TtlStrategy:            ctx.URLParam("ttlStrategy"),
+			Scenarios:              ctx.URLParamIntDefault("scenarios", 0),
+			Seed:                   ctx.URLParamInt64Default("seed", 0),
+			Verify:                 ctx.URLParam("verify"),
+			Namespace:              ctx.URLParamBoolDefault("namespace", false),
+			PrefillMemory:          ctx.URLParam("prefillMemory"),
+			Pressure:               pressure,
+			OverloadSeconds:        ctx.URLParamIntDefault("overloadSeconds", 0),
+		}
+		if codecs := ctx.URLParam("codecs"); codecs != "" {
+			parameters.Codecs = strings.Split(codecs, ",")
+		}
+		runId, err := redisLoadGenerator.GenerateLoad(parameters)
+		if errors.Is(err, codec.ErrUnknownCodec) || errors.Is(err, handlers.ErrUnknownWriteMode) || errors.Is(err, handlers.ErrWriteModeUnsupported) || errors.Is(err, handlers.ErrUnknownTtlStrategy) || errors.Is(err, handlers.ErrUnknownVerifyMode) || errors.Is(err, loadGenerators.ErrInvalidPrefillTarget) || errors.Is(err, loadGenerators.ErrPressureUnsupported) {
+			ctx.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			ctx.StopWithError(iris.StatusServiceUnavailable, err)
+			return
 		}
 
-		go redisLoadGenerator.GenerateLoad(traceCount)
+		// with wait=true the request blocks until the run is over and fails if the run fails its verdict
+		if ctx.URLParamBoolDefault("wait", false) {
+			runReport, err := redisLoadGenerator.WaitForReport(ctx.Request().Context(), runId)
+			if err != nil {
+				ctx.StopWithError(iris.StatusServiceUnavailable, err)
+				return
+			}
+			if runReport.Verdict != nil && !runReport.Verdict.Passed {
+				ctx.StatusCode(iris.StatusExpectationFailed)
+			}
+			writeReport(ctx, runReport, ctx.URLParamDefault("format", "json"))
+			return
+		}
 
 		ctx.StatusCode(iris.StatusAccepted)
-		_, err = ctx.WriteString("accepted")
+		_, err = ctx.WriteString("accepted runId=" + runId)
 		if err != nil {
 			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
 			return
@@ -119,6 +256,57 @@ func configureRedisLoadGeneratorAPI(app *iris.Application, redisLoadGenerator *l
 	}).Describe("redis load generator")
 }
 
+// runThresholds reads the optional SLO thresholds of a run from the query parameters.
+func runThresholds(ctx iris.Context) (model.RunThresholds, error) {
+	var thresholds model.RunThresholds
+	params := map[string]**float64{
+		"minSpansPerSecond":    &thresholds.MinSpansPerSecond,
+		"maxP99FlushMS":        &thresholds.MaxP99FlushMS,
+		"maxErrorRate":         &thresholds.MaxErrorRate,
+		"maxMemoryGrowthBytes": &thresholds.MaxMemoryGrowthBytes,
+	}
+	for name, threshold := range params {
+		if !ctx.URLParamExists(name) {
+			continue
+		}
+		value, err := ctx.URLParamFloat64(name)
+		if err != nil {
+			return thresholds, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*threshold = &value
+	}
+	return thresholds, nil
+}
+
+func writeReport(ctx iris.Context, runReport report.Report, format string) {
+	var data []byte
+	var err error
+	extension := ".json"
+	switch format {
+	case "junit":
+		data, err = runReport.JUnit()
+		ctx.ContentType("application/xml")
+		extension = ".xml"
+	case "html":
+		data, err = runReport.HTML()
+		ctx.ContentType("text/html")
+		extension = ".html"
+	default:
+		data, err = json.Marshal(runReport)
+		ctx.ContentType("application/json")
+	}
+	if err != nil {
+		ctx.StopWithError(iris.StatusInternalServerError, err)
+		return
+	}
+	if ctx.URLParamBoolDefault("download", false) {
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, runReport.RunId, extension))
+	}
+	if _, err = ctx.Write(data); err != nil {
+		zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+	}
+}
+
 func configureRedisLoadGeneratorAPIForAllPods(app *iris.Application) {
 	app.Get(redisLoadTestApiAllPods, func(ctx iris.Context) {
 
@@ -126,37 +314,31 @@ func configureRedisLoadGeneratorAPIForAllPods(app *iris.Application) {
 		podDetails := k8s.GetPodNameAndIPs(namespace, labelSelector)
 		zkLogger.InfoF(LogTag, "podDetails = %v", podDetails)
 
-		traceCountPerPod, err := ctx.URLParamInt("traceCountPerPod")
-		if traceCountPerPod == 0 || err != nil {
-			traceCount, err1 := ctx.URLParamInt("traceCount")
-			if traceCount == 0 || err1 != nil {
-				traceCountPerPod = 2
-				zkLogger.DebugF(LogTag, "zero or error traceCount = %v, traceCountPerPod=%v", traceCount, traceCountPerPod)
-			} else {
-				traceCountPerPod = traceCount / len(podDetails)
-				zkLogger.DebugF(LogTag, "zero or error traceCount=%v, len(podDetails)=%v, traceCountPerPod = %v", traceCount, len(podDetails), traceCountPerPod)
-			}
-		}
+		query := podRunQuery(ctx.Request().URL.Query(), len(podDetails))
+		zkLogger.DebugF(LogTag, "len(podDetails)=%v, pod query = %v", len(podDetails), query)
 
 		out := "accepted for all pods"
+		statuses := make([]int, 0, len(podDetails))
 		for _, pod := range podDetails {
 
-			url := fmt.Sprintf("http://%s:%d%s?traceCount=%d", pod.IP, port, redisLoadTestApi, traceCountPerPod)
-			zkLogger.InfoF(LogTag, "url = %s", url)
+			podUrl := fmt.Sprintf("http://%s:%d%s?%s", pod.IP, port, redisLoadTestApi, query.Encode())
+			zkLogger.InfoF(LogTag, "url = %s", podUrl)
 
-			out = fmt.Sprintf("%v\nPodName: %s, IP: %s, url:%s", out, pod.Name, pod.IP, url)
+			out = fmt.Sprintf("%v\nPodName: %s, IP: %s, url:%s", out, pod.Name, pod.IP, podUrl)
 
 			//	make http call to the pod
-			response, err1 := common.MakeHTTPCall(url)
+			status, response, err1 := common.MakeHTTPCallWithStatus(podUrl)
 			if err1 != nil {
 				zkLogger.ErrorF(LogTag, "Error in making an http call %v", err1)
+				status = iris.StatusBadGateway
 			}
+			statuses = append(statuses, status)
 
-			out = fmt.Sprintf("%v Status: %s", out, response)
+			out = fmt.Sprintf("%v Status: %d %s", out, status, response)
 		}
 
-		ctx.StatusCode(iris.StatusAccepted)
-		_, err = ctx.WriteString(out)
+		ctx.StatusCode(combinedPodStatus(statuses))
+		_, err := ctx.WriteString(out)
 		if err != nil {
 			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
 			return
@@ -164,3 +346,265 @@ func configureRedisLoadGeneratorAPIForAllPods(app *iris.Application) {
 
 	}).Describe("redis load generator")
 }
+
+// podRunQuery forwards the parameters of a run on all pods to each pod, with the trace count split across the pods.
+// traceCountPerPod takes precedence over traceCount, and a pressure run without either writes until the overload is over.
+func podRunQuery(query url.Values, podCount int) url.Values {
+	podQuery := url.Values{}
+	for name, values := range query {
+		podQuery[name] = append([]string(nil), values...)
+	}
+	podQuery.Del("traceCountPerPod")
+
+	traceCountPerPod, err := strconv.Atoi(query.Get("traceCountPerPod"))
+	if traceCountPerPod == 0 || err != nil {
+		traceCount, err1 := strconv.Atoi(query.Get("traceCount"))
+		if traceCount == 0 || err1 != nil || podCount == 0 {
+			podQuery.Del("traceCount")
+			return podQuery
+		}
+		traceCountPerPod = traceCount / podCount
+	}
+	podQuery.Set("traceCount", strconv.Itoa(traceCountPerPod))
+	return podQuery
+}
+
+// combinedPodStatus is the worst status of the pods, so a pod failing its verdict fails the run on all pods.
+func combinedPodStatus(statuses []int) int {
+	combined := iris.StatusAccepted
+	for i, status := range statuses {
+		if i == 0 || status > combined {
+			combined = status
+		}
+	}
+	return combined
+}
+
+func configureCommandStatsAPI(app *iris.Application, redisLoadGenerator *loadGenerators.RedisLoadGenerator) {
+	app.Get(commandStatsApi, func(ctx iris.Context) {
+		commandStats, ok := redisLoadGenerator.CommandStats()
+		if !ok {
+			ctx.StopWithError(iris.StatusNotImplemented, errors.New("the trace store does not send redis commands"))
+			return
+		}
+		err := ctx.JSON(commandStats)
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}).Describe("redis command and error counts")
+}
+
+func configureLatencyStatsAPI(app *iris.Application) {
+	app.Get(latencyStatsApi, func(ctx iris.Context) {
+		err := ctx.JSON(metrics.Histograms.Snapshot())
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}).Describe("latency and batch size percentiles")
+}
+
+func configureExpiryStatsAPI(app *iris.Application, redisLoadGenerator *loadGenerators.RedisLoadGenerator) {
+	app.Get(expiryStatsApi, func(ctx iris.Context) {
+		err := ctx.JSON(redisLoadGenerator.ExpiryStats())
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}).Describe("expired and evicted trace keys compared with their intended ttl")
+}
+
+func configureRunsAPI(app *iris.Application, redisLoadGenerator *loadGenerators.RedisLoadGenerator) {
+	app.Get(runsApi, func(ctx iris.Context) {
+		err := ctx.JSON(redisLoadGenerator.Runs())
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}).Describe("list of runs")
+
+	app.Get(runsApi+"/{runId}/report", func(ctx iris.Context) {
+		runReport, err := redisLoadGenerator.Report(ctx.Params().Get("runId"))
+		if err != nil {
+			ctx.StopWithError(iris.StatusNotFound, err)
+			return
+		}
+		writeReport(ctx, runReport, ctx.URLParamDefault("format", "json"))
+	}).Describe("run report")
+
+	app.Get(runsApi+"/{runId}/report.html", func(ctx iris.Context) {
+		runReport, err := redisLoadGenerator.Report(ctx.Params().Get("runId"))
+		if err != nil {
+			ctx.StopWithError(iris.StatusNotFound, err)
+			return
+		}
+		writeReport(ctx, runReport, "html")
+	}).Describe("run report page")
+
+	// projects the memory footprint of a run for another load, tracesPerSecond and ttl default to the
+	// ones of the report
+	app.Get(runsApi+"/{runId}/memory", func(ctx iris.Context) {
+		runReport, err := redisLoadGenerator.Report(ctx.Params().Get("runId"))
+		if err != nil {
+			ctx.StopWithError(iris.StatusNotFound, err)
+			return
+		}
+		if runReport.Memory == nil || runReport.Memory.Projection == nil {
+			ctx.StopWithError(iris.StatusNotFound, fmt.Errorf("run %s has no memory analysis", runReport.RunId))
+			return
+		}
+		analysis := *runReport.Memory
+		projection := analysis.Project(
+			ctx.URLParamFloat64Default("tracesPerSecond", analysis.Projection.TracesPerSecond),
+			ctx.URLParamIntDefault("ttl", analysis.Projection.TtlSeconds))
+		analysis.Projection = &projection
+		err = ctx.JSON(analysis)
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}).Describe("run memory footprint and projection")
+
+	// deletes the keys of a namespaced run, throttled to keysPerSecond if given
+	app.Delete(runsApi+"/{runId}/keys", func(ctx iris.Context) {
+		result, err := redisLoadGenerator.CleanupRun(ctx.Request().Context(), ctx.Params().Get("runId"), ctx.URLParamIntDefault("keysPerSecond", 0))
+		switch {
+		case errors.Is(err, loadGenerators.ErrUnknownRun):
+			ctx.StopWithError(iris.StatusNotFound, err)
+			return
+		case errors.Is(err, loadGenerators.ErrRunActive) || errors.Is(err, loadGenerators.ErrRunNotNamespaced):
+			ctx.StopWithError(iris.StatusConflict, err)
+			return
+		case errors.Is(err, handlers.ErrCleanupUnsupported):
+			ctx.StopWithError(iris.StatusNotImplemented, err)
+			return
+		case err != nil:
+			ctx.StopWithError(iris.StatusServiceUnavailable, err)
+			return
+		}
+		err = ctx.JSON(result)
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}).Describe("delete run keys")
+
+	app.Get(runsApi+"/{runId}/junit", func(ctx iris.Context) {
+		runReport, err := redisLoadGenerator.Report(ctx.Params().Get("runId"))
+		if err != nil {
+			ctx.StopWithError(iris.StatusNotFound, err)
+			return
+		}
+		writeReport(ctx, runReport, "junit")
+	}).Describe("run verdict as JUnit XML")
+}
+
+func configureHistoryAPI(app *iris.Application, runHistory *history.Store) {
+	writeJSON := func(ctx iris.Context, value interface{}) {
+		err := ctx.JSON(value)
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+		}
+	}
+
+	// filters: status, profile, passed, since and until (RFC 3339) and limit
+	app.Get(historyApi, func(ctx iris.Context) {
+		filter := history.Filter{
+			Status:  report.Status(ctx.URLParam("status")),
+			Profile: ctx.URLParam("profile"),
+			Limit:   ctx.URLParamIntDefault("limit", 100),
+		}
+		if ctx.URLParamExists("passed") {
+			passed := ctx.URLParamBoolDefault("passed", false)
+			filter.Passed = &passed
+		}
+		var err error
+		for param, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if ctx.URLParamExists(param) {
+				if *value, err = time.Parse(time.RFC3339, ctx.URLParam(param)); err != nil {
+					ctx.StopWithError(iris.StatusBadRequest, fmt.Errorf("invalid %s: %w", param, err))
+					return
+				}
+			}
+		}
+		summaries, err := runHistory.List(filter)
+		if err != nil {
+			ctx.StopWithError(iris.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(ctx, summaries)
+	}).Describe("past runs")
+
+	app.Get(historyApi+"/compare", func(ctx iris.Context) {
+		base, err := runHistory.Get(ctx.URLParam("base"))
+		if err != nil {
+			ctx.StopWithError(iris.StatusNotFound, fmt.Errorf("base: %w", err))
+			return
+		}
+		other, err := runHistory.Get(ctx.URLParam("other"))
+		if err != nil {
+			ctx.StopWithError(iris.StatusNotFound, fmt.Errorf("other: %w", err))
+			return
+		}
+		writeJSON(ctx, history.Compare(base, other))
+	}).Describe("compare two runs")
+
+	app.Get(historyApi+"/trend", func(ctx iris.Context) {
+		metric := ctx.URLParamDefault("metric", "spansPerSecond")
+		points, err := runHistory.Trend(ctx.URLParam("profile"), metric, ctx.URLParamIntDefault("limit", 20))
+		if err != nil {
+			ctx.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+		writeJSON(ctx, points)
+	}).Describe("metric trend across the runs of a profile")
+
+	app.Get(historyApi+"/{runId}", func(ctx iris.Context) {
+		runReport, err := runHistory.Get(ctx.Params().Get("runId"))
+		if err != nil {
+			ctx.StopWithError(iris.StatusNotFound, err)
+			return
+		}
+		writeReport(ctx, runReport, ctx.URLParamDefault("format", "json"))
+	}).Describe("past run report")
+}
+
+func configureChaosAPI(app *iris.Application, chaosProxy *chaos.Proxy) {
+	writeStatus := func(ctx iris.Context) {
+		err := ctx.JSON(chaosProxy.Status())
+		if err != nil {
+			zkLogger.ErrorF(LogTag, "Unable to write response %v", err)
+			return
+		}
+	}
+
+	app.Get(chaosApi, writeStatus).Describe("chaos proxy status")
+
+	// applies the fault in the request body, for durationMS milliseconds if given
+	app.Put(chaosApi+"/fault", func(ctx iris.Context) {
+		var fault chaos.Fault
+		if err := ctx.ReadJSON(&fault); err != nil {
+			ctx.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+		durationMS := ctx.URLParamIntDefault("durationMS", 0)
+		chaosProxy.SetFault(fault, time.Duration(durationMS)*time.Millisecond)
+		writeStatus(ctx)
+	}).Describe("set chaos fault")
+
+	app.Delete(chaosApi+"/fault", func(ctx iris.Context) {
+		chaosProxy.ClearFault()
+		writeStatus(ctx)
+	}).Describe("clear chaos fault")
+
+	app.Put(chaosApi+"/schedule", func(ctx iris.Context) {
+		var schedule []chaos.ScheduledFault
+		if err := ctx.ReadJSON(&schedule); err != nil {
+			ctx.StopWithError(iris.StatusBadRequest, err)
+			return
+		}
+		chaosProxy.RunSchedule(schedule)
+		writeStatus(ctx)
+	}).Describe("run chaos schedule")
+}
@@ -0,0 +1,1116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"redis-test/config"
+	"redis-test/handlers"
+	"redis-test/internal/codec"
+	"redis-test/internal/embedded"
+	loadGenerators "redis-test/internal/load-generators"
+	"redis-test/internal/metrics"
+	"redis-test/internal/report"
+	"redis-test/model"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/redis/go-redis/v9"
+	zkLogsConfig "github.com/zerok-ai/zk-utils-go/logs/config"
+	storage "github.com/zerok-ai/zk-utils-go/storage/redis/config"
+)
+
+const (
+	testTracesDB = 3
+	testTtl      = 300
+)
+
+type testEnv struct {
+	cfg         config.AppConfigs
+	redisServer *miniredis.Miniredis
+	generator   *loadGenerators.RedisLoadGenerator
+	server      *httptest.Server
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	return newTestEnvWith(t, func(*config.AppConfigs) {})
+}
+
+// newTestEnvWith lets a test change the config before the application is started.
+func newTestEnvWith(t *testing.T, configure func(cfg *config.AppConfigs)) *testEnv {
+	t.Helper()
+
+	cfg := config.AppConfigs{
+		Redis: storage.RedisConfig{
+			Password: "test-password",
+			DBs:      map[string]int{"traces": testTracesDB},
+		},
+		Traces: config.TraceConfig{
+			Backend:               "redis",
+			SyncDurationMS:        1,
+			SyncBatchSize:         30,
+			Ttl:                   testTtl,
+			HealthCheckIntervalMS: 100,
+			QueueCapacity:         100,
+			QueuePolicy:           "block",
+		},
+		Reports:    config.ReportsConfig{MaxRuns: 10, DrainTimeoutSeconds: 5},
+		RedisStats: config.RedisStatsConfig{Enabled: true, SampleIntervalMS: 100},
+		LogsConfig: zkLogsConfig.LogsConfig{Level: "ERROR"},
+	}
+	configure(&cfg)
+
+	redisServer, err := embedded.StartRedisServer(&cfg.Redis)
+	if err != nil {
+		t.Fatalf("unable to start embedded redis: %v", err)
+	}
+
+	generator, err := loadGenerators.NewRedisLoadGenerator(cfg)
+	if err != nil {
+		redisServer.Close()
+		t.Fatalf("unable to create load generator: %v", err)
+	}
+
+	app := newApp(cfg, generator, nil)
+	if err = app.Build(); err != nil {
+		t.Fatalf("unable to build app: %v", err)
+	}
+
+	env := &testEnv{cfg: cfg, redisServer: redisServer, generator: generator, server: httptest.NewServer(app)}
+	t.Cleanup(func() {
+		env.server.Close()
+		env.generator.Close(time.Second)
+		env.redisServer.Close()
+	})
+	return env
+}
+
+func (env *testEnv) get(t *testing.T, path string) (int, string) {
+	t.Helper()
+	return env.do(t, http.MethodGet, path)
+}
+
+func (env *testEnv) do(t *testing.T, method string, path string) (int, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, env.server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("unable to create %s %s: %v", method, path, err)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("unable to read response of %s: %v", path, err)
+	}
+	return response.StatusCode, string(body)
+}
+
+// waitForTraces waits until the traces DB holds the expected number of traces with all their spans.
+func (env *testEnv) waitForTraces(t *testing.T, traceCount int) []string {
+	t.Helper()
+	db := env.redisServer.DB(testTracesDB)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keys := db.Keys()
+		complete := len(keys) == traceCount
+		for _, key := range keys {
+			fields, _ := db.HKeys(key)
+			complete = complete && len(fields) == 10
+		}
+		if complete {
+			return keys
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d complete traces, found %d keys", traceCount, len(keys))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGenerateLoadWritesTraces(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, body := env.get(t, redisLoadTestApi+"?traceCount=5")
+	if status != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, status, body)
+	}
+
+	keys := env.waitForTraces(t, 5)
+	db := env.redisServer.DB(testTracesDB)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "00-aaaa") {
+			t.Errorf("unexpected trace key %s", key)
+		}
+		if ttl := db.TTL(key); ttl != testTtl*time.Second {
+			t.Errorf("expected ttl of %s to be %ds, got %s", key, testTtl, ttl)
+		}
+
+		// the spans of a trace form a chain starting at the default parent
+		parents := make(map[string]string)
+		fields, _ := db.HKeys(key)
+		for _, spanId := range fields {
+			var span model.OTelSpanDetails
+			if err := json.Unmarshal([]byte(db.HGet(key, spanId)), &span); err != nil {
+				t.Fatalf("span %s of %s is not valid json: %v", spanId, key, err)
+			}
+			parents[spanId] = span.ParentSpanId
+		}
+		roots := 0
+		for _, parent := range parents {
+			if parent == model.DefaultParentSpanId {
+				roots++
+			} else if _, ok := parents[parent]; !ok {
+				t.Errorf("span of %s has unknown parent %s", key, parent)
+			}
+		}
+		if roots != 1 {
+			t.Errorf("expected a single root span in %s, found %d", key, roots)
+		}
+	}
+}
+
+func TestMetricsCountWrites(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.get(t, redisLoadTestApi+"?traceCount=3")
+	env.waitForTraces(t, 3)
+
+	status, body := env.get(t, "/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	for _, metric := range []string{"zk_db_test_spans_generated_total", "zk_db_test_spans_written_total",
+		"zk_db_test_bytes_written_total", "zk_db_test_flushes_total", "zk_db_test_pipeline_exec_seconds_bucket",
+		"zk_db_test_redis_server"} {
+		if !strings.Contains(body, metric+`{`) {
+			t.Errorf("%s missing from metrics", metric)
+		}
+	}
+	if strings.Contains(body, "http_requests_total") {
+		t.Errorf("redis writes must not be counted as http requests")
+	}
+
+	status, body = env.get(t, commandStatsApi)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	var stats struct {
+		Executed map[string]int64 `json:"executed"`
+		ByClass  map[string]int64 `json:"byClass"`
+	}
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatalf("unable to decode command stats: %v", err)
+	}
+	if stats.Executed["hmset"] != 30 || stats.Executed["expire"] != 30 {
+		t.Errorf("expected 30 hmset and expire commands, got %v", stats.Executed)
+	}
+	if len(stats.ByClass) != 0 {
+		t.Errorf("expected no failed commands, got %v", stats.ByClass)
+	}
+
+	status, body = env.get(t, latencyStatsApi)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	var histograms map[string]metrics.HistogramSnapshot
+	if err := json.Unmarshal([]byte(body), &histograms); err != nil {
+		t.Fatalf("unable to decode latency stats: %v", err)
+	}
+	for _, name := range []string{metrics.HistogramPipelineExec, metrics.HistogramFlushBatchSize, metrics.HistogramSpanAck} {
+		if histograms[name].Count == 0 {
+			t.Errorf("expected %s to be recorded, got %v", name, histograms)
+		}
+	}
+}
+
+// waitForReport polls the report of a run until the run is over.
+func (env *testEnv) waitForReport(t *testing.T, runId string) report.Report {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, body := env.get(t, runsApi+"/"+runId+"/report")
+		if status != http.StatusOK {
+			t.Fatalf("expected status %d for the report, got %d: %s", http.StatusOK, status, body)
+		}
+		var runReport report.Report
+		if err := json.Unmarshal([]byte(body), &runReport); err != nil {
+			t.Fatalf("unable to decode report: %v", err)
+		}
+		if runReport.Status != report.StatusRunning {
+			return runReport
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run %s did not finish", runId)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, body := env.get(t, redisLoadTestApi+"?traceCount=4")
+	runId := strings.TrimPrefix(body, "accepted runId=")
+
+	runReport := env.waitForReport(t, runId)
+	if runReport.Status != report.StatusCompleted {
+		t.Fatalf("expected the run to complete, got %s", runReport.Status)
+	}
+	totals := runReport.Totals
+	if totals.TracesGenerated != 4 || totals.TracesWritten != 4 || totals.SpansGenerated != 40 || totals.SpansWritten != 40 {
+		t.Errorf("unexpected totals %+v", totals)
+	}
+	if totals.BytesWritten == 0 || runReport.SpansPerSecond == 0 || len(runReport.Timeline) == 0 {
+		t.Errorf("expected bytes, throughput and a timeline, got %+v", runReport)
+	}
+	if runReport.Latency[metrics.HistogramSpanAck].Count != 40 {
+		t.Errorf("expected 40 acknowledged spans in the latency histogram, got %v", runReport.Latency)
+	}
+	if len(runReport.Errors) != 0 {
+		t.Errorf("expected no errors, got %v", runReport.Errors)
+	}
+	redisConfig := runReport.Config["Redis"].(map[string]interface{})
+	if redisConfig["Password"] != "REDACTED" {
+		t.Errorf("expected the redis password to be redacted, got %v", redisConfig["Password"])
+	}
+	if _, ok := runReport.RedisDelta["connected_clients"]; !ok {
+		t.Errorf("expected redis deltas, got %v", runReport.RedisDelta)
+	}
+	if runReport.Server == nil || len(runReport.Server.Samples) == 0 {
+		t.Errorf("expected server samples in the report, got %+v", runReport.Server)
+	} else if _, ok := runReport.Server.Samples[0].Fields["connected_clients"]; !ok {
+		t.Errorf("expected connected_clients to be sampled, got %v", runReport.Server.Samples[0].Fields)
+	}
+
+	memory := runReport.Memory
+	if memory == nil || memory.SampledTraces != 4 || memory.MissingTraces != 0 || memory.BytesPerSpan == 0 {
+		t.Fatalf("expected the 4 traces to be measured, got %+v", memory)
+	}
+	if memory.OverheadRatio <= 0 || memory.Projection == nil || memory.Projection.TtlSeconds != testTtl {
+		t.Errorf("unexpected memory analysis %+v", memory)
+	}
+
+	status, body := env.get(t, runsApi+"/"+runId+"/memory?tracesPerSecond=10&ttl=60")
+	var projected struct {
+		Projection struct {
+			LiveTraces float64 `json:"liveTraces"`
+		} `json:"projection"`
+	}
+	if err := json.Unmarshal([]byte(body), &projected); err != nil || status != http.StatusOK {
+		t.Fatalf("unexpected memory projection %d: %s", status, body)
+	}
+	if projected.Projection.LiveTraces != 600 {
+		t.Errorf("expected 600 live traces, got %v", projected.Projection.LiveTraces)
+	}
+
+	status, body = env.get(t, runsApi)
+	if status != http.StatusOK || !strings.Contains(body, runId) {
+		t.Errorf("expected the run to be listed, got %d: %s", status, body)
+	}
+	if status, _ = env.get(t, runsApi+"/unknown/report"); status != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown run, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestRunCodecs(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, body := env.get(t, redisLoadTestApi+"?traceCount=4&wait=true&codecs=json,msgpack%2Bzstd")
+	var runReport report.Report
+	if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", status, body)
+	}
+	if len(runReport.Codecs) != 2 {
+		t.Fatalf("expected stats for both codecs, got %+v", runReport.Codecs)
+	}
+	for _, stats := range runReport.Codecs {
+		if stats.Spans != 20 || stats.StoredBytesPerSpan == 0 || stats.MemoryBytesPerSpan == 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	}
+
+	// every trace is stored with one of the codecs and decodes back to its spans
+	jsonCodec, _ := codec.New(codec.JSON, nil)
+	compressedCodec, _ := codec.New("msgpack+zstd", nil)
+	decoded := map[string]int{}
+	db := env.redisServer.DB(testTracesDB)
+	for _, key := range db.Keys() {
+		fields, _ := db.HKeys(key)
+		for _, spanId := range fields {
+			value := []byte(db.HGet(key, spanId))
+			if _, err := jsonCodec.Decode(value); err == nil {
+				decoded[codec.JSON]++
+			} else if span, err := compressedCodec.Decode(value); err == nil && span.ParentSpanId != "" {
+				decoded["msgpack+zstd"]++
+			}
+		}
+	}
+	if decoded[codec.JSON] != 20 || decoded["msgpack+zstd"] != 20 {
+		t.Errorf("expected 20 spans per codec, decoded %v", decoded)
+	}
+
+	if status, _ = env.get(t, redisLoadTestApi+"?codecs=xml"); status != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown codec, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestRunLayouts(t *testing.T) {
+	for _, layout := range handlers.TraceLayouts() {
+		t.Run(layout, func(t *testing.T) {
+			env := newTestEnvWith(t, func(cfg *config.AppConfigs) {
+				cfg.Traces.Layout = layout
+			})
+
+			status, body := env.get(t, redisLoadTestApi+"?traceCount=3&wait=true&codecs=json,protobuf")
+			var runReport report.Report
+			if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+				t.Fatalf("unexpected response %d: %s", status, body)
+			}
+			if layout != handlers.LayoutHash && !strings.Contains(runReport.Profile, "/layout="+layout+"/") {
+				t.Errorf("expected the layout in the profile, got %q", runReport.Profile)
+			}
+			reads := runReport.Reads
+			if reads == nil || reads.Traces != 3 || reads.Spans != 30 || !reads.Passed() {
+				t.Errorf("expected every sampled trace to read back, got %+v", reads)
+			} else if reads.FullScan != (layout == handlers.LayoutStreamBucket) {
+				t.Errorf("expected only the stream-bucket reads to be full scans, got %+v", reads)
+			}
+			if runReport.Latency["trace_read"].Count != 3 {
+				t.Errorf("expected 3 trace reads, got %+v", runReport.Latency["trace_read"])
+			}
+			if runReport.Memory == nil || runReport.Memory.MissingTraces != 0 || runReport.Memory.BytesPerSpan == 0 {
+				t.Errorf("expected the memory of the traces, got %+v", runReport.Memory)
+			}
+		})
+	}
+}
+
+func TestRunWriteModes(t *testing.T) {
+	env := newTestEnv(t)
+	db := env.redisServer.DB(testTracesDB)
+	client := redis.NewClient(&redis.Options{Addr: env.redisServer.Addr(), Password: env.cfg.Redis.Password, DB: testTracesDB})
+	defer client.Close()
+	emulateFunctions(t, env.redisServer, client)
+
+	run := func(writeMode string) report.Report {
+		t.Helper()
+		status, body := env.get(t, redisLoadTestApi+"?traceCount=4&wait=true&scenarios=2&writeMode="+writeMode)
+		var runReport report.Report
+		if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d: %s", writeMode, status, body)
+		}
+		if runReport.Totals.SpansWritten != 40 || runReport.Totals.SpansFailed != 0 {
+			t.Errorf("%s: expected 40 spans written, got %+v", writeMode, runReport.Totals)
+		}
+		return runReport
+	}
+	// shape describes what a run left in the DB
+	shape := func() string {
+		var lines []string
+		for _, key := range db.Keys() {
+			if strings.HasPrefix(key, "scenario:") {
+				members, _ := db.Members(key)
+				lines = append(lines, fmt.Sprintf("%s %d traces ttl %v", key, len(members), db.TTL(key)))
+				continue
+			}
+			fields, _ := db.HKeys(key)
+			lines = append(lines, fmt.Sprintf("trace %d spans ttl %v", len(fields), db.TTL(key)))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n")
+	}
+	// every mode writes the 4 traces to hashes of their 10 spans and indexes them in the sets of their
+	// scenarios, all of them with the configured ttl
+	ttl := time.Duration(testTtl) * time.Second
+	expectedShape := strings.Join([]string{
+		fmt.Sprintf("scenario:1:traces 2 traces ttl %v", ttl),
+		fmt.Sprintf("scenario:2:traces 2 traces ttl %v", ttl),
+		fmt.Sprintf("trace 10 spans ttl %v", ttl),
+		fmt.Sprintf("trace 10 spans ttl %v", ttl),
+		fmt.Sprintf("trace 10 spans ttl %v", ttl),
+		fmt.Sprintf("trace 10 spans ttl %v", ttl),
+	}, "\n")
+
+	// HMSET and EXPIRE for the trace and SADD and EXPIRE for the scenario of every span, plus MULTI and
+	// EXEC once or twice per trace depending on how the traces were split over the flushes
+	commandsPerSpan := map[string]func(float64) bool{
+		"pipeline": func(commands float64) bool { return commands == 4 },
+		"direct":   func(commands float64) bool { return commands == 4 },
+		"tx":       func(commands float64) bool { return commands >= 4.2 && commands <= 4.4 },
+		"lua":      func(commands float64) bool { return commands < 1 },
+		"function": func(commands float64) bool { return commands < 1 },
+	}
+	for _, writeMode := range []string{"pipeline", "tx", "direct", "lua", "function"} {
+		env.redisServer.FlushAll()
+		runReport := run(writeMode)
+		if !commandsPerSpan[writeMode](runReport.CommandsPerSpan) {
+			t.Errorf("%s: unexpected %v commands per span", writeMode, runReport.CommandsPerSpan)
+		}
+		if writeMode != "pipeline" && !strings.Contains(runReport.Profile, "/writeMode="+writeMode) {
+			t.Errorf("%s: expected the write mode in the profile, got %s", writeMode, runReport.Profile)
+		}
+		if actual := shape(); actual != expectedShape {
+			t.Errorf("%s: expected the data\n%s\ngot\n%s", writeMode, expectedShape, actual)
+		}
+	}
+
+	// the scripts are loaded again once the server lost them
+	if err := client.ScriptFlush(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	run("lua")
+	_, body := env.get(t, commandStatsApi)
+	if !strings.Contains(body, `"noscript":`) {
+		t.Errorf("expected the NOSCRIPT errors in the command stats, got %s", body)
+	}
+
+	if status, body := env.get(t, redisLoadTestApi+"?writeMode=bulk"); status != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown write mode, got %d: %s", http.StatusBadRequest, status, body)
+	}
+}
+
+// registeredFunction matches the registration of a function in a library, with its name and the lua
+// function it calls.
+var registeredFunction = regexp.MustCompile(`(?m)^redis\.register_function\('(\w+)', (\w+)\)$`)
+
+// emulateFunctions runs the redis functions on miniredis, which has no FUNCTION and FCALL. The loaded
+// library is run as a script with EVAL on client, which returns the result of the called function.
+func emulateFunctions(t *testing.T, redisServer *miniredis.Miniredis, client *redis.Client) {
+	t.Helper()
+	var functions sync.Map
+	redisServer.Server().SetPreHook(func(peer *server.Peer, cmd string, args ...string) bool {
+		switch cmd {
+		case "FUNCTION":
+			if len(args) == 0 || !strings.EqualFold(args[0], "LOAD") {
+				return false
+			}
+			header, code, _ := strings.Cut(args[len(args)-1], "\n")
+			for _, match := range registeredFunction.FindAllStringSubmatch(code, -1) {
+				script := registeredFunction.ReplaceAllString(code, "") + "return " + match[2] + "(KEYS, ARGV)"
+				functions.Store(match[1], script)
+			}
+			peer.WriteBulk(strings.TrimPrefix(header, "#!lua name="))
+			return true
+		case "FCALL":
+			script, ok := functions.Load(args[0])
+			numKeys, err := strconv.Atoi(args[1])
+			if !ok || err != nil {
+				peer.WriteError("ERR Function not found")
+				return true
+			}
+			var values []interface{}
+			for _, value := range args[2+numKeys:] {
+				values = append(values, value)
+			}
+			result, err := client.Eval(context.Background(), script.(string), args[2:2+numKeys], values...).Int64()
+			if err != nil {
+				peer.WriteError(err.Error())
+				return true
+			}
+			peer.WriteInt(int(result))
+			return true
+		}
+		return false
+	})
+}
+
+func TestRunTtlStrategies(t *testing.T) {
+	env := newTestEnvWith(t, func(cfg *config.AppConfigs) {
+		cfg.Traces.TtlJitterPercent = 10
+	})
+	db := env.redisServer.DB(testTracesDB)
+
+	run := func(query string) report.Report {
+		t.Helper()
+		env.redisServer.FlushAll()
+		status, body := env.get(t, redisLoadTestApi+"?traceCount=4&wait=true&scenarios=2&"+query)
+		var runReport report.Report
+		if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d: %s", query, status, body)
+		}
+		if runReport.Totals.SpansWritten != 40 || runReport.Expiry == nil {
+			t.Fatalf("%s: expected 40 spans written and an expiry check, got %+v", query, runReport)
+		}
+		return runReport
+	}
+	traceTtls := func() map[time.Duration]int {
+		ttls := make(map[time.Duration]int)
+		for _, key := range db.Keys() {
+			if !strings.HasPrefix(key, "scenario:") {
+				ttls[db.TTL(key)]++
+			}
+		}
+		return ttls
+	}
+
+	// every span expires its trace and its scenario index
+	perSpan := run("ttlStrategy=per-span")
+	if expiry := perSpan.Expiry; expiry.Commands != 80 || expiry.Skipped != 0 || !expiry.Bounded() || expiry.MaxTtlSeconds != testTtl {
+		t.Errorf("per-span: unexpected expiry %+v", expiry)
+	}
+	for _, strategy := range []string{"expireat", "nx", "jitter"} {
+		runReport := run("ttlStrategy=" + strategy)
+		expiry := runReport.Expiry
+		if expiry.Strategy != strategy || expiry.Commands != 80 || !expiry.Bounded() || expiry.Traces == 0 {
+			t.Errorf("%s: unexpected expiry %+v", strategy, expiry)
+		}
+		if !strings.Contains(runReport.Profile, "/ttl="+strategy) {
+			t.Errorf("%s: expected the ttl strategy in the profile, got %s", strategy, runReport.Profile)
+		}
+		if strategy != "jitter" && expiry.MaxTtlSeconds > testTtl {
+			t.Errorf("%s: expected a ttl of at most %ds, got %+v", strategy, testTtl, expiry)
+		}
+	}
+	if ttls := traceTtls(); len(ttls) < 2 {
+		t.Errorf("jitter: expected the traces to expire at different times, got %v", ttls)
+	}
+
+	perBatch := run("ttlStrategy=per-batch")
+	if expiry := perBatch.Expiry; expiry.SavedRatio < 0.5 || expiry.Commands+expiry.Skipped != 80 || !expiry.Bounded() {
+		t.Errorf("per-batch: expected most expiry commands saved, got %+v", expiry)
+	}
+	if perBatch.CommandsPerSpan >= perSpan.CommandsPerSpan {
+		t.Errorf("per-batch: expected fewer commands per span than %v, got %v", perSpan.CommandsPerSpan, perBatch.CommandsPerSpan)
+	}
+
+	none := run("ttlStrategy=none")
+	if expiry := none.Expiry; expiry.Commands != 0 || expiry.SavedRatio != 1 || expiry.Bounded() || expiry.Unbounded != expiry.Traces {
+		t.Errorf("none: unexpected expiry %+v", expiry)
+	}
+	if ttls := traceTtls(); ttls[0] != 4 {
+		t.Errorf("none: expected 4 traces without a ttl, got %v", ttls)
+	}
+
+	for _, query := range []string{"ttlStrategy=sometimes", "ttlStrategy=nx&writeMode=lua", "ttlStrategy=per-batch&writeMode=direct"} {
+		if status, body := env.get(t, redisLoadTestApi+"?"+query); status != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d: %s", query, http.StatusBadRequest, status, body)
+		}
+	}
+}
+
+func TestRunVerification(t *testing.T) {
+	env := newTestEnv(t)
+	db := env.redisServer.DB(testTracesDB)
+
+	run := func(query string) report.Report {
+		t.Helper()
+		status, body := env.get(t, redisLoadTestApi+"?traceCount=3&wait=true&seed=42&"+query)
+		var runReport report.Report
+		if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d: %s", query, status, body)
+		}
+		return runReport
+	}
+	var expected []handlers.ExpectedTrace
+	handlers.ExpectedTraces(model.RunParameters{TraceCount: 3, SpansPerTrace: 10, Seed: 42}, func(trace handlers.ExpectedTrace) bool {
+		expected = append(expected, trace)
+		return true
+	})
+
+	// the seed generates the same ids again
+	verified := run("verify=all")
+	if reads := verified.Reads; reads == nil || reads.Mode != "all" || reads.Seed != 42 || reads.Traces != 3 || reads.Spans != 30 || reads.TtlChecked != 3 || !reads.Passed() {
+		t.Fatalf("expected every trace to verify, got %+v", verified.Reads)
+	}
+	for _, trace := range expected {
+		if spanIds, _ := db.HKeys(trace.TraceId); len(spanIds) != 10 {
+			t.Errorf("expected the 10 spans of trace %s, got %v", trace.TraceId, spanIds)
+		}
+	}
+
+	// a corrupt span and an orphaned span left by another writer, and a ttl nx does not replace
+	env.redisServer.FlushAll()
+	db.HSet(expected[0].TraceId, "ffffffffffffffff", "garbage")
+	db.HSet(expected[1].TraceId, "eeeeeeeeeeeeeeee", `{"parent_span_id":"dddddddddddddddd"}`)
+	db.HSet(expected[2].TraceId, "cccccccccccccccc", `{"parent_span_id":"0000000000000000"}`)
+	db.SetTTL(expected[2].TraceId, 10*testTtl*time.Second)
+	reads := run("verify=all&ttlStrategy=nx").Reads
+	if reads == nil || reads.Passed() || reads.Incomplete != 0 || reads.CorruptSpans != 1 || reads.OrphanedSpans != 1 || reads.UnexpectedSpans != 3 || reads.Unrooted != 2 || reads.TtlOutOfRange != 1 {
+		t.Fatalf("expected the problems to be counted, got %+v", reads)
+	}
+	problems := make(map[string]int)
+	for _, problem := range reads.Problems {
+		problems[problem.Problem]++
+	}
+	if reads.ProblemCount != len(reads.Problems) || problems[model.ProblemCorrupt] != 1 || problems[model.ProblemOrphaned] != 1 || problems[model.ProblemTtl] != 1 {
+		t.Errorf("expected the problems to be listed, got %+v", reads.Problems)
+	}
+
+	if off := run("verify=off"); off.Reads != nil {
+		t.Errorf("expected no verification, got %+v", off.Reads)
+	}
+	if status, body := env.get(t, redisLoadTestApi+"?verify=never"); status != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, status, body)
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	env := newTestEnv(t)
+	db := env.redisServer.DB(testTracesDB)
+
+	run := func(query string) report.Report {
+		t.Helper()
+		status, body := env.get(t, redisLoadTestApi+"?traceCount=3&wait=true&scenarios=2&"+query)
+		var runReport report.Report
+		if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d: %s", query, status, body)
+		}
+		return runReport
+	}
+	namespaced := run("namespace=true")
+	shared := run("namespace=false")
+
+	prefix := "run:" + namespaced.RunId + ":"
+	if namespaced.Parameters.KeyPrefix != prefix || !namespaced.Reads.Passed() {
+		t.Fatalf("expected the run to write under %s, got %+v", prefix, namespaced.Parameters)
+	}
+	var runKeys []string
+	for _, key := range db.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			runKeys = append(runKeys, key)
+		}
+	}
+	// 3 traces and 2 scenario indexes
+	if len(runKeys) != 5 || len(db.Keys()) != 10 {
+		t.Fatalf("expected 5 keys of each run, got %v", db.Keys())
+	}
+
+	start := time.Now()
+	status, body := env.do(t, http.MethodDelete, runsApi+"/"+namespaced.RunId+"/keys?keysPerSecond=10")
+	var result handlers.CleanupResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil || status != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", status, body)
+	}
+	if result.KeyPrefix != prefix || result.Scanned != 5 || result.Removed != 5 {
+		t.Errorf("expected the 5 keys of the run removed, got %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("expected the deletion throttled to 10 keys/s, took %v", elapsed)
+	}
+	for _, key := range db.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			t.Errorf("expected key %s to be deleted", key)
+		}
+	}
+	if remaining := len(db.Keys()); remaining != 5 {
+		t.Errorf("expected the 5 keys of the other run to remain, got %v", db.Keys())
+	}
+
+	for path, expected := range map[string]int{
+		runsApi + "/" + shared.RunId + "/keys": http.StatusConflict,
+		runsApi + "/unknown/keys":              http.StatusNotFound,
+	} {
+		if status, body := env.do(t, http.MethodDelete, path); status != expected {
+			t.Errorf("%s: expected status %d, got %d: %s", path, expected, status, body)
+		}
+	}
+}
+
+func TestRunCleanupRemovesDirectWrites(t *testing.T) {
+	env := newTestEnv(t)
+	db := env.redisServer.DB(testTracesDB)
+
+	status, body := env.get(t, redisLoadTestApi+"?traceCount=3&wait=true&scenarios=2&namespace=true&writeMode=direct")
+	var runReport report.Report
+	if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", status, body)
+	}
+	prefix := runReport.Parameters.KeyPrefix
+	if !runReport.Reads.Passed() || len(db.Keys()) != 5 {
+		t.Fatalf("expected 3 traces and 2 scenario indexes read back, got %v", db.Keys())
+	}
+	for _, key := range db.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			t.Errorf("expected key %s to start with %s", key, prefix)
+		}
+	}
+
+	if status, body = env.do(t, http.MethodDelete, runsApi+"/"+runReport.RunId+"/keys"); status != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", status, body)
+	}
+	if keys := db.Keys(); len(keys) != 0 {
+		t.Errorf("expected the direct writes to be deleted with the run, got %v", keys)
+	}
+}
+
+func TestRunPrefill(t *testing.T) {
+	env := newTestEnv(t)
+	db := env.redisServer.DB(testTracesDB)
+	// miniredis has no memory section in INFO, every key of the traces db counts for 1000 bytes
+	var maxMemory atomic.Int64
+	maxMemory.Store(100000)
+	env.redisServer.Server().SetPreHook(func(peer *server.Peer, cmd string, args ...string) bool {
+		if cmd != "INFO" {
+			return false
+		}
+		peer.WriteBulk(fmt.Sprintf("# Memory\r\nused_memory:%d\r\nmaxmemory:%d\r\n", len(db.Keys())*1000, maxMemory.Load()))
+		return true
+	})
+
+	run := func(query string) report.Report {
+		t.Helper()
+		env.redisServer.FlushAll()
+		status, body := env.get(t, redisLoadTestApi+"?traceCount=3&wait=true&"+query)
+		var runReport report.Report
+		if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d: %s", query, status, body)
+		}
+		return runReport
+	}
+
+	// the first chunk of 100 traces reaches half of maxmemory
+	percent := run("prefillMemory=50%25")
+	if prefill := percent.Prefill; prefill == nil || !prefill.Reached || prefill.TargetBytes != 50000 || prefill.MaxMemoryBytes != 100000 || prefill.Traces != 100 || prefill.Spans != 1000 {
+		t.Fatalf("expected a prefill of 100 traces, got %+v", percent.Prefill)
+	}
+	if percent.Totals.TracesWritten != 3 || percent.Totals.SpansGenerated != 30 || percent.RedisDelta["used_memory"] != 3000 || percent.Reads == nil || percent.Reads.Traces != 3 {
+		t.Errorf("expected only the 3 measured traces in the statistics, got %+v, used memory delta %v", percent.Totals, percent.RedisDelta["used_memory"])
+	}
+	if keys := len(db.Keys()); keys != 103 || !strings.Contains(percent.Profile, "/prefill=50%") {
+		t.Errorf("expected 103 traces and the prefill in the profile, got %d keys and %s", keys, percent.Profile)
+	}
+
+	// the second chunk is sized with the growth per trace of the first
+	absolute := run("prefillMemory=200000")
+	if prefill := absolute.Prefill; !prefill.Reached || prefill.Traces != 201 || prefill.UsedMemoryAfter != 201000 {
+		t.Errorf("expected a prefill of 201 traces, got %+v", prefill)
+	}
+
+	maxMemory.Store(0)
+	if prefill := run("prefillMemory=50%25").Prefill; prefill.Reached || !strings.Contains(prefill.Error, "maxmemory") {
+		t.Errorf("expected the prefill to fail without maxmemory, got %+v", prefill)
+	}
+	if status, body := env.get(t, redisLoadTestApi+"?prefillMemory=lots"); status != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, status, body)
+	}
+}
+
+func TestRunPressure(t *testing.T) {
+	env := newTestEnvWith(t, func(cfg *config.AppConfigs) {
+		cfg.Pressure = config.PressureConfig{OverloadSeconds: 1, MaxSeconds: 10, ProbeIntervalMS: 20, RecoveryLatencyFactor: 2, RecoveryTimeoutSeconds: 5}
+	})
+	db := env.redisServer.DB(testTracesDB)
+	// miniredis has no maxmemory, every key of the traces db counts for 1000 bytes. Past maxmemory, every
+	// write evicts a key and every other one is rejected with OOM.
+	var maxMemory, evicted, writes atomic.Int64
+	maxMemory.Store(50000)
+	env.redisServer.Server().SetPreHook(func(peer *server.Peer, cmd string, args ...string) bool {
+		switch cmd {
+		case "INFO":
+			peer.WriteBulk(fmt.Sprintf("# Memory\r\nused_memory:%d\r\nmaxmemory:%d\r\nmaxmemory_policy:volatile-lru\r\n# Stats\r\nevicted_keys:%d\r\n", len(db.Keys())*1000, maxMemory.Load(), evicted.Load()))
+			return true
+		case "HMSET", "SET":
+			keys := db.Keys()
+			if int64(len(keys))*1000 < maxMemory.Load() {
+				return false
+			}
+			db.Del(keys[0])
+			evicted.Add(1)
+			if writes.Add(1)%2 == 1 {
+				peer.WriteError("OOM command not allowed when used memory > 'maxmemory'.")
+				return true
+			}
+		}
+		return false
+	})
+
+	status, body := env.get(t, redisLoadTestApi+"?pressure=true&wait=true")
+	var runReport report.Report
+	if err := json.Unmarshal([]byte(body), &runReport); err != nil || status != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", status, body)
+	}
+	pressure := runReport.Pressure
+	if pressure == nil || !pressure.Reached || pressure.MaxMemoryBytes != 50000 || pressure.MaxMemoryPolicy != "volatile-lru" || pressure.OverloadSeconds != 1 {
+		t.Fatalf("expected maxmemory to be reached, got %+v", pressure)
+	}
+	if pressure.LoadStoppedSeconds-pressure.ReachedSeconds < 1 || pressure.OomErrors == 0 || pressure.OomErrors != runReport.Errors["oom"] || pressure.EvictedKeys == 0 {
+		t.Errorf("expected an overload of 1s with OOM errors and evictions, got %+v", pressure)
+	}
+	if !pressure.Recovered || pressure.RecoverySeconds <= 0 {
+		t.Errorf("expected the server to recover once the load stopped, got %+v", pressure)
+	}
+	phases := make(map[string]bool)
+	for _, point := range pressure.Curve {
+		phases[point.Phase] = true
+	}
+	if !phases[report.PhaseOverload] || !phases[report.PhaseRecovery] || runReport.Reads != nil || !strings.HasSuffix(runReport.Profile, "/pressure") || strings.Contains(runReport.Profile, "/traces=") {
+		t.Errorf("expected a curve with the overload and recovery phases and no verification, got %v, reads %+v, profile %s", phases, runReport.Reads, runReport.Profile)
+	}
+
+	maxMemory.Store(0)
+	if status, body := env.get(t, redisLoadTestApi+"?pressure=true"); status != http.StatusBadRequest {
+		t.Errorf("expected status %d without maxmemory, got %d: %s", http.StatusBadRequest, status, body)
+	}
+}
+
+func TestExpiryWatcher(t *testing.T) {
+	env := newTestEnvWith(t, func(cfg *config.AppConfigs) {
+		cfg.Traces.Ttl = 2
+		cfg.ExpiryWatcher = config.ExpiryWatcherConfig{Enabled: true, ConfigureServer: true, MaxTrackedKeys: 100}
+	})
+	stats := func() handlers.ExpiryStats {
+		t.Helper()
+		_, body := env.get(t, expiryStatsApi)
+		var expiryStats handlers.ExpiryStats
+		if err := json.Unmarshal([]byte(body), &expiryStats); err != nil {
+			t.Fatalf("unexpected expiry stats %s: %v", body, err)
+		}
+		return expiryStats
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !stats().Subscribed {
+		if time.Now().After(deadline) {
+			t.Fatal("the expiry watcher did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// miniredis has no CONFIG, the events are published by the test instead
+	if stats().NotificationsError == "" {
+		t.Error("expected the notifications error to be reported")
+	}
+
+	if status, body := env.get(t, redisLoadTestApi+"?traceCount=2&wait=true"); status != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", status, body)
+	}
+	traceIds := env.redisServer.DB(testTracesDB).Keys()
+	if len(traceIds) != 2 {
+		t.Fatalf("expected 2 traces, got %v", traceIds)
+	}
+	expired := fmt.Sprintf("__keyevent@%d__:expired", testTracesDB)
+	evicted := fmt.Sprintf("__keyevent@%d__:evicted", testTracesDB)
+	env.redisServer.Publish(evicted, traceIds[0])
+	time.Sleep(2100 * time.Millisecond)
+	env.redisServer.Publish(expired, traceIds[1])
+	env.redisServer.Publish(expired, "scenario:1:traces")
+
+	expiryStats := stats()
+	for deadline = time.Now().Add(5 * time.Second); expiryStats.Expired+expiryStats.Evicted < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		expiryStats = stats()
+	}
+	if expiryStats.Evicted != 1 || expiryStats.EvictedBeforeTtl != 1 || expiryStats.Expired != 2 || expiryStats.ExpiredEarly != 0 || expiryStats.Untracked != 1 || expiryStats.TrackedKeys != 0 {
+		t.Errorf("unexpected expiry stats %+v", expiryStats)
+	}
+	lag, ttlLeft := expiryStats.Histograms[handlers.HistogramExpiryLag], expiryStats.Histograms[handlers.HistogramEvictionTtlLeft]
+	if lag.Count != 1 || lag.Max <= 0 || ttlLeft.Count != 1 || ttlLeft.Max > 2000 {
+		t.Errorf("unexpected histograms lag %+v, ttl left %+v", lag, ttlLeft)
+	}
+}
+
+func TestRunReportPage(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, body := env.get(t, redisLoadTestApi+"?traceCount=2")
+	runId := strings.TrimPrefix(body, "accepted runId=")
+	env.waitForReport(t, runId)
+
+	response, err := http.Get(env.server.URL + runsApi + "/" + runId + "/report.html?download=true")
+	if err != nil {
+		t.Fatalf("unable to get the report page: %v", err)
+	}
+	defer response.Body.Close()
+	page, _ := io.ReadAll(response.Body)
+
+	if response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("unexpected response %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	if disposition := response.Header.Get("Content-Disposition"); !strings.Contains(disposition, runId+".html") {
+		t.Errorf("expected the page as an attachment, got %q", disposition)
+	}
+	if !strings.Contains(string(page), "<svg") || !strings.Contains(string(page), runId) {
+		t.Errorf("expected a page with charts, got %s", page)
+	}
+}
+
+func TestRunVerdictGatesTheRequest(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, body := env.get(t, redisLoadTestApi+"?traceCount=2&wait=true&maxErrorRate=0")
+	if status != http.StatusOK {
+		t.Fatalf("expected the run to pass, got %d: %s", status, body)
+	}
+
+	status, body = env.get(t, redisLoadTestApi+"?traceCount=2&wait=true&format=junit&minSpansPerSecond=1e12")
+	if status != http.StatusExpectationFailed {
+		t.Fatalf("expected the run to fail its verdict, got %d: %s", status, body)
+	}
+	if !strings.Contains(body, "<testsuites>") || !strings.Contains(body, `<testcase name="min_spans_per_second"`) ||
+		strings.Count(body, "<failure") != 1 {
+		t.Errorf("unexpected junit output %s", body)
+	}
+
+	if status, _ = env.get(t, redisLoadTestApi+"?maxErrorRate=abc"); status != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid threshold, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	historyPath := filepath.Join(t.TempDir(), "runs.db")
+	env := newTestEnvWith(t, func(cfg *config.AppConfigs) {
+		cfg.Reports.HistoryPath = historyPath
+	})
+
+	var runIds []string
+	for i := 0; i < 2; i++ {
+		status, body := env.get(t, redisLoadTestApi+"?traceCount=2&wait=true&profile=smoke")
+		if status != http.StatusOK {
+			t.Fatalf("expected the run to complete, got %d: %s", status, body)
+		}
+		var runReport report.Report
+		if err := json.Unmarshal([]byte(body), &runReport); err != nil {
+			t.Fatalf("unable to decode report: %v", err)
+		}
+		runIds = append(runIds, runReport.RunId)
+	}
+
+	status, body := env.get(t, historyApi+"?profile=smoke")
+	if status != http.StatusOK || !strings.Contains(body, runIds[0]) || !strings.Contains(body, runIds[1]) {
+		t.Errorf("expected both runs in the history, got %d: %s", status, body)
+	}
+
+	status, body = env.get(t, historyApi+"/compare?base="+runIds[0]+"&other="+runIds[1])
+	if status != http.StatusOK || !strings.Contains(body, `"metric":"spansPerSecond"`) {
+		t.Errorf("unexpected comparison %d: %s", status, body)
+	}
+
+	status, body = env.get(t, historyApi+"/trend?profile=smoke&metric=spansWritten")
+	var points []struct {
+		Value float64 `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(body), &points); err != nil || status != http.StatusOK {
+		t.Fatalf("unexpected trend %d: %s", status, body)
+	}
+	if len(points) != 2 || points[0].Value != 20 || points[1].Value != 20 {
+		t.Errorf("expected 20 spans written in both runs, got %+v", points)
+	}
+
+	if status, _ = env.get(t, historyApi+"/"+runIds[0]); status != http.StatusOK {
+		t.Errorf("expected the stored report, got %d", status)
+	}
+}
+
+func TestReadinessFollowsRedisHealth(t *testing.T) {
+	env := newTestEnv(t)
+
+	if status, body := env.get(t, "/readyz"); status != http.StatusOK {
+		t.Fatalf("expected ready, got %d: %s", status, body)
+	}
+
+	env.redisServer.SetError("LOADING redis is loading")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, _ := env.get(t, "/readyz")
+		if status == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected readiness to fail while redis returns errors")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	env.redisServer.SetError("")
+}
+
+func TestGenerateLoadRejectedWhileShuttingDown(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.generator.Close(time.Second)
+	if status, _ := env.get(t, redisLoadTestApi+"?traceCount=1"); status != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d after shutdown, got %d", http.StatusServiceUnavailable, status)
+	}
+}
+
+func TestCloseWhileRedisIsDown(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.redisServer.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for env.generator.StoreStats().Healthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the store to turn unhealthy once redis is stopped")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// the writer waits for redis, so the run fills the queue and blocks on it
+	status, body := env.get(t, redisLoadTestApi+"?traceCount=1000")
+	if status != http.StatusAccepted {
+		t.Fatalf("unexpected response %d: %s", status, body)
+	}
+	recorder := metrics.Run(strings.TrimPrefix(body, "accepted runId="))
+	for recorder.Counts().SpansGenerated <= int64(env.cfg.Traces.QueueCapacity) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the run to fill the queue, got %+v", recorder.Counts())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		env.generator.Close(100 * time.Millisecond)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to return while redis is down")
+	}
+}
+
+func TestRunOnAllPodsForwardsTheRunParameters(t *testing.T) {
+	query, _ := url.ParseQuery("traceCount=10&wait=true&minSpansPerSecond=5&codecs=json,protobuf")
+	podQuery := podRunQuery(query, 4)
+	if podQuery.Get("traceCount") != "2" || podQuery.Get("wait") != "true" ||
+		podQuery.Get("minSpansPerSecond") != "5" || podQuery.Get("codecs") != "json,protobuf" {
+		t.Errorf("expected the run parameters with the trace count split across the pods, got %v", podQuery)
+	}
+	if query.Get("traceCount") != "10" {
+		t.Errorf("expected the request query to be left alone, got %v", query)
+	}
+
+	query, _ = url.ParseQuery("traceCountPerPod=3&traceCount=10")
+	if podQuery = podRunQuery(query, 4); podQuery.Get("traceCount") != "3" || podQuery.Has("traceCountPerPod") {
+		t.Errorf("expected traceCountPerPod to be forwarded as the trace count, got %v", podQuery)
+	}
+	query, _ = url.ParseQuery("pressure=true")
+	if podQuery = podRunQuery(query, 4); podQuery.Has("traceCount") {
+		t.Errorf("expected a pressure run without a trace count to stay unbounded, got %v", podQuery)
+	}
+
+	if status := combinedPodStatus([]int{http.StatusOK, http.StatusExpectationFailed, http.StatusOK}); status != http.StatusExpectationFailed {
+		t.Errorf("expected a failed verdict on one pod to fail the run, got %d", status)
+	}
+	if status := combinedPodStatus([]int{http.StatusOK, http.StatusOK}); status != http.StatusOK {
+		t.Errorf("expected the run to pass on all pods, got %d", status)
+	}
+	if status := combinedPodStatus(nil); status != http.StatusAccepted {
+		t.Errorf("expected status %d without pods, got %d", http.StatusAccepted, status)
+	}
+}
+
+func TestRunProfileWithoutBackend(t *testing.T) {
+	env := newTestEnvWith(t, func(cfg *config.AppConfigs) {
+		cfg.Traces.Backend = ""
+	})
+
+	_, body := env.get(t, redisLoadTestApi+"?traceCount=2")
+	runReport := env.waitForReport(t, strings.TrimPrefix(body, "accepted runId="))
+	if !strings.HasPrefix(runReport.Profile, "traces=2/") {
+		t.Errorf("expected the profile to leave out the empty backend, got %q", runReport.Profile)
+	}
+}
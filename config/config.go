@@ -1,6 +1,8 @@
 package config
 
 import (
+	"redis-test/internal/chaos"
+
 	zkHttpConfig "github.com/zerok-ai/zk-utils-go/http/config"
 	zkLogsConfig "github.com/zerok-ai/zk-utils-go/logs/config"
 	storage "github.com/zerok-ai/zk-utils-go/storage/redis/config"
@@ -9,20 +11,120 @@ import (
 type ServerConfig struct {
 	Host string `yaml:"host" env:"SRV_HOST,HOST" env-description:"Server host" env-default:"localhost"`
 	Port string `yaml:"port" env:"SRV_PORT,PORT" env-description:"Server port" env-default:"80"`
+	// ShutdownGraceSeconds is how long active runs may take to finish after SIGTERM before they are cancelled.
+	ShutdownGraceSeconds int `yaml:"shutdownGraceSeconds" env-default:"20"`
 }
 
 type TraceConfig struct {
-	SyncDurationMS int `yaml:"syncDurationMS"`
-	SyncBatchSize  int `yaml:"syncBatchSize"`
-	Ttl            int `yaml:"ttl"`
+	// Backend is the name of the registered trace store the spans are written to.
+	Backend               string `yaml:"backend" env-default:"redis"`
+	SyncDurationMS        int    `yaml:"syncDurationMS"`
+	SyncBatchSize         int    `yaml:"syncBatchSize"`
+	Ttl                   int    `yaml:"ttl"`
+	HealthCheckIntervalMS int    `yaml:"healthCheckIntervalMS" env-default:"1000"`
+	QueueCapacity         int    `yaml:"queueCapacity" env-default:"10000"`
+	QueuePolicy           string `yaml:"queuePolicy" env-default:"block"`
+	// TtlStrategy decides the expiry commands of the keys of the spans unless a run asks for another
+	// one, see handlers.TtlStrategies.
+	TtlStrategy string `yaml:"ttlStrategy" env-default:"per-span"`
+	// TtlJitterPercent is how far the jitter ttl strategy moves the ttl of a trace, either way.
+	TtlJitterPercent int `yaml:"ttlJitterPercent" env-default:"10"`
+	// Layout decides the redis keys the spans of a trace are written to, see handlers.TraceLayouts.
+	Layout string `yaml:"layout" env-default:"hash"`
+	// StreamBucketSeconds is the time span of the streams of the stream-bucket layout.
+	StreamBucketSeconds int `yaml:"streamBucketSeconds" env-default:"60"`
+	// Codec encodes the span values unless a run asks for other codecs, e.g. json, msgpack+zstd.
+	Codec string `yaml:"codec" env-default:"json"`
+	// CodecDictionaryPath is a file of sample span values the zstd codecs use as a shared dictionary.
+	CodecDictionaryPath string `yaml:"codecDictionaryPath"`
+}
+
+// EmbeddedRedisConfig starts an in-process redis compatible server instead of connecting to the configured host.
+type EmbeddedRedisConfig struct {
+	Enabled bool `yaml:"enabled" env:"ZK_EMBEDDED_REDIS" env-description:"Use an in-process redis server"`
+}
+
+// ChaosConfig puts a fault injecting proxy between the application and redis.
+type ChaosConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"ZK_CHAOS_PROXY" env-description:"Route redis traffic through the chaos proxy"`
+	ListenAddr string `yaml:"listenAddr" env-default:"127.0.0.1:0"`
+	// Schedule is started with the application. Faults can also be set through the chaos api.
+	Schedule []chaos.ScheduledFault `yaml:"schedule"`
+}
+
+type MetricsConfig struct {
+	// MaxRunLabels is the number of runs that keep their own run_id series. Older finished runs are
+	// evicted and runs beyond the limit share a single series.
+	MaxRunLabels int `yaml:"maxRunLabels" env-default:"20"`
+}
+
+// RedisStatsConfig controls the sampling of INFO, SLOWLOG and LATENCY of the target redis.
+type RedisStatsConfig struct {
+	Enabled          bool `yaml:"enabled" env-default:"true"`
+	SampleIntervalMS int  `yaml:"sampleIntervalMS" env-default:"1000"`
+}
+
+// ExpiryWatcherConfig controls the watcher of the keys the server expires and evicts in the traces db.
+type ExpiryWatcherConfig struct {
+	Enabled bool `yaml:"enabled" env-default:"false"`
+	// ConfigureServer adds the expired and evicted keyevent notifications to notify-keyspace-events.
+	// Servers that do not allow CONFIG SET need them enabled beforehand.
+	ConfigureServer bool `yaml:"configureServer" env-default:"true"`
+	// MaxTrackedKeys bounds the keys whose intended expiry is kept. The events of the other keys are
+	// counted as untracked.
+	MaxTrackedKeys int `yaml:"maxTrackedKeys" env-default:"100000"`
+}
+
+// CleanupConfig controls the deletion of the keys of namespaced runs.
+type CleanupConfig struct {
+	// KeysPerSecond throttles the deletion so that it does not disturb other runs on the same server.
+	KeysPerSecond int `yaml:"keysPerSecond" env-default:"10000"`
+	// ScanCount is the COUNT hint of the SCAN calls, and the most keys deleted with one UNLINK.
+	ScanCount int `yaml:"scanCount" env-default:"1000"`
+}
+
+// PressureConfig controls the runs of the memory pressure mode.
+type PressureConfig struct {
+	// OverloadSeconds is how long a run keeps writing once used_memory reached maxmemory.
+	OverloadSeconds int `yaml:"overloadSeconds" env-default:"30"`
+	// MaxSeconds stops the load of a run that does not reach maxmemory.
+	MaxSeconds int `yaml:"maxSeconds" env-default:"600"`
+	// ProbeIntervalMS is how often used_memory is checked during the load, and a write is probed
+	// once the load stopped.
+	ProbeIntervalMS int `yaml:"probeIntervalMS" env-default:"100"`
+	// RecoveryLatencyFactor is how much slower than before the run a probe write may be for the server
+	// to count as recovered.
+	RecoveryLatencyFactor float64 `yaml:"recoveryLatencyFactor" env-default:"2"`
+	// RecoveryTimeoutSeconds is how long the probes wait for the server to recover.
+	RecoveryTimeoutSeconds int `yaml:"recoveryTimeoutSeconds" env-default:"60"`
+}
+
+// ReportsConfig controls the reports produced at the end of each run.
+type ReportsConfig struct {
+	// Dir is where reports are written as <runId>.json. Reports are only kept in memory if it is empty.
+	Dir string `yaml:"dir" env:"ZK_REPORTS_DIR"`
+	// MaxRuns is the number of reports kept in memory.
+	MaxRuns int `yaml:"maxRuns" env-default:"100"`
+	// HistoryPath is the bbolt file the final reports are kept in. Run history is disabled if it is empty.
+	HistoryPath string `yaml:"historyPath" env:"ZK_HISTORY_PATH"`
+	// DrainTimeoutSeconds is how long a run waits for its spans to be acknowledged after generating them.
+	DrainTimeoutSeconds int `yaml:"drainTimeoutSeconds" env-default:"30"`
 }
 
 // AppConfigs is an application configuration structure
 type AppConfigs struct {
-	Redis      storage.RedisConfig     `yaml:"redis"`
-	Server     ServerConfig            `yaml:"server"`
-	Traces     TraceConfig             `yaml:"traces"`
-	LogsConfig zkLogsConfig.LogsConfig `yaml:"logs"`
-	Http       zkHttpConfig.HttpConfig `yaml:"http"`
-	Greeting   string                  `env:"GREETING" env-description:"Greeting phrase" env-default:"Hello!"`
+	Redis         storage.RedisConfig     `yaml:"redis"`
+	EmbeddedRedis EmbeddedRedisConfig     `yaml:"embeddedRedis"`
+	Chaos         ChaosConfig             `yaml:"chaos"`
+	Server        ServerConfig            `yaml:"server"`
+	Traces        TraceConfig             `yaml:"traces"`
+	Metrics       MetricsConfig           `yaml:"metrics"`
+	Reports       ReportsConfig           `yaml:"reports"`
+	RedisStats    RedisStatsConfig        `yaml:"redisStats"`
+	ExpiryWatcher ExpiryWatcherConfig     `yaml:"expiryWatcher"`
+	Cleanup       CleanupConfig           `yaml:"cleanup"`
+	Pressure      PressureConfig          `yaml:"pressure"`
+	LogsConfig    zkLogsConfig.LogsConfig `yaml:"logs"`
+	Http          zkHttpConfig.HttpConfig `yaml:"http"`
+	Greeting      string                  `env:"GREETING" env-description:"Greeting phrase" env-default:"Hello!"`
 }
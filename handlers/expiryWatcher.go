@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"redis-test/config"
+	"redis-test/internal/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+)
+
+var expiryWatcherLogTag = "ExpiryWatcher"
+
+const (
+	keyEventExpired = "expired"
+	keyEventEvicted = "evicted"
+	// keyEventFlags enable the keyevent notifications of expired and evicted keys.
+	keyEventDeleted     = "del"
+	keyEventFlags       = "Exeg"
+	notifyKeyspaceEvent = "notify-keyspace-events"
+	resubscribeInterval = time.Second
+	maxMemoryPolicy     = "maxmemory_policy"
+	defaultMaxTracked   = 100000
+	// trackingGrace is how long past its intended expiry a key stays tracked without an event, e.g. the
+	// events published while the watcher resubscribes are lost.
+	trackingGrace = time.Minute
+)
+
+// Names of the histograms of the expiry watcher, in milliseconds.
+const (
+	// HistogramExpiryLag is the time from the intended expiry of a key until it expired.
+	HistogramExpiryLag = "expiry_lag"
+	// HistogramExpiredEarlyBy is the time left until the intended expiry of the keys expired early.
+	HistogramExpiredEarlyBy = "expired_early_by"
+	// HistogramEvictionTtlLeft is the time left until the intended expiry of the keys evicted before it.
+	HistogramEvictionTtlLeft = "eviction_ttl_left"
+)
+
+// ExpiryStats is what the expiry watcher observed since it started.
+type ExpiryStats struct {
+	Enabled    bool `json:"enabled"`
+	Subscribed bool `json:"subscribed"`
+	// NotificationsError is why notify-keyspace-events could not be configured. The events only arrive
+	// if the server was configured beforehand.
+	NotificationsError string `json:"notificationsError,omitempty"`
+	MaxMemoryPolicy    string `json:"maxMemoryPolicy,omitempty"`
+	TtlSeconds         int    `json:"ttlSeconds"`
+	// TrackingDisabled is why the intended expiry of the keys is not tracked, the events of a layout without
+	// a key per trace are only counted as untracked.
+	TrackingDisabled string `json:"trackingDisabled,omitempty"`
+	// TrackedKeys is the number of keys whose intended expiry is known and that did not expire yet.
+	TrackedKeys int   `json:"trackedKeys"`
+	Expired     int64 `json:"expired"`
+	// ExpiredEarly counts the tracked keys that expired before their intended expiry, beyond the ttl
+	// precision and a flush interval.
+	ExpiredEarly     int64 `json:"expiredEarly"`
+	Evicted          int64 `json:"evicted"`
+	EvictedBeforeTtl int64 `json:"evictedBeforeTtl"`
+	// Untracked counts the events of keys whose intended expiry is not known, e.g. the scenario
+	// indexes, the span keys of the span-keys layout, the buckets of the stream-bucket layout, or the keys
+	// of an earlier process.
+	Untracked int64 `json:"untracked"`
+	// Deleted counts the tracked keys deleted before they expired, e.g. by the cleanup of a run.
+	Deleted int64 `json:"deleted"`
+	// Forgotten counts the tracked keys without an event a minute past their intended expiry.
+	Forgotten int64 `json:"forgotten"`
+	// NotTracked counts the keys written while expiryWatcher.maxTrackedKeys keys were tracked.
+	NotTracked int64                                `json:"notTracked"`
+	Histograms map[string]metrics.HistogramSnapshot `json:"histograms"`
+}
+
+// ExpiryStatsSource is implemented by the stores that can watch the keys the server expires and evicts.
+type ExpiryStatsSource interface {
+	ExpiryStats() ExpiryStats
+}
+
+// ExpiryWatcher subscribes to the keyevent notifications of the expired, evicted and deleted keys of the
+// traces db, and compares them with the intended expiry of the trace keys written by the store.
+type ExpiryWatcher struct {
+	redisHandler *RedisHandler
+	config       config.ExpiryWatcherConfig
+	// tolerance is how much earlier than intended a key may expire without being counted early.
+	tolerance  time.Duration
+	channels   []string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	histograms *metrics.HdrRecorder
+
+	mutex     sync.Mutex
+	deadlines map[string]time.Time
+	lastSweep time.Time
+	stats     ExpiryStats
+}
+
+func NewExpiryWatcher(redisHandler *RedisHandler, watcherConfig config.ExpiryWatcherConfig, ttl time.Duration, tolerance time.Duration) *ExpiryWatcher {
+	if watcherConfig.MaxTrackedKeys <= 0 {
+		watcherConfig.MaxTrackedKeys = defaultMaxTracked
+	}
+	db := redisHandler.config.DBs[redisHandler.dbName]
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ExpiryWatcher{
+		redisHandler: redisHandler,
+		config:       watcherConfig,
+		tolerance:    tolerance,
+		channels: []string{
+			fmt.Sprintf("__keyevent@%d__:%s", db, keyEventExpired),
+			fmt.Sprintf("__keyevent@%d__:%s", db, keyEventEvicted),
+			fmt.Sprintf("__keyevent@%d__:%s", db, keyEventDeleted),
+		},
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		histograms: metrics.NewHdrRecorder(),
+		deadlines:  make(map[string]time.Time),
+		stats:      ExpiryStats{Enabled: true, TtlSeconds: int(ttl / time.Second)},
+	}
+}
+
+// Start enables the notifications on the server if configured to, and subscribes in the background.
+func (w *ExpiryWatcher) Start() {
+	if w.config.ConfigureServer {
+		w.configureNotifications()
+	}
+	go w.watch()
+}
+
+// Stop unsubscribes and waits for the background subscription to end.
+func (w *ExpiryWatcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *ExpiryWatcher) configureNotifications() {
+	client := w.redisHandler.client()
+	current, err := client.ConfigGet(w.ctx, notifyKeyspaceEvent).Result()
+	if err == nil {
+		err = client.ConfigSet(w.ctx, notifyKeyspaceEvent, mergeEventFlags(current[notifyKeyspaceEvent], keyEventFlags)).Err()
+	}
+	if err != nil {
+		zkLogger.ErrorF(expiryWatcherLogTag, "unable to enable the keyevent notifications, they have to be enabled on the server: %v", err)
+		w.mutex.Lock()
+		w.stats.NotificationsError = err.Error()
+		w.mutex.Unlock()
+	}
+}
+
+// mergeEventFlags keeps the flags already set, A is an alias of all the event classes.
+func mergeEventFlags(current string, flags string) string {
+	merged := current
+	for _, flag := range flags {
+		classFlag := flag != 'E' && flag != 'K'
+		if strings.ContainsRune(merged, flag) || (classFlag && strings.ContainsRune(merged, 'A')) {
+			continue
+		}
+		merged += string(flag)
+	}
+	return merged
+}
+
+// watch renews the subscription when its connection is lost, e.g. when the store reconnects.
+func (w *ExpiryWatcher) watch() {
+	defer close(w.done)
+	for w.ctx.Err() == nil {
+		pubsub := w.redisHandler.client().PSubscribe(w.ctx, w.channels...)
+		if _, err := pubsub.Receive(w.ctx); err != nil {
+			if w.ctx.Err() == nil {
+				zkLogger.ErrorF(expiryWatcherLogTag, "unable to subscribe to %v: %v", w.channels, err)
+			}
+		} else {
+			w.setSubscribed(true)
+			w.consume(pubsub.Channel())
+			w.setSubscribed(false)
+		}
+		_ = pubsub.Close()
+
+		select {
+		case <-w.ctx.Done():
+		case <-time.After(resubscribeInterval):
+		}
+	}
+}
+
+func (w *ExpiryWatcher) consume(messages <-chan *redis.Message) {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			event := message.Channel[strings.LastIndex(message.Channel, ":")+1:]
+			w.observe(event, message.Payload, time.Now())
+		}
+	}
+}
+
+func (w *ExpiryWatcher) setSubscribed(subscribed bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stats.Subscribed = subscribed
+}
+
+// Track records when a key written now is meant to expire. A deadline already recorded for the key is
+// kept if keep is set. Keys beyond expiryWatcher.maxTrackedKeys are counted as not tracked.
+func (w *ExpiryWatcher) Track(key string, deadline time.Time, keep bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, ok := w.deadlines[key]; ok {
+		if !keep {
+			w.deadlines[key] = deadline
+		}
+		return
+	}
+	if len(w.deadlines) >= w.config.MaxTrackedKeys {
+		w.forgetOverdue(time.Now())
+	}
+	if len(w.deadlines) >= w.config.MaxTrackedKeys {
+		w.stats.NotTracked++
+		return
+	}
+	w.deadlines[key] = deadline
+}
+
+// forgetOverdue stops tracking the keys trackingGrace past their deadline, at most once a second as it
+// goes through all of them. The caller must hold mutex.
+func (w *ExpiryWatcher) forgetOverdue(now time.Time) {
+	if now.Sub(w.lastSweep) < time.Second {
+		return
+	}
+	w.lastSweep = now
+	for key, deadline := range w.deadlines {
+		if now.Sub(deadline) > trackingGrace {
+			delete(w.deadlines, key)
+			w.stats.Forgotten++
+		}
+	}
+}
+
+func (w *ExpiryWatcher) observe(event string, key string, at time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	deadline, tracked := w.deadlines[key]
+	delete(w.deadlines, key)
+	switch event {
+	case keyEventExpired:
+		w.stats.Expired++
+	case keyEventEvicted:
+		w.stats.Evicted++
+	case keyEventDeleted:
+		if tracked {
+			w.stats.Deleted++
+		}
+		return
+	default:
+		return
+	}
+	if !tracked {
+		w.stats.Untracked++
+		w.redisHandler.metrics.KeyUntracked(event == keyEventEvicted)
+		return
+	}
+
+	if event == keyEventExpired {
+		lag := at.Sub(deadline)
+		early := lag < -w.tolerance
+		if early {
+			w.stats.ExpiredEarly++
+			w.histograms.RecordMillis(HistogramExpiredEarlyBy, -lag)
+		} else if lag >= 0 {
+			w.histograms.RecordMillis(HistogramExpiryLag, lag)
+		} else {
+			w.histograms.RecordMillis(HistogramExpiryLag, 0)
+		}
+		w.redisHandler.metrics.KeyExpired(lag, early)
+		return
+	}
+
+	ttlLeft := deadline.Sub(at)
+	beforeTtl := ttlLeft > 0
+	if beforeTtl {
+		w.stats.EvictedBeforeTtl++
+		w.histograms.RecordMillis(HistogramEvictionTtlLeft, ttlLeft)
+	}
+	w.redisHandler.metrics.KeyEvicted(ttlLeft, beforeTtl)
+}
+
+// Stats returns what the watcher observed so far, with the eviction policy of the server if it
+// reports it.
+func (w *ExpiryWatcher) Stats() ExpiryStats {
+	var policy string
+	if info, err := w.redisHandler.ServerInfo(); err == nil {
+		policy = info[maxMemoryPolicy]
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.forgetOverdue(time.Now())
+	stats := w.stats
+	stats.MaxMemoryPolicy = policy
+	stats.TrackedKeys = len(w.deadlines)
+	stats.Histograms = w.histograms.Snapshot()
+	return stats
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"redis-test/config"
+	"testing"
+	"time"
+
+	zkconfig "github.com/zerok-ai/zk-utils-go/storage/redis/config"
+)
+
+func newTestExpiryWatcher(maxTrackedKeys int) *ExpiryWatcher {
+	redisHandler := &RedisHandler{config: &zkconfig.RedisConfig{}}
+	return NewExpiryWatcher(redisHandler, config.ExpiryWatcherConfig{MaxTrackedKeys: maxTrackedKeys}, time.Minute, time.Second)
+}
+
+// trackedStats returns the stats of the watcher without the server info Stats reads.
+func trackedStats(watcher *ExpiryWatcher) ExpiryStats {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	stats := watcher.stats
+	stats.TrackedKeys = len(watcher.deadlines)
+	return stats
+}
+
+func TestExpiryWatcherStopsTrackingDeletedKeys(t *testing.T) {
+	watcher := newTestExpiryWatcher(10)
+	now := time.Now()
+	watcher.Track("deleted", now.Add(time.Minute), false)
+	watcher.Track("expired", now.Add(time.Minute), false)
+
+	watcher.observe(keyEventDeleted, "deleted", now)
+	watcher.observe(keyEventDeleted, "scenario:1:traces", now)
+	watcher.observe(keyEventExpired, "expired", now.Add(time.Minute))
+
+	stats := trackedStats(watcher)
+	if stats.Deleted != 1 || stats.Expired != 1 || stats.Untracked != 0 || stats.TrackedKeys != 0 {
+		t.Errorf("expected the deleted key to be forgotten without an untracked event, got %+v", stats)
+	}
+}
+
+func TestExpiryWatcherCountsKeysBeyondTheLimit(t *testing.T) {
+	watcher := newTestExpiryWatcher(2)
+	now := time.Now()
+	watcher.Track("overdue", now.Add(-trackingGrace-time.Second), false)
+	watcher.Track("tracked", now.Add(time.Minute), false)
+	watcher.Track("tracked", now.Add(2*time.Minute), false)
+
+	// the overdue key makes room for the first key beyond the limit
+	watcher.Track("first", now.Add(time.Minute), false)
+	watcher.Track("second", now.Add(time.Minute), false)
+	watcher.Track("third", now.Add(time.Minute), false)
+
+	stats := trackedStats(watcher)
+	if stats.Forgotten != 1 || stats.NotTracked != 2 || stats.TrackedKeys != 2 {
+		t.Errorf("expected the overdue key to be forgotten and 2 keys not to be tracked, got %+v", stats)
+	}
+	if _, ok := watcher.deadlines["first"]; !ok {
+		t.Error("expected the key written after the overdue one was forgotten to be tracked")
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"redis-test/internal/metrics"
+)
+
+// ErrorClass groups redis command errors by their cause.
+type ErrorClass string
+
+const (
+	ErrorClassOOM      ErrorClass = "oom"
+	ErrorClassReadOnly ErrorClass = "readonly"
+	ErrorClassMoved    ErrorClass = "moved"
+	ErrorClassTimeout  ErrorClass = "timeout"
+	// ErrorClassUnavailable is used for writes rejected or dropped while the connection was unhealthy.
+	ErrorClassUnavailable ErrorClass = "unavailable"
+	// ErrorClassNoScript is used for script calls the server had no script or function for.
+	ErrorClassNoScript ErrorClass = "noscript"
+	// ErrorClassEncode is used for spans the codec failed to encode, they never reach the store.
+	ErrorClassEncode ErrorClass = "encode"
+	ErrorClassOther  ErrorClass = "other"
+)
+
+// ClassifyRedisError maps an error returned by a redis command to an ErrorClass.
+func ClassifyRedisError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrRedisUnavailable):
+		return ErrorClassUnavailable
+	case redis.HasErrorPrefix(err, "OOM"):
+		return ErrorClassOOM
+	case redis.HasErrorPrefix(err, "READONLY"):
+		return ErrorClassReadOnly
+	case redis.HasErrorPrefix(err, "MOVED"), redis.HasErrorPrefix(err, "ASK"):
+		return ErrorClassMoved
+	case redis.HasErrorPrefix(err, "NOSCRIPT"), strings.HasPrefix(err.Error(), "ERR Function not found"):
+		return ErrorClassNoScript
+	case isTimeoutError(err):
+		return ErrorClassTimeout
+	}
+	return ErrorClassOther
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "i/o timeout")
+}
+
+// CommandStats counts executed redis commands and their failures per command type and error class.
+type CommandStats struct {
+	mutex    sync.Mutex
+	metrics  metrics.Target
+	executed map[string]int64
+	errors   map[string]map[ErrorClass]int64
+}
+
+func NewCommandStats(target metrics.Target) *CommandStats {
+	return &CommandStats{
+		metrics:  target,
+		executed: make(map[string]int64),
+		errors:   make(map[string]map[ErrorClass]int64),
+	}
+}
+
+// Record inspects the result of every command and returns the number of failed commands. runIds holds
+// the run each command was sent for, the commands past its end were sent outside of a run.
+func (s *CommandStats) Record(cmds []redis.Cmder, runIds []string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	failed := 0
+	for i, cmd := range cmds {
+		name := cmd.Name()
+		s.executed[name]++
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			class := ClassifyRedisError(err)
+			if s.errors[name] == nil {
+				s.errors[name] = make(map[ErrorClass]int64)
+			}
+			s.errors[name][class]++
+			var runId string
+			if i < len(runIds) {
+				runId = runIds[i]
+			}
+			s.metrics.CommandFailed(runId, name, string(class))
+			failed++
+		}
+	}
+	return failed
+}
+
+// CommandStatsSnapshot is a point-in-time copy of CommandStats.
+type CommandStatsSnapshot struct {
+	Executed map[string]int64                `json:"executed"`
+	Errors   map[string]map[ErrorClass]int64 `json:"errors"`
+	ByClass  map[ErrorClass]int64            `json:"byClass"`
+}
+
+func (s *CommandStats) Snapshot() CommandStatsSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := CommandStatsSnapshot{
+		Executed: make(map[string]int64, len(s.executed)),
+		Errors:   make(map[string]map[ErrorClass]int64, len(s.errors)),
+		ByClass:  make(map[ErrorClass]int64),
+	}
+	for name, count := range s.executed {
+		snapshot.Executed[name] = count
+	}
+	for name, classes := range s.errors {
+		snapshot.Errors[name] = make(map[ErrorClass]int64, len(classes))
+		for class, count := range classes {
+			snapshot.Errors[name][class] = count
+			snapshot.ByClass[class] += count
+		}
+	}
+	return snapshot
+}
@@ -3,28 +3,69 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
 	zkconfig "github.com/zerok-ai/zk-utils-go/storage/redis/config"
 	zktick "github.com/zerok-ai/zk-utils-go/ticker"
+	"redis-test/internal/metrics"
+	"redis-test/internal/redisstats"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var redisHandlerLogTag = "RedisHandler"
 
-var (
-	redisWriteCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
-		},
-		[]string{"method"},
-	)
-)
+const redisBackend = "redis"
+
+// PendingWrite is a span queued in the pipeline. It is acknowledged once all of its commands succeeded.
+type PendingWrite struct {
+	RunId      string
+	TraceId    string
+	EnqueuedAt time.Time
+	Bytes      int
+	// WriteMode labels the commands sent for the span.
+	WriteMode string
+}
 
-func init() {
-	prometheus.MustRegister(redisWriteCounter)
+type pendingWrite struct {
+	PendingWrite
+	firstCmd int
+	cmdCount int
+	// batch is set for the writes grouped by BatchFor, their commands are the ones of the batch.
+	batch *pipelineBatch
+}
+
+// BatchWriter queues the commands writing the values grouped under a key by BatchFor.
+type BatchWriter interface {
+	// Queue queues the commands writing the values.
+	Queue(ctx context.Context, pipeline redis.Pipeliner, values []interface{})
+	// Load loads the script the commands call. It is called again before the batches that failed
+	// with NOSCRIPT are retried.
+	Load(ctx context.Context, client redis.Cmdable) error
+}
+
+// pipelineBatch holds the writes grouped under a key until the flush queues them.
+type pipelineBatch struct {
+	writer BatchWriter
+	// transaction batches are executed in a MULTI/EXEC of their own instead of the pipeline.
+	transaction bool
+	writes      []PendingWrite
+	values      []interface{}
+	firstCmd    int
+	cmdCount    int
+}
+
+// pipelineFlush holds the commands and the writes taken out of the handler under pipelineLock, so
+// that they are executed without holding it.
+type pipelineFlush struct {
+	client       *redis.Client
+	pipeline     redis.Pipeliner
+	pending      []pendingWrite
+	batches      []*pipelineBatch
+	transactions []*pipelineBatch
+	count        int
 }
 
 type RedisHandler struct {
@@ -33,7 +74,19 @@ type RedisHandler struct {
 	config       *zkconfig.RedisConfig
 	dbName       string
 	Pipeline     redis.Pipeliner
+	pipelineLock sync.Mutex
+	// flushLock executes one flush at a time, in the order the commands were queued. It is held
+	// without pipelineLock while the flush is executed.
+	flushLock    sync.Mutex
+	pending      []pendingWrite
+	batches      map[string]*pipelineBatch
+	batchKeys    []string
+	commandStats *CommandStats
+	metrics      metrics.Target
+	flushes      atomic.Uint64
 	ticker       *zktick.TickerTask
+	closed       bool
+	health       *RedisHealthMonitor
 	count        int
 	startTime    time.Time
 	batchSize    int
@@ -41,11 +94,15 @@ type RedisHandler struct {
 	tag          string
 }
 
-func NewRedisHandler(redisConfig *zkconfig.RedisConfig, dbName string, syncInterval int, batchSize int, tag string) (*RedisHandler, error) {
+func NewRedisHandler(redisConfig *zkconfig.RedisConfig, dbName string, syncInterval int, batchSize int, healthCheckInterval int, tag string) (*RedisHandler, error) {
+	target := metrics.Target{Db: dbName, Backend: redisBackend}
 	handler := RedisHandler{
-		ctx:    context.Background(),
-		config: redisConfig,
-		dbName: dbName,
+		ctx:          context.Background(),
+		config:       redisConfig,
+		dbName:       dbName,
+		batches:      make(map[string]*pipelineBatch),
+		commandStats: NewCommandStats(target),
+		metrics:      target,
 	}
 
 	err := handler.InitializeRedisConn()
@@ -56,15 +113,19 @@ func NewRedisHandler(redisConfig *zkconfig.RedisConfig, dbName string, syncInter
 
 	handler.Pipeline = handler.RedisClient.Pipeline()
 
-	timerDuration := time.Duration(syncInterval) * time.Millisecond
-	handler.ticker = zktick.GetNewTickerTask("sync_pipeline", timerDuration, handler.SyncPipeline)
-	handler.ticker.Start()
-
 	handler.syncInterval = syncInterval
 	handler.batchSize = batchSize
 	handler.ctx = context.Background()
 	handler.tag = tag
 
+	// the background tasks are started once the handler is fully initialized
+	timerDuration := time.Duration(syncInterval) * time.Millisecond
+	handler.ticker = zktick.GetNewTickerTask("sync_pipeline", timerDuration, handler.SyncPipeline)
+	handler.ticker.Start()
+
+	handler.health = NewRedisHealthMonitor(&handler, time.Duration(healthCheckInterval)*time.Millisecond)
+	handler.health.Start()
+
 	return &handler, nil
 }
 
@@ -79,35 +140,83 @@ func (h *RedisHandler) InitializeRedisConn() error {
 	redisClient := redis.NewClient(opt)
 
 	h.RedisClient = redisClient
-	err := h.PingRedis()
+	err := pingRedis(redisClient)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (h *RedisHandler) Set(key string, value interface{}) error {
-	statusCmd := h.RedisClient.Set(h.ctx, key, value, 0)
+// The direct methods below send a single command and wait for its result. Its failure is counted for
+// the run, which is empty for the commands sent outside of a run.
+
+func (h *RedisHandler) Set(runId string, key string, value interface{}) error {
+	start := time.Now()
+	statusCmd := h.client().Set(h.ctx, key, value, 0)
+	h.observeCommand(runId, statusCmd, start)
+	return statusCmd.Err()
+}
+
+func (h *RedisHandler) SetNX(runId string, key string, value interface{}) error {
+	start := time.Now()
+	statusCmd := h.client().SetNX(h.ctx, key, value, 0)
+	h.observeCommand(runId, statusCmd, start)
 	return statusCmd.Err()
 }
 
-func (h *RedisHandler) SetNX(key string, value interface{}) error {
-	statusCmd := h.RedisClient.SetNX(h.ctx, key, value, 0)
+func (h *RedisHandler) HSet(runId string, key string, values ...interface{}) error {
+	start := time.Now()
+	statusCmd := h.client().HSet(h.ctx, key, values...)
+	h.observeCommand(runId, statusCmd, start)
 	return statusCmd.Err()
 }
 
-func (h *RedisHandler) HSet(key string, value interface{}) error {
-	statusCmd := h.RedisClient.HSet(h.ctx, key, value, 0)
+func (h *RedisHandler) HMSet(runId string, key string, value interface{}) error {
+	start := time.Now()
+	statusCmd := h.client().HMSet(h.ctx, key, value)
+	h.observeCommand(runId, statusCmd, start)
 	return statusCmd.Err()
 }
 
-func (h *RedisHandler) HMSet(key string, value interface{}) error {
-	statusCmd := h.RedisClient.HMSet(h.ctx, key, value)
+func (h *RedisHandler) SAdd(runId string, key string, members ...interface{}) error {
+	start := time.Now()
+	statusCmd := h.client().SAdd(h.ctx, key, members...)
+	h.observeCommand(runId, statusCmd, start)
 	return statusCmd.Err()
 }
 
+// Process sends a command built by the caller.
+func (h *RedisHandler) Process(runId string, cmd redis.Cmder) error {
+	start := time.Now()
+	_ = h.client().Process(h.ctx, cmd)
+	h.observeCommand(runId, cmd, start)
+	return cmd.Err()
+}
+
+// DirectFor writes a span with the direct methods called by send, which returns the number of
+// commands it sent. The span is acknowledged if send succeeded, the error is returned otherwise.
+func (h *RedisHandler) DirectFor(write PendingWrite, send func() (int, error)) error {
+	commands, err := send()
+	h.metrics.CommandsSent(write.RunId, write.WriteMode, commands)
+	if err != nil {
+		return err
+	}
+	h.metrics.SpanWritten(write.RunId, write.TraceId, write.Bytes)
+	h.metrics.SpanAcknowledged(write.RunId, time.Since(write.EnqueuedAt))
+	return nil
+}
+
+func (h *RedisHandler) observeCommand(runId string, cmd redis.Cmder, start time.Time) {
+	h.metrics.CommandExecuted(cmd.Name(), time.Since(start))
+	h.commandStats.Record([]redis.Cmder{cmd}, []string{runId})
+}
+
 func (h *RedisHandler) PingRedis() error {
-	redisClient := h.RedisClient
+	return pingRedis(h.client())
+}
+
+// pingRedis is called by InitializeRedisConn under pipelineLock, so it cannot go through client().
+func pingRedis(redisClient *redis.Client) error {
 	if redisClient == nil {
 		zkLogger.Error(redisHandlerLogTag, "Redis client is nil.")
 		return fmt.Errorf("redis client is nil")
@@ -119,78 +228,452 @@ func (h *RedisHandler) PingRedis() error {
 	return nil
 }
 
+// The pipeline methods below only queue commands. Their results are known after the
+// pipeline is executed and are inspected in execPipeline.
+
 func (h *RedisHandler) HMSetPipeline(key string, value map[string]string, expiration time.Duration) error {
-	cmd := h.Pipeline.HMSet(h.ctx, key, value)
-	if cmd.Err() != nil {
-		return cmd.Err()
+	return h.HMSetPipelineFor(PendingWrite{}, key, value, expiration)
+}
+
+// HMSetPipelineFor queues the write of a span, so that it can be acknowledged after the flush.
+func (h *RedisHandler) HMSetPipelineFor(write PendingWrite, key string, value map[string]string, expiration time.Duration) error {
+	return h.PipelineFor(write, func(pipeline redis.Pipeliner) {
+		pipeline.HMSet(h.ctx, key, value)
+		h.setExpiry(key, expiration)
+	})
+}
+
+// PipelineFor queues the commands of a span added by queue, so that the span can be acknowledged
+// after the flush once all of them succeeded.
+func (h *RedisHandler) PipelineFor(write PendingWrite, queue func(pipeline redis.Pipeliner)) error {
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+
+	firstCmd := h.Pipeline.Len()
+	queue(h.Pipeline)
+	h.count++
+	if !write.EnqueuedAt.IsZero() {
+		h.pending = append(h.pending, pendingWrite{PendingWrite: write, firstCmd: firstCmd, cmdCount: h.Pipeline.Len() - firstCmd})
+		h.metrics.CommandsSent(write.RunId, write.WriteMode, h.Pipeline.Len()-firstCmd)
 	}
+	return nil
+}
+
+// BatchFor groups the write of a value with the other writes of the same key until the flush, where
+// writer queues the commands of the whole group. The writes are acknowledged once these commands
+// succeeded.
+func (h *RedisHandler) BatchFor(key string, write PendingWrite, value interface{}, writer BatchWriter) error {
+	return h.batchFor(key, write, value, writer, false)
+}
+
+// TxBatchFor groups writes like BatchFor, the commands of the group are executed atomically in a
+// MULTI/EXEC of their own at the flush.
+func (h *RedisHandler) TxBatchFor(key string, write PendingWrite, value interface{}, writer BatchWriter) error {
+	return h.batchFor(key, write, value, writer, true)
+}
+
+func (h *RedisHandler) batchFor(key string, write PendingWrite, value interface{}, writer BatchWriter, transaction bool) error {
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+
+	batch, ok := h.batches[key]
+	if !ok {
+		batch = &pipelineBatch{writer: writer, transaction: transaction}
+		h.batches[key] = batch
+		h.batchKeys = append(h.batchKeys, key)
+	}
+	batch.writes = append(batch.writes, write)
+	batch.values = append(batch.values, value)
 	h.count++
-	return h.setExpiry(key, expiration)
+	return nil
 }
 
-func (h *RedisHandler) SetNXPipeline(key string, value interface{}, expiration time.Duration) error {
-	cmd := h.Pipeline.SetNX(h.ctx, key, value, expiration)
-	if cmd.Err() != nil {
-		return cmd.Err()
+// queueBatches returns the transaction batches apart. The caller must hold pipelineLock.
+func (h *RedisHandler) queueBatches() (batches []*pipelineBatch, transactions []*pipelineBatch) {
+	for _, key := range h.batchKeys {
+		batch := h.batches[key]
+		if batch.transaction {
+			transactions = append(transactions, batch)
+		} else {
+			h.queueBatch(h.Pipeline, 0, batch)
+			batches = append(batches, batch)
+		}
+		for _, write := range batch.writes {
+			if !write.EnqueuedAt.IsZero() {
+				h.pending = append(h.pending, pendingWrite{PendingWrite: write, batch: batch})
+			}
+		}
+		delete(h.batches, key)
+	}
+	h.batchKeys = h.batchKeys[:0]
+	return batches, transactions
+}
+
+// queueBatch queues the commands of a batch, offset is the number of commands executed before the
+// pipeline.
+func (h *RedisHandler) queueBatch(pipeline redis.Pipeliner, offset int, batch *pipelineBatch) {
+	firstCmd := pipeline.Len()
+	batch.writer.Queue(h.ctx, pipeline, batch.values)
+	batch.firstCmd = offset + firstCmd
+	batch.cmdCount = pipeline.Len() - firstCmd
+	h.metrics.CommandsSent(batch.writes[0].RunId, batch.writes[0].WriteMode, batch.cmdCount)
+}
+
+// retryBatches queues the batches of a flush whose commands failed with NOSCRIPT again once their
+// writers loaded their scripts, and executes them. It returns cmds with the results of the retries
+// appended, the retried batches point to these results, and the number of retried commands that failed.
+func (h *RedisHandler) retryBatches(flush *pipelineFlush, cmds []redis.Cmder) ([]redis.Cmder, int) {
+	pipeline := flush.client.Pipeline()
+	loaded := make(map[BatchWriter]bool)
+	for _, batch := range flush.batches {
+		if !batchFailedWith(cmds, batch, ErrorClassNoScript) {
+			continue
+		}
+		if !loaded[batch.writer] {
+			loaded[batch.writer] = true
+			if err := batch.writer.Load(h.ctx, flush.client); err != nil {
+				zkLogger.Error(redisHandlerLogTag, "Error while loading the script of a batch ", err)
+			}
+		}
+		h.queueBatch(pipeline, len(cmds), batch)
 	}
+	if pipeline.Len() == 0 {
+		return cmds, 0
+	}
+	zkLogger.Info(redisHandlerLogTag, "Retrying batches after NOSCRIPT, commands =", pipeline.Len())
+	retried, _ := pipeline.Exec(h.ctx)
+	failed := h.commandStats.Record(retried, cmdRunIds(flush.pending, len(cmds), len(cmds)+len(retried)))
+	return append(cmds, retried...), failed
+}
+
+// execTransactions executes each transaction batch of a flush in a MULTI/EXEC of its own and returns
+// cmds with their results appended, and the number of their commands that failed.
+func (h *RedisHandler) execTransactions(flush *pipelineFlush, cmds []redis.Cmder) ([]redis.Cmder, int) {
+	failed := 0
+	for _, batch := range flush.transactions {
+		tx := flush.client.TxPipeline()
+		batch.writer.Queue(h.ctx, tx, batch.values)
+		batch.firstCmd = len(cmds)
+		batch.cmdCount = tx.Len()
+		// MULTI and EXEC are sent around the commands of the batch
+		h.metrics.CommandsSent(batch.writes[0].RunId, batch.writes[0].WriteMode, batch.cmdCount+2)
+		results, _ := tx.Exec(h.ctx)
+		failed += h.commandStats.Record(results, cmdRunIds(flush.pending, len(cmds), len(cmds)+len(results)))
+		cmds = append(cmds, results...)
+	}
+	return cmds, failed
+}
+
+func batchFailedWith(cmds []redis.Cmder, batch *pipelineBatch, class ErrorClass) bool {
+	if batch.firstCmd+batch.cmdCount > len(cmds) {
+		return false
+	}
+	for _, cmd := range cmds[batch.firstCmd : batch.firstCmd+batch.cmdCount] {
+		if ClassifyRedisError(cmd.Err()) == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *RedisHandler) SetNXPipeline(key string, value interface{}, expiration time.Duration) error {
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+
+	h.Pipeline.SetNX(h.ctx, key, value, expiration)
 	h.count++
-	return h.setExpiry(key, expiration)
+	h.setExpiry(key, expiration)
+	return nil
 }
 
 func (h *RedisHandler) SAddPipeline(key string, value interface{}, expiration time.Duration) error {
-	cmd := h.Pipeline.SAdd(h.ctx, key, value)
-	if cmd.Err() != nil {
-		return cmd.Err()
-	}
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+
+	h.Pipeline.SAdd(h.ctx, key, value)
 	h.count++
-	return h.setExpiry(key, expiration)
+	h.setExpiry(key, expiration)
+	return nil
 }
 
-func (h *RedisHandler) setExpiry(key string, expiration time.Duration) error {
+func (h *RedisHandler) setExpiry(key string, expiration time.Duration) {
 	if expiration > 0 {
-		cmd := h.Pipeline.Expire(h.ctx, key, expiration)
-		if cmd.Err() != nil {
-			return cmd.Err()
-		}
+		h.Pipeline.Expire(h.ctx, key, expiration)
 	}
-	return nil
 }
 
 func (h *RedisHandler) CheckRedisConnection() error {
 	err := h.PingRedis()
 	if err != nil {
-		//Closing redis connection.
-		err = h.CloseConnection()
-		if err != nil {
-			zkLogger.Error(redisHandlerLogTag, "Failed to close Redis connection: ", err)
-			return err
+		return h.Reconnect()
+	}
+	return nil
+}
+
+// Reconnect replaces the redis client. Commands queued on the old connection's pipeline are dropped.
+func (h *RedisHandler) Reconnect() error {
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+
+	//Closing redis connection.
+	err := h.CloseConnection()
+	if err != nil {
+		zkLogger.Error(redisHandlerLogTag, "Failed to close Redis connection: ", err)
+	}
+	if h.count > 0 {
+		zkLogger.Error(redisHandlerLogTag, "Dropping queued commands while reconnecting, count =", h.count)
+		h.count = 0
+		for _, write := range h.pending {
+			h.metrics.SpanFailed(write.RunId, write.TraceId, string(ErrorClassUnavailable))
 		}
-		err = h.InitializeRedisConn()
-		if err != nil {
-			zkLogger.Error(redisHandlerLogTag, "Error while initializing redis connection ", err)
-			return err
+		h.pending = h.pending[:0]
+		for _, key := range h.batchKeys {
+			for _, write := range h.batches[key].writes {
+				h.metrics.SpanFailed(write.RunId, write.TraceId, string(ErrorClassUnavailable))
+			}
+			delete(h.batches, key)
 		}
+		h.batchKeys = h.batchKeys[:0]
+	}
+
+	err = h.InitializeRedisConn()
+	h.Pipeline = h.RedisClient.Pipeline()
+	if err != nil {
+		zkLogger.Error(redisHandlerLogTag, "Error while initializing redis connection ", err)
+		return err
 	}
 	return nil
 }
 
+// IsHealthy reports the connection state last observed by the background health monitor.
+func (h *RedisHandler) IsHealthy() bool {
+	return h.health.IsHealthy()
+}
+
+func (h *RedisHandler) HealthStatus() RedisHealthStatus {
+	return h.health.Status()
+}
+
 func (h *RedisHandler) SyncPipeline() {
+	h.flushLock.Lock()
+	defer h.flushLock.Unlock()
+
+	flush := h.takeDueFlush()
+	if flush == nil {
+		return
+	}
+	failed, err := h.execPipeline(flush)
+	if err != nil {
+		zkLogger.Error(redisHandlerLogTag, "Error while syncing data to redis ", err)
+	}
+	zkLogger.Debug(redisHandlerLogTag, "Pipeline synchronized. Batch size =", flush.count, ", failed commands =", failed)
+}
+
+// takeDueFlush takes the queued commands out of the handler once the batch is full or old enough,
+// it returns nil otherwise.
+func (h *RedisHandler) takeDueFlush() *pipelineFlush {
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
 	syncDuration := time.Duration(h.syncInterval) * time.Second
 
 	count := h.count
 	if count > h.batchSize || (count > 0 && time.Since(h.startTime) >= syncDuration) {
-		_, err := h.Pipeline.Exec(h.ctx)
-		if err != nil {
-			zkLogger.Error(redisHandlerLogTag, "Error while syncing data to redis ", err)
-			return
+		return h.takeFlush()
+	}
+	return nil
+}
+
+// takeFlush queues the batches and takes the pipeline and the pending writes out of the handler,
+// which queues the next writes on a new pipeline. The caller must hold pipelineLock.
+func (h *RedisHandler) takeFlush() *pipelineFlush {
+	batches, transactions := h.queueBatches()
+	flush := &pipelineFlush{
+		client:       h.RedisClient,
+		pipeline:     h.Pipeline,
+		pending:      h.pending,
+		batches:      batches,
+		transactions: transactions,
+		count:        h.count,
+	}
+	h.Pipeline = h.RedisClient.Pipeline()
+	h.pending = nil
+	h.count = 0
+	h.startTime = time.Now()
+	return flush
+}
+
+// execPipeline executes the commands of a flush and inspects the result of each of them. Exec only
+// reports the first failure, so partial failures are visible through the returned count only. The
+// count and the flush latency include the retried batches and the transactions. The caller must hold
+// flushLock, but not pipelineLock.
+func (h *RedisHandler) execPipeline(flush *pipelineFlush) (int, error) {
+	start := time.Now()
+	cmds, err := flush.pipeline.Exec(h.ctx)
+	failed := h.commandStats.Record(cmds, cmdRunIds(flush.pending, 0, len(cmds)))
+	cmds, retryFailed := h.retryBatches(flush, cmds)
+	cmds, txFailed := h.execTransactions(flush, cmds)
+	failed += retryFailed + txFailed
+	if len(cmds) > 0 {
+		h.metrics.Flushed(time.Since(start), flush.count, pendingRunIds(flush.pending))
+	}
+	h.acknowledge(flush.pending, cmds, err)
+	h.flushes.Add(1)
+	if err == redis.Nil {
+		err = nil
+	}
+	return failed, err
+}
+
+func pendingRunIds(pending []pendingWrite) []string {
+	var runIds []string
+	seen := make(map[string]bool)
+	for _, write := range pending {
+		if !seen[write.RunId] {
+			seen[write.RunId] = true
+			runIds = append(runIds, write.RunId)
+		}
+	}
+	return runIds
+}
+
+// cmdRunIds returns the run of each of the commands from first to last, the ones no pending write
+// queued have an empty run.
+func cmdRunIds(pending []pendingWrite, first int, last int) []string {
+	runIds := make([]string, last-first)
+	for _, write := range pending {
+		firstCmd, cmdCount := write.firstCmd, write.cmdCount
+		if write.batch != nil {
+			firstCmd, cmdCount = write.batch.firstCmd, write.batch.cmdCount
 		}
-		zkLogger.Debug(redisHandlerLogTag, "Pipeline synchronized. Batch size =", count)
+		for i := max(firstCmd, first); i < min(firstCmd+cmdCount, last); i++ {
+			runIds[i-first] = write.RunId
+		}
+	}
+	return runIds
+}
 
-		redisWriteCounter.WithLabelValues("redis-writes").Add(float64(count))
+// acknowledge counts the pending writes whose commands all succeeded as written and records their
+// end-to-end latency. The other writes are counted as failed with the class of their first error.
+func (h *RedisHandler) acknowledge(pending []pendingWrite, cmds []redis.Cmder, execErr error) {
+	now := time.Now()
+	for _, write := range pending {
+		firstCmd, cmdCount := write.firstCmd, write.cmdCount
+		if write.batch != nil {
+			firstCmd, cmdCount = write.batch.firstCmd, write.batch.cmdCount
+		}
+		var failure error
+		if firstCmd+cmdCount > len(cmds) {
+			failure = execErr
+			if failure == nil || failure == redis.Nil {
+				failure = ErrRedisUnavailable
+			}
+		} else {
+			for _, cmd := range cmds[firstCmd : firstCmd+cmdCount] {
+				if err := cmd.Err(); err != nil && err != redis.Nil {
+					failure = err
+					break
+				}
+			}
+		}
+		if failure != nil {
+			h.metrics.SpanFailed(write.RunId, write.TraceId, string(ClassifyRedisError(failure)))
+			continue
+		}
+		h.metrics.SpanWritten(write.RunId, write.TraceId, write.Bytes)
+		h.metrics.SpanAcknowledged(write.RunId, now.Sub(write.EnqueuedAt))
+	}
+}
 
-		h.count -= count
-		h.startTime = time.Now()
+// client returns the current redis client, which Reconnect replaces.
+func (h *RedisHandler) client() *redis.Client {
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+	return h.RedisClient
+}
+
+// ServerInfo returns the output of INFO with its default sections.
+func (h *RedisHandler) ServerInfo() (redisstats.Info, error) {
+	text, err := h.client().Info(h.ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	return redisstats.ParseInfo(text), nil
+}
+
+// SlowLog returns the newest entries of SLOWLOG GET.
+func (h *RedisHandler) SlowLog(count int64) ([]redisstats.SlowLogEntry, error) {
+	logs, err := h.client().SlowLogGet(h.ctx, count).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]redisstats.SlowLogEntry, 0, len(logs))
+	for _, log := range logs {
+		entries = append(entries, redisstats.SlowLogEntry{
+			Id:         log.ID,
+			Time:       log.Time,
+			DurationUs: log.Duration.Microseconds(),
+			Command:    strings.Join(log.Args, " "),
+			ClientAddr: log.ClientAddr,
+		})
 	}
+	return entries, nil
+}
+
+// KeyMemory returns the MEMORY USAGE and OBJECT ENCODING of a key.
+func (h *RedisHandler) KeyMemory(key string) (redisstats.TraceMemory, error) {
+	return keyMemory(h.ctx, h.client(), key)
+}
+
+// keyMemory measures all the elements of a key, unless the server does not accept SAMPLES. An
+// encoding the server does not report is left empty.
+func keyMemory(ctx context.Context, client redis.Cmdable, key string) (redisstats.TraceMemory, error) {
+	bytes, err := client.MemoryUsage(ctx, key, 0).Result()
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "syntax") {
+		bytes, err = client.MemoryUsage(ctx, key).Result()
+	}
+	if err == redis.Nil {
+		return redisstats.TraceMemory{}, nil
+	}
+	if err != nil {
+		return redisstats.TraceMemory{}, err
+	}
+
+	encoding, err := client.ObjectEncoding(ctx, key).Result()
+	if err != nil {
+		encoding = ""
+	}
+	return redisstats.TraceMemory{Found: true, Bytes: bytes, Encoding: encoding}, nil
+}
+
+// LatencyLatest returns the latest latency spike of each event tracked by the latency monitor.
+func (h *RedisHandler) LatencyLatest() ([]redisstats.LatencyEvent, error) {
+	lines, err := h.client().Do(h.ctx, "LATENCY", "LATEST").Slice()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]redisstats.LatencyEvent, 0, len(lines))
+	for _, line := range lines {
+		fields, ok := line.([]interface{})
+		if !ok || len(fields) < 4 {
+			continue
+		}
+		event, _ := fields[0].(string)
+		timestamp, _ := fields[1].(int64)
+		latest, _ := fields[2].(int64)
+		max, _ := fields[3].(int64)
+		events = append(events, redisstats.LatencyEvent{Event: event, Time: time.Unix(timestamp, 0), LatestMS: latest, MaxMS: max})
+	}
+	return events, nil
+}
+
+// CommandStats returns the per command type and per error class counts of executed commands.
+func (h *RedisHandler) CommandStats() CommandStatsSnapshot {
+	return h.commandStats.Snapshot()
 }
 
 func (h *RedisHandler) CloseConnection() error {
@@ -198,15 +681,30 @@ func (h *RedisHandler) CloseConnection() error {
 }
 
 func (h *RedisHandler) forceSync() {
-	_, err := h.Pipeline.Exec(h.ctx)
+	h.flushLock.Lock()
+	defer h.flushLock.Unlock()
+
+	h.pipelineLock.Lock()
+	flush := h.takeFlush()
+	h.pipelineLock.Unlock()
+
+	count := flush.count
+	_, err := h.execPipeline(flush)
 	if err != nil {
 		zkLogger.Error(redisHandlerLogTag, "Error while force syncing data to redis ", err)
 		return
 	}
+	zkLogger.Debug(redisHandlerLogTag, "Pipeline force synchronized. Batch size =", count)
 }
 
 func (h *RedisHandler) shutdown() {
+	h.ticker.Stop()
+	h.health.Stop()
 	h.forceSync()
+
+	h.pipelineLock.Lock()
+	defer h.pipelineLock.Unlock()
+	h.closed = true
 	err := h.CloseConnection()
 	if err != nil {
 		zkLogger.Error(redisHandlerLogTag, "Error while closing redis conn.")
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"redis-test/internal/metrics"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// silentServer accepts connections and never answers, so that a flush waits for its read timeout.
+func silentServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestPipelineQueuesWhileAFlushWaits(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: silentServer(t), ReadTimeout: time.Second, MaxRetries: -1})
+	defer client.Close()
+	target := metrics.Target{Db: "traces", Backend: "flush-test"}
+	h := &RedisHandler{
+		RedisClient:  client,
+		ctx:          context.Background(),
+		Pipeline:     client.Pipeline(),
+		batches:      make(map[string]*pipelineBatch),
+		commandStats: NewCommandStats(target),
+		metrics:      target,
+	}
+
+	_ = h.HMSetPipeline("first", map[string]string{"span": "value"}, 0)
+	flushed := make(chan struct{})
+	go func() {
+		h.SyncPipeline()
+		close(flushed)
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		h.pipelineLock.Lock()
+		taken := h.count == 0
+		h.pipelineLock.Unlock()
+		if taken {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the flush did not take the pipeline")
+		}
+	}
+
+	queued := make(chan struct{})
+	go func() {
+		_ = h.HMSetPipeline("second", map[string]string{"span": "value"}, 0)
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-flushed:
+		t.Fatal("expected the flush to wait for the server")
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected the write to be queued while the flush waits for the server")
+	}
+	<-flushed
+	if h.count != 1 || h.Pipeline.Len() != 1 {
+		t.Errorf("expected the write queued during the flush to wait for the next one, count = %d, commands = %d", h.count, h.Pipeline.Len())
+	}
+}
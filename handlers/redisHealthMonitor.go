@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+	zktick "github.com/zerok-ai/zk-utils-go/ticker"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var healthMonitorLogTag = "RedisHealthMonitor"
+
+const defaultHealthCheckInterval = time.Second
+
+// RedisHealthStatus is the state of the connection as last observed by the health monitor.
+type RedisHealthStatus struct {
+	Healthy             bool      `json:"healthy"`
+	LastCheck           time.Time `json:"lastCheck"`
+	LastHealthy         time.Time `json:"lastHealthy"`
+	LastError           string    `json:"lastError,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Reconnects          int       `json:"reconnects"`
+}
+
+// RedisHealthMonitor pings redis in the background and reconnects when the ping fails, so that
+// writers only have to check a flag instead of making a round trip for every write.
+type RedisHealthMonitor struct {
+	redisHandler *RedisHandler
+	ticker       *zktick.TickerTask
+	healthy      atomic.Bool
+	mutex        sync.RWMutex
+	status       RedisHealthStatus
+}
+
+func NewRedisHealthMonitor(redisHandler *RedisHandler, interval time.Duration) *RedisHealthMonitor {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	monitor := &RedisHealthMonitor{redisHandler: redisHandler}
+
+	// the connection was verified while creating the handler
+	now := time.Now()
+	monitor.healthy.Store(true)
+	monitor.status = RedisHealthStatus{Healthy: true, LastCheck: now, LastHealthy: now}
+
+	monitor.ticker = zktick.GetNewTickerTask("redis_health_check", interval, monitor.check)
+	return monitor
+}
+
+func (m *RedisHealthMonitor) Start() {
+	m.ticker.Start()
+}
+
+func (m *RedisHealthMonitor) Stop() {
+	m.ticker.Stop()
+}
+
+// IsHealthy is cheap enough to be called for every write.
+func (m *RedisHealthMonitor) IsHealthy() bool {
+	return m.healthy.Load()
+}
+
+func (m *RedisHealthMonitor) Status() RedisHealthStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.status
+}
+
+// check records the ping result and reconnects after a failure. The mutex is not held while
+// reconnecting, so that Status keeps answering the readiness probe while redis is down.
+func (m *RedisHealthMonitor) check() {
+	err := m.redisHandler.PingRedis()
+	if m.recordPing(err) {
+		return
+	}
+
+	zkLogger.Error(healthMonitorLogTag, "Redis health check failed, reconnecting ", err)
+	err = m.redisHandler.Reconnect()
+	if err != nil {
+		zkLogger.Error(healthMonitorLogTag, "Error while reconnecting to redis ", err)
+	}
+	m.recordReconnect(err)
+}
+
+// recordPing updates the status with the result of a ping and reports whether it succeeded.
+func (m *RedisHealthMonitor) recordPing(err error) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.status.LastCheck = time.Now()
+	if err == nil {
+		m.status.LastError = ""
+		m.setHealthy(m.status.LastCheck)
+		return true
+	}
+
+	m.status.Healthy = false
+	m.status.LastError = err.Error()
+	m.status.ConsecutiveFailures++
+	m.healthy.Store(false)
+	return false
+}
+
+// recordReconnect counts a successful reconnect, the connection is healthy again.
+func (m *RedisHealthMonitor) recordReconnect(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err != nil {
+		m.status.LastError = err.Error()
+		return
+	}
+	m.status.Reconnects++
+	m.setHealthy(time.Now())
+}
+
+// setHealthy marks the connection healthy since at. The caller must hold mutex.
+func (m *RedisHealthMonitor) setHealthy(at time.Time) {
+	m.status.Healthy = true
+	m.status.LastHealthy = at
+	m.status.ConsecutiveFailures = 0
+	m.healthy.Store(true)
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHealthMonitorCountsSuccessfulReconnects(t *testing.T) {
+	monitor := &RedisHealthMonitor{}
+	down := errors.New("connection refused")
+
+	monitor.recordPing(down)
+	monitor.recordReconnect(down)
+	monitor.recordPing(down)
+	if status := monitor.Status(); status.Reconnects != 0 || status.ConsecutiveFailures != 2 || status.Healthy {
+		t.Errorf("expected failed pings and reconnects not to count as reconnects, got %+v", status)
+	}
+
+	monitor.recordReconnect(nil)
+	if status := monitor.Status(); status.Reconnects != 1 || status.ConsecutiveFailures != 0 || !status.Healthy {
+		t.Errorf("expected the successful reconnect to be counted, got %+v", status)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"redis-test/internal/metrics"
+	"sync"
+	"time"
+)
+
+// QueuePolicy decides what happens to a span when the queue between the generator and the writer is full.
+type QueuePolicy string
+
+const (
+	// QueuePolicyBlock slows the generator down until the writer catches up.
+	QueuePolicyBlock QueuePolicy = "block"
+	// QueuePolicyDropNewest discards the span being enqueued.
+	QueuePolicyDropNewest QueuePolicy = "drop_newest"
+	// QueuePolicyDropOldest discards the span waiting the longest to make room for the new one.
+	QueuePolicyDropOldest QueuePolicy = "drop_oldest"
+)
+
+const defaultQueueCapacity = 10000
+
+// SpanQueue is a bounded buffer between span generation and the redis writer.
+type SpanQueue struct {
+	items   chan Span
+	policy  QueuePolicy
+	metrics metrics.Target
+
+	// runDepths counts the queued spans by run, a span waiting for room in a blocking queue included.
+	depthMutex sync.Mutex
+	runDepths  map[string]int
+}
+
+func NewSpanQueue(capacity int, policy QueuePolicy, target metrics.Target) (*SpanQueue, error) {
+	if capacity <= 0 {
+		capacity = defaultQueueCapacity
+	}
+	switch policy {
+	case "":
+		policy = QueuePolicyBlock
+	case QueuePolicyBlock, QueuePolicyDropNewest, QueuePolicyDropOldest:
+	default:
+		return nil, fmt.Errorf("unknown queue policy %q", policy)
+	}
+	return &SpanQueue{items: make(chan Span, capacity), policy: policy, metrics: target, runDepths: make(map[string]int)}, nil
+}
+
+// Enqueue adds an item to the queue according to the queue policy. It returns false if an item was dropped.
+// A blocking queue drops the item when ctx is done before there is room for it.
+func (q *SpanQueue) Enqueue(ctx context.Context, item Span) bool {
+	item.EnqueuedAt = time.Now()
+	q.updateDepth(item.RunId, 1)
+
+	select {
+	case q.items <- item:
+		return true
+	default:
+	}
+
+	switch q.policy {
+	case QueuePolicyDropNewest:
+		q.updateDepth(item.RunId, -1)
+		q.metrics.SpanDropped(item.RunId, item.TraceId, string(q.policy))
+		return false
+
+	case QueuePolicyDropOldest:
+		dropped := false
+		for {
+			select {
+			case q.items <- item:
+				return !dropped
+			default:
+			}
+			select {
+			case oldest := <-q.items:
+				dropped = true
+				q.updateDepth(oldest.RunId, -1)
+				q.metrics.SpanDropped(oldest.RunId, oldest.TraceId, string(q.policy))
+			default:
+			}
+		}
+
+	default:
+		start := time.Now()
+		defer func() { q.metrics.QueueBlocked(time.Since(start)) }()
+		select {
+		case q.items <- item:
+			return true
+		case <-ctx.Done():
+			q.updateDepth(item.RunId, -1)
+			q.metrics.SpanDropped(item.RunId, item.TraceId, droppedCancelled)
+			return false
+		}
+	}
+}
+
+// Items returns the channel the writer consumes from. The writer calls Taken for every span it takes.
+func (q *SpanQueue) Items() <-chan Span {
+	return q.items
+}
+
+// Taken counts a span the writer took from the queue out of the depth of its run.
+func (q *SpanQueue) Taken(span Span) {
+	q.updateDepth(span.RunId, -1)
+}
+
+// Close lets the writer finish once the queued items are consumed.
+func (q *SpanQueue) Close() {
+	close(q.items)
+}
+
+func (q *SpanQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *SpanQueue) updateDepth(runId string, delta int) {
+	q.depthMutex.Lock()
+	runDepth := q.runDepths[runId] + delta
+	if runDepth == 0 {
+		delete(q.runDepths, runId)
+	} else {
+		q.runDepths[runId] = runDepth
+	}
+	q.depthMutex.Unlock()
+	q.metrics.QueueDepth(runId, runDepth, len(q.items))
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"redis-test/internal/metrics"
+	"testing"
+	"time"
+)
+
+func queueOf(t *testing.T, capacity int, policy QueuePolicy) *SpanQueue {
+	t.Helper()
+	queue, err := NewSpanQueue(capacity, policy, metrics.Target{})
+	if err != nil {
+		t.Fatalf("unable to create a %s queue: %v", policy, err)
+	}
+	return queue
+}
+
+// drain returns the span ids left in the queue.
+func drain(queue *SpanQueue) []string {
+	var spanIds []string
+	for queue.Len() > 0 {
+		spanIds = append(spanIds, (<-queue.Items()).SpanId)
+	}
+	return spanIds
+}
+
+func TestSpanQueueDropPolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy   QueuePolicy
+		enqueued []bool
+		kept     []string
+	}{
+		{QueuePolicyDropNewest, []bool{true, true, false, false}, []string{"1", "2"}},
+		{QueuePolicyDropOldest, []bool{true, true, false, false}, []string{"3", "4"}},
+	} {
+		queue := queueOf(t, 2, test.policy)
+		for i, spanId := range []string{"1", "2", "3", "4"} {
+			if enqueued := queue.Enqueue(context.Background(), Span{SpanId: spanId}); enqueued != test.enqueued[i] {
+				t.Errorf("%s: expected span %s enqueued %v, got %v", test.policy, spanId, test.enqueued[i], enqueued)
+			}
+		}
+		if kept := drain(queue); len(kept) != 2 || kept[0] != test.kept[0] || kept[1] != test.kept[1] {
+			t.Errorf("%s: expected spans %v to be kept, got %v", test.policy, test.kept, kept)
+		}
+	}
+}
+
+func TestSpanQueueBlocksUntilTheWriterCatchesUp(t *testing.T) {
+	queue := queueOf(t, 1, QueuePolicyBlock)
+	queue.Enqueue(context.Background(), Span{SpanId: "1"})
+
+	enqueued := make(chan bool)
+	go func() { enqueued <- queue.Enqueue(context.Background(), Span{SpanId: "2"}) }()
+	select {
+	case <-enqueued:
+		t.Fatal("expected the second span to wait for room in the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if spanId := (<-queue.Items()).SpanId; spanId != "1" {
+		t.Errorf("expected span 1 first, got %s", spanId)
+	}
+	if !<-enqueued {
+		t.Error("expected the second span to be enqueued once there is room")
+	}
+}
+
+func TestSpanQueueDropsABlockedSpanOnceCancelled(t *testing.T) {
+	queue := queueOf(t, 1, QueuePolicyBlock)
+	queue.Enqueue(context.Background(), Span{SpanId: "1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	enqueued := make(chan bool)
+	go func() { enqueued <- queue.Enqueue(ctx, Span{SpanId: "2"}) }()
+	cancel()
+	select {
+	case ok := <-enqueued:
+		if ok {
+			t.Error("expected the blocked span to be dropped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the blocked span to give up once cancelled")
+	}
+	if kept := drain(queue); len(kept) != 1 || kept[0] != "1" {
+		t.Errorf("expected only span 1 to be kept, got %v", kept)
+	}
+}
+
+func TestSpanQueueDefaults(t *testing.T) {
+	queue := queueOf(t, 0, "")
+	if queue.policy != QueuePolicyBlock || cap(queue.items) != defaultQueueCapacity {
+		t.Errorf("expected a blocking queue of %d spans, got %s of %d", defaultQueueCapacity, queue.policy, cap(queue.items))
+	}
+	if _, err := NewSpanQueue(1, "drop_random", metrics.Target{}); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestSpanQueueCountsTheDepthOfEachRun(t *testing.T) {
+	queue := queueOf(t, 3, QueuePolicyDropOldest)
+	for _, runId := range []string{"a", "a", "b", "b"} {
+		queue.Enqueue(context.Background(), Span{RunId: runId})
+	}
+	queue.Taken(<-queue.Items())
+	if queue.runDepths["a"] != 0 || queue.runDepths["b"] != 2 {
+		t.Errorf("expected the dropped and the taken spans of run a to leave 2 spans of run b, got %v", queue.runDepths)
+	}
+	for queue.Len() > 0 {
+		queue.Taken(<-queue.Items())
+	}
+	if len(queue.runDepths) != 0 {
+		t.Errorf("expected the runs to be forgotten once their spans are taken, got %v", queue.runDepths)
+	}
+}
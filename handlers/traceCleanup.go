@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrCleanupUnsupported = errors.New("the trace store cannot delete the keys of a run")
+
+// KeyCleaner is implemented by the stores that can delete the keys of a namespaced run.
+type KeyCleaner interface {
+	// DeleteKeys deletes the keys starting with keyPrefix, at most keysPerSecond a second. The keys
+	// found so far are deleted if the context is cancelled.
+	DeleteKeys(ctx context.Context, keyPrefix string, keysPerSecond int, scanCount int) (CleanupResult, error)
+}
+
+// CleanupResult is what deleting the keys of a run removed.
+type CleanupResult struct {
+	KeyPrefix string `json:"keyPrefix"`
+	// Scanned counts the keys found with the prefix, Removed the ones deleted. Keys that expired in
+	// between are found but not removed.
+	Scanned         int64   `json:"scanned"`
+	Removed         int64   `json:"removed"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// RunKeyPrefix is the prefix of the keys of a namespaced run.
+func RunKeyPrefix(runId string) string {
+	return "run:" + runId + ":"
+}
+
+// globEscaper escapes the characters SCAN MATCH patterns give a meaning to.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// prefixPattern is the SCAN MATCH pattern of the keys starting with a prefix.
+func prefixPattern(keyPrefix string) string {
+	return globEscaper.Replace(keyPrefix) + "*"
+}
+
+// throttle waits until removing the keys so far fits in keysPerSecond since start. It returns false if
+// the context is cancelled first.
+func throttle(ctx context.Context, start time.Time, keys int64, keysPerSecond int) bool {
+	if keysPerSecond <= 0 {
+		return ctx.Err() == nil
+	}
+	wait := time.Duration(float64(keys)/float64(keysPerSecond)*float64(time.Second)) - time.Since(start)
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPrefixPatternEscapesGlobs(t *testing.T) {
+	if pattern := prefixPattern(`run:a*b?[c]\:`); pattern != `run:a\*b\?\[c\]\\:*` {
+		t.Errorf("unexpected pattern %s", pattern)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	start := time.Now()
+	if !throttle(context.Background(), start, 5, 100) || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected 5 keys at 100 keys per second to take 50ms, took %v", time.Since(start))
+	}
+	if !throttle(context.Background(), time.Now(), 1000, 0) {
+		t.Error("expected no throttling without a rate")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if throttle(ctx, time.Now(), 1000, 1) {
+		t.Error("expected a cancelled throttle to stop the cleanup")
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
+	"redis-test/config"
+	"redis-test/internal/metrics"
+	"sync/atomic"
+	"time"
+)
+
+const discardTraceStoreBackend = "discard"
+
+var _ TraceStore = (*TraceDiscardStore)(nil)
+
+func init() {
+	RegisterTraceStore(discardTraceStoreBackend, func(config *config.AppConfigs) (TraceStore, error) {
+		return NewTraceDiscardStore(), nil
+	})
+}
+
+// TraceDiscardStore throws the encoded spans away. It is the baseline for the cost of generating
+// and encoding spans without any storage behind it.
+type TraceDiscardStore struct {
+	metrics metrics.Target
+	writes  atomic.Int64
+}
+
+func NewTraceDiscardStore() *TraceDiscardStore {
+	return &TraceDiscardStore{metrics: metrics.Target{Db: clientDBNames.TraceDBName, Backend: discardTraceStoreBackend}}
+}
+
+func (s *TraceDiscardStore) PutSpan(span Span) error {
+	s.writes.Add(1)
+	s.metrics.SpanWritten(span.RunId, span.TraceId, len(span.Value))
+	s.metrics.SpanAcknowledged(span.RunId, time.Since(span.EnqueuedAt))
+	return nil
+}
+
+func (s *TraceDiscardStore) Flush() {
+}
+
+func (s *TraceDiscardStore) Close() {
+}
+
+func (s *TraceDiscardStore) Stats() TraceStoreStats {
+	return TraceStoreStats{Backend: discardTraceStoreBackend, Healthy: true, Writes: s.writes.Load()}
+}
@@ -1,75 +1,362 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
-	"math/rand"
+	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
+	"os"
 	"redis-test/config"
+	"redis-test/internal/codec"
+	"redis-test/internal/metrics"
+	"redis-test/internal/redisstats"
 	"redis-test/model"
+	"strconv"
 	"sync"
 	"time"
 )
 
 var traceLogTag = "TraceHandler"
+
+var ErrTraceReadUnsupported = errors.New("the trace store cannot read traces")
 var delimiter = "__"
 
+const (
+	storeRetryInterval = 50 * time.Millisecond
+	droppedUnavailable = "unavailable"
+	droppedCancelled   = "cancelled"
+)
+
 type TraceHandler struct {
-	traceRedisHandler *TraceRedisHandler
-	traceStoreMutex   sync.Mutex
-	traceStore        sync.Map
+	store           TraceStore
+	metrics         metrics.Target
+	spanQueue       *SpanQueue
+	writerDone      chan struct{}
+	traceStoreMutex sync.Mutex
+	traceStore      sync.Map
+
+	// stopping is cancelled by StopWaiting or Close, the writer no longer waits for an unavailable store.
+	stopping    context.Context
+	stopWaiting context.CancelFunc
+
+	// defaultCodec encodes the spans of runs that do not pick codecs, dictionary is shared by the
+	// compressed codecs.
+	defaultCodec string
+	dictionary   []byte
+	codecsMutex  sync.Mutex
+	// ttlStrategy expires the spans of runs that do not pick a ttl strategy, ttl and ttlJitterPercent
+	// are the configured ttl the verification expects.
+	ttlStrategy      string
+	ttl              time.Duration
+	ttlJitterPercent int
+	codecs           map[string]codec.Codec
 }
 
 func NewTraceHandler(config *config.AppConfigs) (*TraceHandler, error) {
-	traceRedisHandler, err := NewTracesRedisHandler(config)
+	var dictionary []byte
+	var err error
+	if path := config.Traces.CodecDictionaryPath; path != "" {
+		if dictionary, err = os.ReadFile(path); err != nil {
+			logger.Error(traceLogTag, "Error while reading the codec dictionary:", err)
+			return nil, err
+		}
+	}
+	defaultCodec := config.Traces.Codec
+	if defaultCodec == "" {
+		defaultCodec = codec.JSON
+	}
+
+	ttlStrategy := config.Traces.TtlStrategy
+	if ttlStrategy == "" {
+		ttlStrategy = defaultTtlStrategy
+	}
+	if !isTtlStrategy(ttlStrategy) {
+		err = fmt.Errorf("%w %q, ttl strategies are %v", ErrUnknownTtlStrategy, ttlStrategy, TtlStrategies())
+		logger.Error(traceLogTag, "Error while checking the ttl strategy:", err)
+		return nil, err
+	}
+
+	store, err := NewTraceStore(config)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating trace store:", err)
+		return nil, err
+	}
+	target := metrics.Target{Db: clientDBNames.TraceDBName, Backend: store.Stats().Backend}
+	spanQueue, err := NewSpanQueue(config.Traces.QueueCapacity, QueuePolicy(config.Traces.QueuePolicy), target)
 	if err != nil {
-		logger.Error(traceLogTag, "Error while creating redis handler:", err)
+		logger.Error(traceLogTag, "Error while creating span queue:", err)
+		store.Close()
 		return nil, err
 	}
 
-	handler := TraceHandler{traceRedisHandler: traceRedisHandler}
+	stopping, stopWaiting := context.WithCancel(context.Background())
+	handler := TraceHandler{
+		store:        store,
+		stopping:     stopping,
+		stopWaiting:  stopWaiting,
+		metrics:      target,
+		spanQueue:    spanQueue,
+		writerDone:   make(chan struct{}),
+		defaultCodec: defaultCodec,
+		dictionary:   dictionary,
+		codecs:       make(map[string]codec.Codec),
+		ttlStrategy:  ttlStrategy,
+
+		ttl:              time.Duration(config.Traces.Ttl) * time.Second,
+		ttlJitterPercent: config.Traces.TtlJitterPercent,
+	}
+	if _, err = handler.Codec(""); err != nil {
+		logger.Error(traceLogTag, "Error while creating the configured codec:", err)
+		store.Close()
+		return nil, err
+	}
+	go handler.writeSpans()
 	return &handler, nil
 }
 
-func (th *TraceHandler) PushDataToRedis(runId string, traceCount, spanCountPerTrace int) {
+// Codec returns the codec with the given name, or the configured codec if the name is empty.
+func (th *TraceHandler) Codec(name string) (codec.Codec, error) {
+	if name == "" {
+		name = th.defaultCodec
+	}
+	th.codecsMutex.Lock()
+	defer th.codecsMutex.Unlock()
+	if spanCodec, ok := th.codecs[name]; ok {
+		return spanCodec, nil
+	}
+	spanCodec, err := codec.New(name, th.dictionary)
+	if err != nil {
+		return nil, err
+	}
+	th.codecs[name] = spanCodec
+	return spanCodec, nil
+}
 
-	for traceIndex := 0; traceIndex < traceCount; traceIndex++ {
-		traceIDStr := fmt.Sprintf("00-aaaa%s", generateRandomHex(28))
+// CheckWriteMode returns an error if the store cannot write spans in the mode. Every store writes
+// spans in the pipeline mode.
+func (th *TraceHandler) CheckWriteMode(mode string) error {
+	if mode == "" || mode == WriteModePipeline {
+		return nil
+	}
+	if !isWriteMode(mode) {
+		return fmt.Errorf("%w %q, write modes are %v", ErrUnknownWriteMode, mode, WriteModes())
+	}
+	store, ok := th.store.(WriteModeStore)
+	if !ok {
+		return fmt.Errorf("%w: the %s store only writes in the %s mode", ErrWriteModeUnsupported, th.store.Stats().Backend, WriteModePipeline)
+	}
+	return store.CheckWriteMode(mode)
+}
 
-		parentSpanId := "0000000000000000"
-		for spanIndex := 0; spanIndex < spanCountPerTrace; spanIndex++ {
+// CheckTtlStrategy returns an error if the spans of a write mode cannot be expired with the ttl
+// strategy, the configured one if it is empty.
+func (th *TraceHandler) CheckTtlStrategy(strategy string, writeMode string) error {
+	if strategy == "" {
+		strategy = th.ttlStrategy
+	}
+	return checkTtlStrategy(strategy, writeMode)
+}
+
+// TtlStrategy returns the ttl strategy the spans of a run are expired with.
+func (th *TraceHandler) TtlStrategy(parameters model.RunParameters) string {
+	if parameters.TtlStrategy == "" {
+		return th.ttlStrategy
+	}
+	return parameters.TtlStrategy
+}
 
-			spanDetails := th.createSpanDetails(parentSpanId)
+// PushDataToRedis generates the spans of a run until all traces are generated or the context is cancelled.
+// The trace and span ids are generated from the seed of the run, see ExpectedTraces. The traces are
+// spread over the codecs in turn, all of them use the configured codec if none are given. With
+// scenarios, every trace is matched by one of them in turn.
+func (th *TraceHandler) PushDataToRedis(ctx context.Context, runId string, parameters model.RunParameters) {
+	ExpectedTraces(parameters, func(trace ExpectedTrace) bool {
+		if ctx.Err() != nil {
+			logger.InfoF(traceLogTag, "run %s cancelled after %d traces", runId, trace.Index)
+			return false
+		}
+		traceStart := time.Now()
+		var groupBy model.GroupByMap
+		if parameters.Scenarios > 0 {
+			scenario := model.ScenarioId(strconv.Itoa(trace.Index%parameters.Scenarios + 1))
+			groupBy = model.GroupByMap{scenario: {{WorkloadId: "load-generator", Title: "scenario " + string(scenario)}}}
+		}
 
-			// Generate a random span ID (16 characters)
-			spanID := generateRandomHex(16)
+		parentSpanId := model.DefaultParentSpanId
+		for _, spanID := range trace.SpanIds {
+			spanDetails := th.createSpanDetails(parentSpanId, groupBy)
 
-			err := th.traceRedisHandler.PutTraceData(traceIDStr, spanID, spanDetails)
-			if err != nil {
-				logger.Debug(traceLogTag, "Error while putting trace data to redis ", err)
-				return
-			}
+			th.metrics.SpanGenerated(runId, trace.TraceId)
+			th.spanQueue.Enqueue(ctx, Span{RunId: runId, TraceId: trace.TraceId, SpanId: spanID, SpanDetails: spanDetails, Codec: trace.Codec, WriteMode: parameters.WriteMode, TtlStrategy: parameters.TtlStrategy, TraceStart: traceStart, TtlJitter: trace.TtlJitter, KeyPrefix: parameters.KeyPrefix})
 
 			parentSpanId = spanID
 		}
+		return true
+	})
+}
+
+// writeSpans moves spans from the queue to the trace store. The store is flushed as soon as a batch is
+// full, so a slow store keeps the writer busy and the queue absorbs or pushes back on the generator.
+// The writer also stops taking spans while the store is unavailable.
+func (th *TraceHandler) writeSpans() {
+	defer close(th.writerDone)
+	for span := range th.spanQueue.Items() {
+		th.waitForStore()
+		th.spanQueue.Taken(span)
+
+		if err := th.encode(&span); err != nil {
+			logger.Debug(traceLogTag, "Error while encoding span ", span.SpanId, err)
+			th.metrics.SpanFailed(span.RunId, span.TraceId, string(ErrorClassEncode))
+			continue
+		}
+		err := th.store.PutSpan(span)
+		if errors.Is(err, ErrRedisUnavailable) {
+			th.metrics.SpanDropped(span.RunId, span.TraceId, droppedUnavailable)
+			continue
+		}
+		if err != nil {
+			logger.Debug(traceLogTag, "Error while putting trace data to the store ", err)
+			th.metrics.SpanFailed(span.RunId, span.TraceId, string(ClassifyRedisError(err)))
+			continue
+		}
+		th.store.Flush()
+	}
+}
+
+func (th *TraceHandler) waitForStore() {
+	store, ok := th.store.(AvailabilityReporter)
+	if !ok {
+		return
+	}
+	for !store.Available() && th.stopping.Err() == nil {
+		time.Sleep(storeRetryInterval)
 	}
+}
 
-	th.traceRedisHandler.SyncPipeline()
+// StopWaiting stops the writer from waiting for an unavailable store, the spans it takes from then on
+// are dropped while the store is unavailable. It lets a shutdown drain the queue while the store is down.
+func (th *TraceHandler) StopWaiting() {
+	th.stopWaiting()
 }
 
-// Populate Span common properties.
-func (th *TraceHandler) createSpanDetails(parentSpanId string) model.OTelSpanDetails {
-	spanDetail := model.OTelSpanDetails{}
-	spanDetail.SetParentSpanId(parentSpanId)
-	return spanDetail
+func (th *TraceHandler) encode(span *Span) error {
+	spanCodec, err := th.Codec(span.Codec)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	span.Value, err = spanCodec.Encode(&span.SpanDetails)
+	if err != nil {
+		return err
+	}
+	th.metrics.SpanEncoded(span.RunId, span.TraceId, spanCodec.Name(), len(span.Value), time.Since(start))
+	return nil
+}
+
+// Close drains the queue, flushes the pending spans and closes the store. Spans must not be pushed
+// after Close is called.
+func (th *TraceHandler) Close() {
+	th.stopWaiting()
+	th.spanQueue.Close()
+	<-th.writerDone
+	th.store.Close()
 }
 
-// Function to generate a random hexadecimal string of a given length
-func generateRandomHex(length int) string {
-	rand.Seed(time.Now().UnixNano())
-	hexChars := "0123456789abcdef"
-	result := make([]byte, length)
-	for i := 0; i < length; i++ {
-		result[i] = hexChars[rand.Intn(len(hexChars))]
+// Metrics returns the target the metrics of the spans written to the store are recorded for.
+func (th *TraceHandler) Metrics() metrics.Target {
+	return th.metrics
+}
+
+func (th *TraceHandler) StoreStats() TraceStoreStats {
+	return th.store.Stats()
+}
+
+// StatsSource returns the server behind the store, or nil if the store has no server.
+func (th *TraceHandler) StatsSource() redisstats.Source {
+	source, ok := th.store.(redisstats.Source)
+	if !ok {
+		return nil
 	}
-	return string(result)
+	return source
+}
+
+// MemoryProbe returns the store if it can measure the memory of a trace, or nil.
+func (th *TraceHandler) MemoryProbe() redisstats.TraceMemoryProbe {
+	probe, ok := th.store.(redisstats.TraceMemoryProbe)
+	if !ok {
+		return nil
+	}
+	return probe
+}
+
+// TtlProbe returns the store if it can tell the remaining ttl of a trace, or nil.
+func (th *TraceHandler) TtlProbe() TraceTtlProbe {
+	probe, ok := th.store.(TraceTtlProbe)
+	if !ok {
+		return nil
+	}
+	return probe
+}
+
+// Health returns the store if it monitors the connection to its server, or nil.
+func (th *TraceHandler) Health() HealthReporter {
+	reporter, ok := th.store.(HealthReporter)
+	if !ok {
+		return nil
+	}
+	return reporter
+}
+
+// CommandStats returns the store if it counts the redis commands it sends, or nil.
+func (th *TraceHandler) CommandStats() CommandStatsReporter {
+	reporter, ok := th.store.(CommandStatsReporter)
+	if !ok {
+		return nil
+	}
+	return reporter
+}
+
+// ExpiryStats returns what the expiry watcher of the store observed, Enabled is false if the store
+// does not watch expiries.
+func (th *TraceHandler) ExpiryStats() ExpiryStats {
+	source, ok := th.store.(ExpiryStatsSource)
+	if !ok {
+		return ExpiryStats{}
+	}
+	return source.ExpiryStats()
+}
+
+// Reader returns the store if it can read traces back, or nil.
+func (th *TraceHandler) Reader() TraceReader {
+	reader, ok := th.store.(TraceReader)
+	if !ok {
+		return nil
+	}
+	return reader
+}
+
+// Cleaner returns the store if it can delete the keys of a run, or nil.
+func (th *TraceHandler) Cleaner() KeyCleaner {
+	cleaner, ok := th.store.(KeyCleaner)
+	if !ok {
+		return nil
+	}
+	return cleaner
+}
+
+// Prober returns the store if it can time a single write, or nil.
+func (th *TraceHandler) Prober() WriteProber {
+	prober, ok := th.store.(WriteProber)
+	if !ok {
+		return nil
+	}
+	return prober
+}
+
+// Populate Span common properties.
+func (th *TraceHandler) createSpanDetails(parentSpanId string, groupBy model.GroupByMap) model.OTelSpanDetails {
+	spanDetail := model.OTelSpanDetails{StartNs: uint64(time.Now().UnixNano()), GroupBy: groupBy}
+	spanDetail.SetParentSpanId(parentSpanId)
+	return spanDetail
 }
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"redis-test/config"
+	"redis-test/internal/metrics"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const unavailableTestBackend = "unavailable-test"
+
+// unavailableStore is a store whose availability is switched by the test.
+type unavailableStore struct {
+	available atomic.Bool
+	puts      atomic.Int64
+}
+
+func (s *unavailableStore) Available() bool {
+	return s.available.Load()
+}
+
+func (s *unavailableStore) PutSpan(span Span) error {
+	if !s.available.Load() {
+		return ErrRedisUnavailable
+	}
+	s.puts.Add(1)
+	metrics.Target{}.SpanWritten(span.RunId, span.TraceId, len(span.Value))
+	return nil
+}
+
+func (s *unavailableStore) Flush() {}
+
+func (s *unavailableStore) Close() {}
+
+func (s *unavailableStore) Stats() TraceStoreStats {
+	return TraceStoreStats{Backend: unavailableTestBackend, Healthy: s.available.Load()}
+}
+
+func TestWriterWaitsForAnUnavailableStore(t *testing.T) {
+	store := &unavailableStore{}
+	RegisterTraceStore(unavailableTestBackend, func(*config.AppConfigs) (TraceStore, error) {
+		return store, nil
+	})
+	th, err := NewTraceHandler(&config.AppConfigs{Traces: config.TraceConfig{Backend: unavailableTestBackend, QueueCapacity: 2, QueuePolicy: string(QueuePolicyDropNewest)}})
+	if err != nil {
+		t.Fatalf("unable to create the trace handler: %v", err)
+	}
+	defer th.Close()
+	runId := "writer-waits"
+	recorder := th.metrics.RunStarted(runId)
+	defer metrics.ForgetRun(runId)
+
+	enqueue := func() {
+		th.metrics.SpanGenerated(runId, "trace")
+		th.spanQueue.Enqueue(context.Background(), Span{RunId: runId, TraceId: "trace", SpanId: "span"})
+	}
+	waitFor := func(condition func() bool, what string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the writer holds the first span while the store is unavailable, the queue takes two more
+	enqueue()
+	waitFor(func() bool { return th.spanQueue.Len() == 0 }, "the writer to take the first span")
+	for i := 0; i < 9; i++ {
+		enqueue()
+	}
+	if counts := recorder.Counts(); counts.SpansDropped != 7 || counts.SpansFailed != 0 || store.puts.Load() != 0 {
+		t.Errorf("expected 7 spans dropped by the queue and none written or failed, got %+v", counts)
+	}
+
+	store.available.Store(true)
+	waitFor(func() bool { return recorder.Counts().Pending() == 0 }, "the held spans to be written")
+	if counts := recorder.Counts(); counts.SpansWritten != 3 || counts.SpansDropped != 7 {
+		t.Errorf("expected the 3 held spans to be written once the store is back, got %+v", counts)
+	}
+}
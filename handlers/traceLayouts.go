@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"redis-test/config"
+	"redis-test/internal/redisstats"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Names of the trace layouts.
+const (
+	LayoutHash         = "hash"
+	LayoutSpanKeys     = "span-keys"
+	LayoutStream       = "stream"
+	LayoutStreamBucket = "stream-bucket"
+	LayoutSortedSet    = "sorted-set"
+)
+
+const (
+	defaultTraceLayout = LayoutHash
+	// scanCount is the default COUNT hint of the SCAN calls deleting the keys of a run.
+	scanCount = 1000
+	// stream entry fields
+	streamTraceField = "trace"
+	streamSpanField  = "span"
+	streamValueField = "value"
+)
+
+// TraceLayout decides which keys and commands hold the spans of a trace, and reads them back the way
+// a consumer of the traces would.
+type TraceLayout interface {
+	// Write queues the commands writing a span, expired with the ttl policy. They are executed with the
+	// next flush.
+	Write(ctx context.Context, pipeline redis.Pipeliner, span Span, ttl *TtlPolicy)
+	// Read returns the span values of a trace by span id.
+	Read(ctx context.Context, client redis.Cmdable, traceId string) (map[string][]byte, error)
+	// Memory measures the memory taken by a trace. Keys shared with other traces are counted in
+	// proportion to the entries of the trace.
+	Memory(ctx context.Context, client redis.Cmdable, traceId string) (redisstats.TraceMemory, error)
+	// Ttl returns the remaining ttl of the key holding the spans of a trace, as redis TTL reports it.
+	Ttl(ctx context.Context, client redis.Cmdable, traceId string) (time.Duration, error)
+}
+
+// traceKeyLayout is implemented by the layouts writing a key per trace that expires with the trace, the
+// expiry watcher tracks it.
+type traceKeyLayout interface {
+	traceKey(traceId string) string
+}
+
+// TraceLayoutFactory creates a TraceLayout from the traces config.
+type TraceLayoutFactory func(config *config.TraceConfig) TraceLayout
+
+var traceLayouts = map[string]TraceLayoutFactory{
+	LayoutHash:      func(*config.TraceConfig) TraceLayout { return hashLayout{} },
+	LayoutSpanKeys:  func(*config.TraceConfig) TraceLayout { return spanKeysLayout{} },
+	LayoutStream:    func(*config.TraceConfig) TraceLayout { return streamLayout{} },
+	LayoutSortedSet: func(*config.TraceConfig) TraceLayout { return sortedSetLayout{} },
+	LayoutStreamBucket: func(config *config.TraceConfig) TraceLayout {
+		return streamBucketLayout{bucket: time.Duration(config.StreamBucketSeconds) * time.Second}
+	},
+}
+
+// TraceLayouts returns the names of the layouts.
+func TraceLayouts() []string {
+	layouts := make([]string, 0, len(traceLayouts))
+	for layout := range traceLayouts {
+		layouts = append(layouts, layout)
+	}
+	sort.Strings(layouts)
+	return layouts
+}
+
+// NewTraceLayout creates the layout configured in traces.layout.
+func NewTraceLayout(config *config.TraceConfig) (TraceLayout, error) {
+	layout := config.Layout
+	if layout == "" {
+		layout = defaultTraceLayout
+	}
+	factory, ok := traceLayouts[layout]
+	if !ok {
+		return nil, fmt.Errorf("unknown trace layout %q, layouts are %v", layout, TraceLayouts())
+	}
+	return factory(config), nil
+}
+
+func expire(ctx context.Context, pipeline redis.Pipeliner, span Span, key string, ttl *TtlPolicy) {
+	if cmd := ttl.Expire(ctx, span, key); cmd != nil {
+		_ = pipeline.Process(ctx, cmd)
+	}
+}
+
+func keyTtl(ctx context.Context, client redis.Cmdable, key string) (time.Duration, error) {
+	return client.TTL(ctx, key).Result()
+}
+
+func spanKey(traceId, spanId string) string {
+	return traceId + ":" + spanId
+}
+
+// hashLayout writes a hash per trace with a field per span.
+type hashLayout struct{}
+
+func (hashLayout) traceKey(traceId string) string {
+	return traceId
+}
+
+func (hashLayout) Write(ctx context.Context, pipeline redis.Pipeliner, span Span, ttl *TtlPolicy) {
+	pipeline.HMSet(ctx, span.TraceId, map[string]string{span.SpanId: string(span.Value)})
+	expire(ctx, pipeline, span, span.TraceId, ttl)
+}
+
+func (hashLayout) Read(ctx context.Context, client redis.Cmdable, traceId string) (map[string][]byte, error) {
+	fields, err := client.HGetAll(ctx, traceId).Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(fields))
+	for spanId, value := range fields {
+		values[spanId] = []byte(value)
+	}
+	return values, nil
+}
+
+func (hashLayout) Memory(ctx context.Context, client redis.Cmdable, traceId string) (redisstats.TraceMemory, error) {
+	return keyMemory(ctx, client, traceId)
+}
+
+func (hashLayout) Ttl(ctx context.Context, client redis.Cmdable, traceId string) (time.Duration, error) {
+	return keyTtl(ctx, client, traceId)
+}
+
+// spanKeysLayout writes a string key per span, with a set per trace indexing its span keys.
+type spanKeysLayout struct{}
+
+func (spanKeysLayout) indexKey(traceId string) string {
+	return traceId + ":spans"
+}
+
+// traceKey is the span index of the trace, it expires with the span keys.
+func (l spanKeysLayout) traceKey(traceId string) string {
+	return l.indexKey(traceId)
+}
+
+func (l spanKeysLayout) Write(ctx context.Context, pipeline redis.Pipeliner, span Span, ttl *TtlPolicy) {
+	key := spanKey(span.TraceId, span.SpanId)
+	_ = pipeline.Process(ctx, ttl.Set(ctx, span, key, span.Value))
+	pipeline.SAdd(ctx, l.indexKey(span.TraceId), key)
+	expire(ctx, pipeline, span, l.indexKey(span.TraceId), ttl)
+}
+
+func (l spanKeysLayout) keys(ctx context.Context, client redis.Cmdable, traceId string) ([]string, error) {
+	return client.SMembers(ctx, l.indexKey(traceId)).Result()
+}
+
+func (l spanKeysLayout) Read(ctx context.Context, client redis.Cmdable, traceId string) (map[string][]byte, error) {
+	keys, err := l.keys(ctx, client, traceId)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return readSpanKeys(ctx, client, traceId, keys)
+}
+
+// Memory sums the span keys and the span index of the trace.
+func (l spanKeysLayout) Memory(ctx context.Context, client redis.Cmdable, traceId string) (redisstats.TraceMemory, error) {
+	keys, err := l.keys(ctx, client, traceId)
+	if err != nil {
+		return redisstats.TraceMemory{}, err
+	}
+	return keysMemory(ctx, client, append(keys, l.indexKey(traceId)))
+}
+
+// Ttl returns the ttl of the span index of the trace, the span keys are written with the same ttl.
+func (l spanKeysLayout) Ttl(ctx context.Context, client redis.Cmdable, traceId string) (time.Duration, error) {
+	return keyTtl(ctx, client, l.indexKey(traceId))
+}
+
+// streamLayout writes a stream per trace with an entry per span.
+type streamLayout struct{}
+
+func (streamLayout) traceKey(traceId string) string {
+	return traceId
+}
+
+func (streamLayout) Write(ctx context.Context, pipeline redis.Pipeliner, span Span, ttl *TtlPolicy) {
+	pipeline.XAdd(ctx, &redis.XAddArgs{Stream: span.TraceId, Values: []string{streamSpanField, span.SpanId, streamValueField, string(span.Value)}})
+	expire(ctx, pipeline, span, span.TraceId, ttl)
+}
+
+func (streamLayout) Read(ctx context.Context, client redis.Cmdable, traceId string) (map[string][]byte, error) {
+	entries, err := client.XRange(ctx, traceId, "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	return streamValues(entries, ""), nil
+}
+
+func (streamLayout) Memory(ctx context.Context, client redis.Cmdable, traceId string) (redisstats.TraceMemory, error) {
+	return keyMemory(ctx, client, traceId)
+}
+
+func (streamLayout) Ttl(ctx context.Context, client redis.Cmdable, traceId string) (time.Duration, error) {
+	return keyTtl(ctx, client, traceId)
+}
+
+// streamBucketLayout writes to a stream per time bucket, with a set per trace indexing its buckets.
+type streamBucketLayout struct {
+	bucket time.Duration
+}
+
+func (l streamBucketLayout) bucketKey(span Span) string {
+	bucket := time.Duration(span.SpanDetails.StartNs)
+	if l.bucket > 0 {
+		bucket = bucket.Truncate(l.bucket)
+	}
+	return span.KeyPrefix + "spans:" + strconv.FormatInt(int64(bucket/time.Second), 10)
+}
+
+func (l streamBucketLayout) indexKey(traceId string) string {
+	return traceId + ":buckets"
+}
+
+// traceKey is the bucket index of the trace, the buckets are shared with other traces.
+func (l streamBucketLayout) traceKey(traceId string) string {
+	return l.indexKey(traceId)
+}
+
+func (l streamBucketLayout) Write(ctx context.Context, pipeline redis.Pipeliner, span Span, ttl *TtlPolicy) {
+	bucketKey := l.bucketKey(span)
+	pipeline.XAdd(ctx, &redis.XAddArgs{Stream: bucketKey, Values: []string{
+		streamTraceField, span.TraceId, streamSpanField, span.SpanId, streamValueField, string(span.Value),
+	}})
+	expire(ctx, pipeline, span, bucketKey, ttl)
+	pipeline.SAdd(ctx, l.indexKey(span.TraceId), bucketKey)
+	expire(ctx, pipeline, span, l.indexKey(span.TraceId), ttl)
+}
+
+// Ttl returns the ttl of the bucket index of the trace, the buckets live as long as their newest trace.
+func (l streamBucketLayout) Ttl(ctx context.Context, client redis.Cmdable, traceId string) (time.Duration, error) {
+	return keyTtl(ctx, client, l.indexKey(traceId))
+}
+
+func (l streamBucketLayout) entries(ctx context.Context, client redis.Cmdable, traceId string) (map[string][]redis.XMessage, error) {
+	bucketKeys, err := client.SMembers(ctx, l.indexKey(traceId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]redis.XMessage, len(bucketKeys))
+	for _, bucketKey := range bucketKeys {
+		bucketEntries, err := client.XRange(ctx, bucketKey, "-", "+").Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range bucketEntries {
+			if entry.Values[streamTraceField] == traceId {
+				entries[bucketKey] = append(entries[bucketKey], entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (l streamBucketLayout) Read(ctx context.Context, client redis.Cmdable, traceId string) (map[string][]byte, error) {
+	entries, err := l.entries(ctx, client, traceId)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	values := make(map[string][]byte)
+	for _, bucketEntries := range entries {
+		for spanId, value := range streamValues(bucketEntries, traceId) {
+			values[spanId] = value
+		}
+	}
+	return values, nil
+}
+
+func (l streamBucketLayout) Memory(ctx context.Context, client redis.Cmdable, traceId string) (redisstats.TraceMemory, error) {
+	entries, err := l.entries(ctx, client, traceId)
+	if err != nil || len(entries) == 0 {
+		return redisstats.TraceMemory{}, err
+	}
+	memory, err := keyMemory(ctx, client, l.indexKey(traceId))
+	if err != nil {
+		return memory, err
+	}
+	for bucketKey, bucketEntries := range entries {
+		bucketMemory, err := keyMemory(ctx, client, bucketKey)
+		if err != nil {
+			return memory, err
+		}
+		length, err := client.XLen(ctx, bucketKey).Result()
+		if err != nil {
+			return memory, err
+		}
+		if length > 0 {
+			memory.Bytes += bucketMemory.Bytes * int64(len(bucketEntries)) / length
+		}
+		memory.Encoding = bucketMemory.Encoding
+	}
+	return memory, nil
+}
+
+// sortedSetLayout scores the span ids of a trace by their start, the values are in a key per span.
+type sortedSetLayout struct{}
+
+func (sortedSetLayout) traceKey(traceId string) string {
+	return traceId
+}
+
+func (sortedSetLayout) Write(ctx context.Context, pipeline redis.Pipeliner, span Span, ttl *TtlPolicy) {
+	pipeline.ZAdd(ctx, span.TraceId, redis.Z{Score: float64(span.SpanDetails.StartNs), Member: span.SpanId})
+	expire(ctx, pipeline, span, span.TraceId, ttl)
+	_ = pipeline.Process(ctx, ttl.Set(ctx, span, spanKey(span.TraceId, span.SpanId), span.Value))
+}
+
+func (sortedSetLayout) Ttl(ctx context.Context, client redis.Cmdable, traceId string) (time.Duration, error) {
+	return keyTtl(ctx, client, traceId)
+}
+
+func (sortedSetLayout) Read(ctx context.Context, client redis.Cmdable, traceId string) (map[string][]byte, error) {
+	spanIds, err := client.ZRange(ctx, traceId, 0, -1).Result()
+	if err != nil || len(spanIds) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(spanIds))
+	for i, spanId := range spanIds {
+		keys[i] = spanKey(traceId, spanId)
+	}
+	return readSpanKeys(ctx, client, traceId, keys)
+}
+
+func (sortedSetLayout) Memory(ctx context.Context, client redis.Cmdable, traceId string) (redisstats.TraceMemory, error) {
+	spanIds, err := client.ZRange(ctx, traceId, 0, -1).Result()
+	if err != nil || len(spanIds) == 0 {
+		return redisstats.TraceMemory{}, err
+	}
+	keys := []string{traceId}
+	for _, spanId := range spanIds {
+		keys = append(keys, spanKey(traceId, spanId))
+	}
+	return keysMemory(ctx, client, keys)
+}
+
+func readSpanKeys(ctx context.Context, client redis.Cmdable, traceId string, keys []string) (map[string][]byte, error) {
+	results, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	prefix := spanKey(traceId, "")
+	values := make(map[string][]byte, len(keys))
+	for i, result := range results {
+		if value, ok := result.(string); ok {
+			values[keys[i][len(prefix):]] = []byte(value)
+		}
+	}
+	return values, nil
+}
+
+// streamValues returns the span values of stream entries, only those of traceId if it is not empty.
+func streamValues(entries []redis.XMessage, traceId string) map[string][]byte {
+	values := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		if traceId != "" && entry.Values[streamTraceField] != traceId {
+			continue
+		}
+		spanId, _ := entry.Values[streamSpanField].(string)
+		value, _ := entry.Values[streamValueField].(string)
+		values[spanId] = []byte(value)
+	}
+	return values
+}
+
+// keysMemory sums the memory of the keys, the encoding is the one of the first key.
+func keysMemory(ctx context.Context, client redis.Cmdable, keys []string) (redisstats.TraceMemory, error) {
+	var memory redisstats.TraceMemory
+	for _, key := range keys {
+		keyMemory, err := keyMemory(ctx, client, key)
+		if err != nil {
+			return memory, err
+		}
+		if !keyMemory.Found {
+			continue
+		}
+		if !memory.Found {
+			memory.Encoding = keyMemory.Encoding
+		}
+		memory.Found = true
+		memory.Bytes += keyMemory.Bytes
+	}
+	return memory, nil
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"redis-test/config"
+	"redis-test/internal/metrics"
+	"redis-test/model"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTraceLayoutsReadWhatTheyWrite(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	ttl := newTtlPolicy(TtlPerSpan, time.Minute, 0, metrics.Target{}, &atomic.Uint64{})
+
+	for _, name := range TraceLayouts() {
+		layout, err := NewTraceLayout(&config.TraceConfig{Layout: name, StreamBucketSeconds: 60})
+		if err != nil {
+			t.Fatalf("unable to create the %s layout: %v", name, err)
+		}
+		traceId := name + ":trace"
+		pipeline := client.Pipeline()
+		for _, spanId := range []string{"a", "b", "c"} {
+			details := model.OTelSpanDetails{StartNs: uint64(time.Minute)}
+			layout.Write(ctx, pipeline, Span{TraceId: traceId, SpanId: spanId, SpanDetails: details, Value: []byte("value " + spanId)}, ttl)
+		}
+		if _, err = pipeline.Exec(ctx); err != nil {
+			t.Fatalf("%s: unable to write the spans: %v", name, err)
+		}
+
+		values, err := layout.Read(ctx, client, traceId)
+		if err != nil || len(values) != 3 || string(values["b"]) != "value b" {
+			t.Errorf("%s: expected the 3 spans to be read back, got %q: %v", name, values, err)
+		}
+		if remaining, err := layout.Ttl(ctx, client, traceId); err != nil || remaining <= 0 || remaining > time.Minute {
+			t.Errorf("%s: expected the trace to expire within a minute, got %v: %v", name, remaining, err)
+		}
+		if keyLayout, ok := layout.(traceKeyLayout); ok {
+			if remaining := server.TTL(keyLayout.traceKey(traceId)); remaining <= 0 || remaining > time.Minute {
+				t.Errorf("%s: expected the key per trace to expire within a minute, got %v", name, remaining)
+			}
+		} else {
+			t.Errorf("%s: expected a key per trace for the expiry watcher", name)
+		}
+		if values, err = layout.Read(ctx, client, name+":missing"); err != nil || len(values) != 0 {
+			t.Errorf("%s: expected no spans for a missing trace, got %q: %v", name, values, err)
+		}
+	}
+	if _, err := NewTraceLayout(&config.TraceConfig{Layout: "tree"}); err == nil {
+		t.Error("expected an unknown layout to be rejected")
+	}
+}
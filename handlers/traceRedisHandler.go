@@ -2,33 +2,92 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
 	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
 	"redis-test/config"
-	"redis-test/model"
+	"redis-test/internal/redisstats"
+	"sync"
 	"time"
 )
 
 var traceRedisHandlerLogTag = "TraceRedisHandler"
 
+const redisTraceStoreBackend = "redis"
+
+var _ TraceStore = (*TraceRedisHandler)(nil)
+var _ redisstats.Source = (*TraceRedisHandler)(nil)
+var _ redisstats.TraceMemoryProbe = (*TraceRedisHandler)(nil)
+var _ TraceReader = (*TraceRedisHandler)(nil)
+var _ WriteModeStore = (*TraceRedisHandler)(nil)
+var _ TraceTtlProbe = (*TraceRedisHandler)(nil)
+var _ ExpiryStatsSource = (*TraceRedisHandler)(nil)
+var _ KeyCleaner = (*TraceRedisHandler)(nil)
+var _ WriteProber = (*TraceRedisHandler)(nil)
+
+func init() {
+	RegisterTraceStore(redisTraceStoreBackend, func(config *config.AppConfigs) (TraceStore, error) {
+		return NewTracesRedisHandler(config)
+	})
+}
+
+var ErrRedisUnavailable = errors.New("redis connection is not healthy")
+
 type TraceRedisHandler struct {
 	redisHandler *RedisHandler
-	ctx          context.Context
-	config       *config.AppConfigs
+	layout       TraceLayout
+	// writers write the spans of the scripted write modes, loaded holds the modes whose script was
+	// loaded once. Scripts lost later on are loaded again when their calls fail with NOSCRIPT.
+	writers  map[string]BatchWriter
+	txWriter BatchWriter
+	// ttlPolicies expire the keys of the spans by ttl strategy.
+	ttlPolicies map[string]*TtlPolicy
+	// expiryWatcher is nil unless expiryWatcher.enabled is set.
+	expiryWatcher *ExpiryWatcher
+	writersMutex  sync.Mutex
+	loaded        map[string]bool
+	ctx           context.Context
+	config        *config.AppConfigs
 }
 
 func NewTracesRedisHandler(otlpConfig *config.AppConfigs) (*TraceRedisHandler, error) {
-	redisHandler, err := NewRedisHandler(&otlpConfig.Redis, clientDBNames.TraceDBName, otlpConfig.Traces.SyncDurationMS, otlpConfig.Traces.SyncBatchSize, traceRedisHandlerLogTag)
+	layout, err := NewTraceLayout(&otlpConfig.Traces)
+	if err != nil {
+		logger.Error(traceRedisHandlerLogTag, "Error while creating trace layout ", err)
+		return nil, err
+	}
+
+	redisHandler, err := NewRedisHandler(&otlpConfig.Redis, clientDBNames.TraceDBName, otlpConfig.Traces.SyncDurationMS, otlpConfig.Traces.SyncBatchSize, otlpConfig.Traces.HealthCheckIntervalMS, traceRedisHandlerLogTag)
 
 	if err != nil {
 		logger.Error(traceRedisHandlerLogTag, "Error while creating redis client ", err)
+		return nil, err
 	}
 
+	ttl := time.Duration(otlpConfig.Traces.Ttl) * time.Second
 	handler := &TraceRedisHandler{
 		redisHandler: redisHandler,
-		ctx:          context.Background(),
-		config:       otlpConfig,
+		layout:       layout,
+		writers: map[string]BatchWriter{
+			WriteModeLua:      newLuaSpanWriter(ttl),
+			WriteModeFunction: &functionSpanWriter{ttl: ttl},
+		},
+		ttlPolicies: make(map[string]*TtlPolicy),
+		loaded:      make(map[string]bool),
+		ctx:         context.Background(),
+		config:      otlpConfig,
+	}
+	for _, strategy := range TtlStrategies() {
+		handler.ttlPolicies[strategy] = newTtlPolicy(strategy, ttl, otlpConfig.Traces.TtlJitterPercent, redisHandler.metrics, &redisHandler.flushes)
+	}
+	handler.txWriter = &txSpanWriter{layout: layout, ttl: handler.ttlPolicy}
+	if otlpConfig.ExpiryWatcher.Enabled {
+		// the keys are expired when their flush is executed, up to a sync interval after they were queued
+		tolerance := time.Second + time.Duration(otlpConfig.Traces.SyncDurationMS)*time.Millisecond
+		handler.expiryWatcher = NewExpiryWatcher(redisHandler, otlpConfig.ExpiryWatcher, ttl, tolerance)
+		handler.expiryWatcher.Start()
 	}
 
 	return handler, nil
@@ -38,28 +97,259 @@ func (h *TraceRedisHandler) CheckRedisConnection() error {
 	return h.redisHandler.CheckRedisConnection()
 }
 
-func (h *TraceRedisHandler) PutTraceData(traceId string, spanId string, spanDetails model.OTelSpanDetails) error {
+// Available reports the connection state last observed by the health monitor.
+func (h *TraceRedisHandler) Available() bool {
+	return h.redisHandler.IsHealthy()
+}
 
-	if err := h.redisHandler.CheckRedisConnection(); err != nil {
-		logger.Error(traceRedisHandlerLogTag, "Error while checking redis conn ", err)
-		return err
+func (h *TraceRedisHandler) PutSpan(span Span) error {
+
+	if !h.redisHandler.IsHealthy() {
+		return ErrRedisUnavailable
 	}
 
-	spanJsonMap := make(map[string]string)
-	spanJSON, err := json.Marshal(spanDetails)
+	writeMode := span.WriteMode
+	if writeMode == "" {
+		writeMode = WriteModePipeline
+	}
+	pendingWrite := PendingWrite{RunId: span.RunId, TraceId: span.TraceId, EnqueuedAt: span.EnqueuedAt, Bytes: len(span.Value), WriteMode: writeMode}
+	ttl := h.ttlPolicy(span)
+
+	var err error
+	if writer, ok := h.writers[writeMode]; ok {
+		err = h.redisHandler.BatchFor(span.TraceId, pendingWrite, span, writer)
+	} else if writeMode == WriteModeTx {
+		err = h.redisHandler.TxBatchFor(span.TraceId, pendingWrite, span, h.txWriter)
+	} else if writeMode == WriteModeDirect {
+		err = h.redisHandler.DirectFor(pendingWrite, func() (int, error) {
+			return h.putSpanDirect(span, ttl)
+		})
+	} else {
+		err = h.redisHandler.PipelineFor(pendingWrite, func(pipeline redis.Pipeliner) {
+			h.layout.Write(h.ctx, pipeline, span, ttl)
+			indexScenarios(h.ctx, pipeline, span, ttl)
+		})
+	}
 	if err != nil {
-		logger.Debug(traceRedisHandlerLogTag, "Error encoding SpanDetails for spanID %s: %v\n", spanId, err)
+		logger.Error(traceRedisHandlerLogTag, "Error while setting trace details for traceId %s: %v\n", span.TraceId, err)
 		return err
 	}
-	spanJsonMap[spanId] = string(spanJSON)
-	err = h.redisHandler.HMSetPipeline(traceId, spanJsonMap, time.Duration(h.config.Traces.Ttl)*time.Second)
-	if err != nil {
-		logger.Error(traceRedisHandlerLogTag, "Error while setting trace details for traceId %s: %v\n", traceId, err)
+	if h.expiryWatcher != nil {
+		h.trackExpiry(span, ttl)
+	}
+	return nil
+}
+
+// trackExpiry tracks the key per trace of the layout, and keeps the deadline of scripted writes, the
+// scripts only expire a trace key they create. Layouts without a key per trace are not tracked.
+func (h *TraceRedisHandler) trackExpiry(span Span, ttl *TtlPolicy) {
+	layout, ok := h.layout.(traceKeyLayout)
+	if !ok {
+		return
+	}
+	deadline, keep, ok := ttl.Deadline(span, time.Now())
+	if !ok {
+		return
+	}
+	_, scripted := h.writers[span.WriteMode]
+	h.expiryWatcher.Track(layout.traceKey(span.TraceId), deadline, keep || scripted)
+}
+
+func (h *TraceRedisHandler) ttlPolicy(span Span) *TtlPolicy {
+	strategy := span.TtlStrategy
+	if strategy == "" {
+		strategy = h.config.Traces.TtlStrategy
+	}
+	if policy, ok := h.ttlPolicies[strategy]; ok {
+		return policy
+	}
+	return h.ttlPolicies[defaultTtlStrategy]
+}
+
+func (h *TraceRedisHandler) putSpanDirect(span Span, ttl *TtlPolicy) (int, error) {
+	commands := 0
+	send := func(err error) error {
+		commands++
 		return err
 	}
+	expire := func(key string) error {
+		cmd := ttl.Expire(h.ctx, span, key)
+		if cmd == nil {
+			return nil
+		}
+		return send(h.redisHandler.Process(span.RunId, cmd))
+	}
+
+	// the direct mode needs the hash layout, its key per trace starts with the key prefix of the run
+	traceKey := h.layout.(traceKeyLayout).traceKey(span.TraceId)
+	if err := send(h.redisHandler.HMSet(span.RunId, traceKey, map[string]string{span.SpanId: string(span.Value)})); err != nil {
+		return commands, err
+	}
+	if err := expire(traceKey); err != nil {
+		return commands, err
+	}
+	for scenario := range span.SpanDetails.GroupBy {
+		key := scenarioIndexKey(span.KeyPrefix, scenario)
+		if err := send(h.redisHandler.SAdd(span.RunId, key, span.TraceId)); err != nil {
+			return commands, err
+		}
+		if err := expire(key); err != nil {
+			return commands, err
+		}
+	}
+	return commands, nil
+}
+
+// CheckWriteMode loads the script of a scripted write mode the first time it is used. The scripts and
+// the direct mode write hashes, so they need the hash layout.
+func (h *TraceRedisHandler) CheckWriteMode(mode string) error {
+	writer, scripted := h.writers[mode]
+	if !scripted && mode != WriteModeDirect {
+		return nil
+	}
+	if layout := h.layoutName(); layout != LayoutHash {
+		return fmt.Errorf("%w: %s writes need the %s layout, not %s", ErrWriteModeUnsupported, mode, LayoutHash, layout)
+	}
+	if !scripted {
+		return nil
+	}
+
+	h.writersMutex.Lock()
+	defer h.writersMutex.Unlock()
+	if h.loaded[mode] {
+		return nil
+	}
+	if err := writer.Load(h.ctx, h.redisHandler.client()); err != nil {
+		return fmt.Errorf("%w: unable to load the %s script: %v", ErrWriteModeUnsupported, mode, err)
+	}
+	h.loaded[mode] = true
 	return nil
 }
 
-func (h *TraceRedisHandler) SyncPipeline() {
+func (h *TraceRedisHandler) Flush() {
 	h.redisHandler.SyncPipeline()
 }
+
+func (h *TraceRedisHandler) Close() {
+	if h.expiryWatcher != nil {
+		h.expiryWatcher.Stop()
+	}
+	h.redisHandler.shutdown()
+}
+
+// ExpiryStats returns what the expiry watcher observed, Enabled is false if it does not run.
+func (h *TraceRedisHandler) ExpiryStats() ExpiryStats {
+	if h.expiryWatcher == nil {
+		return ExpiryStats{}
+	}
+	stats := h.expiryWatcher.Stats()
+	if _, ok := h.layout.(traceKeyLayout); !ok {
+		stats.TrackingDisabled = fmt.Sprintf("the %s layout has no key per trace", h.layoutName())
+	}
+	return stats
+}
+
+func (h *TraceRedisHandler) Stats() TraceStoreStats {
+	stats := TraceStoreStats{
+		Backend: redisTraceStoreBackend,
+		Layout:  h.layoutName(),
+		Healthy: h.redisHandler.IsHealthy(),
+	}
+	commands := h.redisHandler.CommandStats()
+	for _, executed := range commands.Executed {
+		stats.Writes += executed
+	}
+	for _, errors := range commands.ByClass {
+		stats.WriteErrors += errors
+	}
+	return stats
+}
+
+func (h *TraceRedisHandler) HealthStatus() RedisHealthStatus {
+	return h.redisHandler.HealthStatus()
+}
+
+func (h *TraceRedisHandler) CommandStats() CommandStatsSnapshot {
+	return h.redisHandler.CommandStats()
+}
+
+func (h *TraceRedisHandler) ServerInfo() (redisstats.Info, error) {
+	return h.redisHandler.ServerInfo()
+}
+
+func (h *TraceRedisHandler) SlowLog(count int64) ([]redisstats.SlowLogEntry, error) {
+	return h.redisHandler.SlowLog(count)
+}
+
+func (h *TraceRedisHandler) LatencyLatest() ([]redisstats.LatencyEvent, error) {
+	return h.redisHandler.LatencyLatest()
+}
+
+// TraceMemory measures the keys a trace is written to.
+func (h *TraceRedisHandler) TraceMemory(traceId string) (redisstats.TraceMemory, error) {
+	return h.layout.Memory(h.ctx, h.redisHandler.client(), traceId)
+}
+
+// TraceTtl returns the remaining ttl of the keys of a trace with the layout.
+func (h *TraceRedisHandler) TraceTtl(traceId string) (time.Duration, error) {
+	return h.layout.Ttl(h.ctx, h.redisHandler.client(), traceId)
+}
+
+// ProbeWrite sets a key with SET ... EX, outside of the pipeline.
+func (h *TraceRedisHandler) ProbeWrite(key string, ttl time.Duration) (time.Duration, error) {
+	start := time.Now()
+	err := h.redisHandler.client().Set(h.ctx, key, "probe", ttl).Err()
+	return time.Since(start), err
+}
+
+// ReadTrace reads the span values of a trace with the reader of the layout.
+func (h *TraceRedisHandler) ReadTrace(traceId string) (map[string][]byte, error) {
+	return h.layout.Read(h.ctx, h.redisHandler.client(), traceId)
+}
+
+// DeleteKeys scans the keys starting with keyPrefix and unlinks them a page at a time, throttled to
+// keysPerSecond.
+func (h *TraceRedisHandler) DeleteKeys(ctx context.Context, keyPrefix string, keysPerSecond int, count int) (CleanupResult, error) {
+	result := CleanupResult{KeyPrefix: keyPrefix}
+	start := time.Now()
+	err := h.unlinkKeys(ctx, &result, start, keysPerSecond, count)
+	result.DurationSeconds = time.Since(start).Seconds()
+	return result, err
+}
+
+func (h *TraceRedisHandler) unlinkKeys(ctx context.Context, result *CleanupResult, start time.Time, keysPerSecond int, count int) error {
+	if count <= 0 {
+		count = scanCount
+	}
+	client := h.redisHandler.client()
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, prefixPattern(result.KeyPrefix), int64(count)).Result()
+		if err != nil {
+			return err
+		}
+		for len(keys) > 0 {
+			batch := keys[:min(len(keys), count)]
+			keys = keys[len(batch):]
+			removed, err := client.Unlink(ctx, batch...).Result()
+			if err != nil {
+				return err
+			}
+			result.Scanned += int64(len(batch))
+			result.Removed += removed
+			if !throttle(ctx, start, result.Scanned, keysPerSecond) {
+				return ctx.Err()
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (h *TraceRedisHandler) layoutName() string {
+	if h.config.Traces.Layout == "" {
+		return defaultTraceLayout
+	}
+	return h.config.Traces.Layout
+}
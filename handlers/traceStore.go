@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fmt"
+	"redis-test/config"
+	"redis-test/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultTraceStoreBackend = "redis"
+
+// Span is a generated span on its way to a TraceStore.
+type Span struct {
+	RunId       string
+	TraceId     string
+	SpanId      string
+	SpanDetails model.OTelSpanDetails
+	// Codec is the name of the codec the span is encoded with, the configured codec if empty.
+	Codec string
+	// Value is the encoded SpanDetails, it is set before the span is put in the store.
+	Value []byte
+	// WriteMode is how the store writes the span, the pipeline if empty.
+	WriteMode string
+	// TtlStrategy is how the store expires the keys of the span, the configured strategy if empty.
+	TtlStrategy string
+	// TraceStart is when the first span of the trace was generated.
+	TraceStart time.Time
+	// TtlJitter places the ttl of the trace in the range of the jitter ttl strategy, see ExpectedTrace.
+	TtlJitter float64
+	// KeyPrefix starts the keys shared by the traces of a namespaced run, e.g. the scenario indexes.
+	// The trace id of the span already starts with it.
+	KeyPrefix  string
+	EnqueuedAt time.Time
+}
+
+// TraceStore is a storage backend the generated spans are written to.
+// Stores that can report the state of the server behind them also implement redisstats.Source.
+type TraceStore interface {
+	// PutSpan writes a span or buffers it until the next flush.
+	PutSpan(span Span) error
+	// Flush writes the buffered spans out if a batch is due.
+	Flush()
+	// Close writes all the buffered spans and releases the connections.
+	Close()
+	Stats() TraceStoreStats
+}
+
+// TraceReader is implemented by the stores that can read the traces they wrote back.
+type TraceReader interface {
+	// ReadTrace returns the span values of a trace by span id, none if the trace is not found.
+	ReadTrace(traceId string) (map[string][]byte, error)
+}
+
+// AvailabilityReporter is implemented by the stores that can tell when their server is unreachable.
+type AvailabilityReporter interface {
+	Available() bool
+}
+
+// WriteProber is implemented by the stores that can time a single write outside of the batches.
+type WriteProber interface {
+	// ProbeWrite writes a key expiring after ttl and returns how long the write took.
+	ProbeWrite(key string, ttl time.Duration) (time.Duration, error)
+}
+
+// TraceStoreStats describes the state of a TraceStore and what it has written so far. Writes is the
+// number of write operations sent to the backend, WriteErrors the number of them that failed.
+type TraceStoreStats struct {
+	Backend     string `json:"backend"`
+	Layout      string `json:"layout,omitempty"`
+	Healthy     bool   `json:"healthy"`
+	Writes      int64  `json:"writes"`
+	WriteErrors int64  `json:"writeErrors"`
+}
+
+// HealthReporter is implemented by the stores that monitor the connection to their server.
+type HealthReporter interface {
+	HealthStatus() RedisHealthStatus
+}
+
+// CommandStatsReporter is implemented by the stores that count the redis commands they send.
+type CommandStatsReporter interface {
+	CommandStats() CommandStatsSnapshot
+}
+
+// TraceStoreFactory creates a TraceStore from the application config.
+type TraceStoreFactory func(config *config.AppConfigs) (TraceStore, error)
+
+var (
+	traceStoreFactoriesMutex sync.RWMutex
+	traceStoreFactories      = make(map[string]TraceStoreFactory)
+)
+
+// RegisterTraceStore makes a backend selectable through the traces.backend config.
+func RegisterTraceStore(backend string, factory TraceStoreFactory) {
+	traceStoreFactoriesMutex.Lock()
+	defer traceStoreFactoriesMutex.Unlock()
+	traceStoreFactories[backend] = factory
+}
+
+// TraceStoreBackends returns the names of the registered backends.
+func TraceStoreBackends() []string {
+	traceStoreFactoriesMutex.RLock()
+	defer traceStoreFactoriesMutex.RUnlock()
+
+	backends := make([]string, 0, len(traceStoreFactories))
+	for backend := range traceStoreFactories {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return backends
+}
+
+// NewTraceStore creates the backend configured in traces.backend.
+func NewTraceStore(config *config.AppConfigs) (TraceStore, error) {
+	backend := config.Traces.Backend
+	if backend == "" {
+		backend = defaultTraceStoreBackend
+	}
+
+	traceStoreFactoriesMutex.RLock()
+	factory, ok := traceStoreFactories[backend]
+	traceStoreFactoriesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown trace store backend %q, registered backends are %v", backend, TraceStoreBackends())
+	}
+	return factory(config)
+}
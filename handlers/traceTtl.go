@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"redis-test/internal/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Names of the ttl strategies, they decide which expiry commands are sent for the keys of the spans.
+const (
+	// TtlPerSpan sends an EXPIRE for every key of every span.
+	TtlPerSpan = "per-span"
+	// TtlPerBatch sends an EXPIRE once per key and pipeline flush.
+	TtlPerBatch = "per-batch"
+	// TtlExpireAt sends an EXPIREAT for every key of every span, at the start of the trace plus the ttl.
+	TtlExpireAt = "expireat"
+	// TtlNx sends an EXPIRE ... NX for every key of every span, which only sets the ttl of keys without one.
+	TtlNx = "nx"
+	// TtlJitter sends an EXPIRE for every key of every span, with the ttl of each trace moved by up to
+	// traces.ttlJitterPercent so that traces written together do not expire together.
+	TtlJitter = "jitter"
+	// TtlNone sends no expiry, the keys are kept until they are evicted or deleted.
+	TtlNone = "none"
+)
+
+const defaultTtlStrategy = TtlPerSpan
+
+var ErrUnknownTtlStrategy = errors.New("unknown ttl strategy")
+
+// TraceTtlProbe is implemented by the stores that can tell the remaining ttl of a trace.
+type TraceTtlProbe interface {
+	// TraceTtl returns the remaining ttl of the keys of a trace, -1 if they have none and -2 if the
+	// trace is not found.
+	TraceTtl(traceId string) (time.Duration, error)
+}
+
+// TtlStrategies returns the names of the ttl strategies.
+func TtlStrategies() []string {
+	return []string{TtlExpireAt, TtlJitter, TtlNone, TtlNx, TtlPerBatch, TtlPerSpan}
+}
+
+func isTtlStrategy(strategy string) bool {
+	for _, ttlStrategy := range TtlStrategies() {
+		if strategy == ttlStrategy {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTtlStrategy rejects the strategies the scripts or the unflushed direct writes cannot apply.
+func checkTtlStrategy(strategy string, writeMode string) error {
+	if strategy == "" || strategy == defaultTtlStrategy {
+		return nil
+	}
+	if !isTtlStrategy(strategy) {
+		return fmt.Errorf("%w %q, ttl strategies are %v", ErrUnknownTtlStrategy, strategy, TtlStrategies())
+	}
+	switch {
+	case writeMode == WriteModeLua || writeMode == WriteModeFunction:
+		return fmt.Errorf("%w: %s writes expire the keys in their script, only with the %s ttl strategy", ErrWriteModeUnsupported, writeMode, defaultTtlStrategy)
+	case writeMode == WriteModeDirect && strategy == TtlPerBatch:
+		return fmt.Errorf("%w: %s writes are not flushed, so they cannot use the %s ttl strategy", ErrWriteModeUnsupported, writeMode, strategy)
+	}
+	return nil
+}
+
+// TtlPolicy builds the expiry commands of the keys of the spans with a ttl strategy, and counts the
+// commands it sends and the ones it saves compared to expiring every key for every span.
+type TtlPolicy struct {
+	strategy string
+	ttl      time.Duration
+	jitter   float64
+	metrics  metrics.Target
+	// flushes is the flush count of the pipeline, expired holds the keys expired since the last flush.
+	flushes *atomic.Uint64
+	mutex   sync.Mutex
+	flush   uint64
+	expired map[string]bool
+}
+
+func newTtlPolicy(strategy string, ttl time.Duration, jitterPercent int, target metrics.Target, flushes *atomic.Uint64) *TtlPolicy {
+	return &TtlPolicy{
+		strategy: strategy,
+		ttl:      ttl,
+		jitter:   float64(jitterPercent) / 100,
+		metrics:  target,
+		flushes:  flushes,
+		expired:  make(map[string]bool),
+	}
+}
+
+// Expire returns the command expiring a key a span was written to, or nil if the strategy sends none.
+func (p *TtlPolicy) Expire(ctx context.Context, span Span, key string) redis.Cmder {
+	if p.ttl <= 0 {
+		return nil
+	}
+	var cmd redis.Cmder
+	switch p.strategy {
+	case TtlPerBatch:
+		if p.firstInFlush(key) {
+			cmd = redis.NewBoolCmd(ctx, "expire", key, int64(p.ttl/time.Second))
+		}
+	case TtlExpireAt:
+		cmd = redis.NewBoolCmd(ctx, "expireat", key, p.expireAt(span).Unix())
+	case TtlNx:
+		cmd = redis.NewBoolCmd(ctx, "expire", key, int64(p.ttl/time.Second), "nx")
+	case TtlJitter:
+		cmd = redis.NewBoolCmd(ctx, "expire", key, int64(p.traceTtl(span)/time.Second))
+	case TtlNone:
+	default:
+		cmd = redis.NewBoolCmd(ctx, "expire", key, int64(p.ttl/time.Second))
+	}
+	p.metrics.ExpiryQueued(span.RunId, p.strategy, cmd != nil)
+	return cmd
+}
+
+// Deadline returns when a key of a span written now is meant to expire with the strategy, and whether
+// a deadline set by an earlier span of the key is kept. ok is false if the key is not expired.
+func (p *TtlPolicy) Deadline(span Span, now time.Time) (deadline time.Time, keep bool, ok bool) {
+	if p.ttl <= 0 {
+		return time.Time{}, false, false
+	}
+	switch p.strategy {
+	case TtlNone:
+		return time.Time{}, false, false
+	case TtlExpireAt:
+		return p.expireAt(span), true, true
+	case TtlNx:
+		return now.Add(p.ttl), true, true
+	case TtlJitter:
+		return now.Add(p.traceTtl(span)), false, true
+	default:
+		return now.Add(p.ttl), false, true
+	}
+}
+
+// Set returns the command writing a key of a span with its value and its expiry, as the strategy
+// would expire the key of a new trace.
+func (p *TtlPolicy) Set(ctx context.Context, span Span, key string, value interface{}) redis.Cmder {
+	args := redis.SetArgs{}
+	if p.ttl > 0 {
+		switch p.strategy {
+		case TtlExpireAt:
+			args.ExpireAt = p.expireAt(span)
+		case TtlJitter:
+			args.TTL = p.traceTtl(span)
+		case TtlNone:
+		default:
+			args.TTL = p.ttl
+		}
+	}
+	return redis.NewStatusCmd(ctx, setArgs(key, value, args)...)
+}
+
+func setArgs(key string, value interface{}, args redis.SetArgs) []interface{} {
+	cmdArgs := []interface{}{"set", key, value}
+	if !args.ExpireAt.IsZero() {
+		return append(cmdArgs, "exat", args.ExpireAt.Unix())
+	}
+	if args.TTL > 0 {
+		return append(cmdArgs, "ex", int64(args.TTL/time.Second))
+	}
+	return cmdArgs
+}
+
+func (p *TtlPolicy) firstInFlush(key string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if flush := p.flushes.Load(); flush != p.flush {
+		p.flush = flush
+		p.expired = make(map[string]bool)
+	}
+	if p.expired[key] {
+		return false
+	}
+	p.expired[key] = true
+	return true
+}
+
+func (p *TtlPolicy) expireAt(span Span) time.Time {
+	start := span.TraceStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return start.Add(p.ttl)
+}
+
+// traceTtl is the ttl moved by the jitter the run drew for the trace from its seed, so it is the same
+// for all the keys of the trace and again when the run is repeated.
+func (p *TtlPolicy) traceTtl(span Span) time.Duration {
+	ttl := time.Duration(float64(p.ttl) * (1 + p.jitter*span.TtlJitter))
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
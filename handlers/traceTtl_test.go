@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"redis-test/internal/metrics"
+	"redis-test/model"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTtlPolicyExpiryCommands(t *testing.T) {
+	ctx := context.Background()
+	traceStart := time.Unix(1700000000, 0)
+	span := Span{TraceId: "trace", SpanId: "span", TraceStart: traceStart}
+	for _, test := range []struct {
+		strategy string
+		expected string
+	}{
+		{TtlPerSpan, "expire trace 60"},
+		{TtlNx, "expire trace 60 nx"},
+		{TtlExpireAt, fmt.Sprintf("expireat trace %d", traceStart.Add(time.Minute).Unix())},
+		{TtlNone, ""},
+	} {
+		policy := newTtlPolicy(test.strategy, time.Minute, 0, metrics.Target{}, &atomic.Uint64{})
+		cmd := policy.Expire(ctx, span, "trace")
+		if test.expected == "" {
+			if cmd != nil {
+				t.Errorf("%s: expected no expiry, got %v", test.strategy, cmd.Args())
+			}
+			continue
+		}
+		if cmd == nil || joinArgs(cmd.Args()) != test.expected {
+			t.Errorf("%s: expected %q, got %v", test.strategy, test.expected, cmd)
+		}
+	}
+}
+
+func joinArgs(args []interface{}) string {
+	joined := ""
+	for i, arg := range args {
+		if i > 0 {
+			joined += " "
+		}
+		joined += fmt.Sprint(arg)
+	}
+	return joined
+}
+
+func TestTtlPolicyPerBatchExpiresOncePerFlush(t *testing.T) {
+	ctx := context.Background()
+	flushes := &atomic.Uint64{}
+	policy := newTtlPolicy(TtlPerBatch, time.Minute, 0, metrics.Target{}, flushes)
+	expired := func(key string) bool {
+		return policy.Expire(ctx, Span{TraceId: key}, key) != nil
+	}
+	if !expired("a") || expired("a") || !expired("b") {
+		t.Error("expected every key to be expired once before the flush")
+	}
+	flushes.Add(1)
+	if !expired("a") {
+		t.Error("expected the key to be expired again after the flush")
+	}
+}
+
+func TestTtlPolicyJitterFollowsTheSeed(t *testing.T) {
+	policy := newTtlPolicy(TtlJitter, 100*time.Second, 10, metrics.Target{}, &atomic.Uint64{})
+	ttls := func(seed int64) []time.Duration {
+		var ttls []time.Duration
+		ExpectedTraces(model.RunParameters{Seed: seed, TraceCount: 100}, func(trace ExpectedTrace) bool {
+			ttls = append(ttls, policy.traceTtl(Span{TraceId: trace.TraceId, TtlJitter: trace.TtlJitter}))
+			return true
+		})
+		return ttls
+	}
+
+	first := ttls(7)
+	distinct := make(map[time.Duration]bool)
+	for _, ttl := range first {
+		if ttl < 90*time.Second || ttl > 110*time.Second {
+			t.Fatalf("expected a ttl within 10%% of 100s, got %v", ttl)
+		}
+		distinct[ttl] = true
+	}
+	if len(distinct) < 50 {
+		t.Errorf("expected the ttls to be spread, got %d distinct ones", len(distinct))
+	}
+	if !reflect.DeepEqual(ttls(7), first) {
+		t.Error("expected the same ttls from the same seed")
+	}
+	if reflect.DeepEqual(ttls(8), first) {
+		t.Error("expected other ttls from another seed")
+	}
+}
+
+func TestCheckTtlStrategy(t *testing.T) {
+	for _, test := range []struct {
+		strategy, writeMode string
+		err                 error
+	}{
+		{"", WriteModeLua, nil},
+		{TtlNx, WriteModePipeline, nil},
+		{"forever", WriteModePipeline, ErrUnknownTtlStrategy},
+		{TtlNx, WriteModeLua, ErrWriteModeUnsupported},
+		{TtlPerBatch, WriteModeDirect, ErrWriteModeUnsupported},
+		{TtlExpireAt, WriteModeDirect, nil},
+	} {
+		if err := checkTtlStrategy(test.strategy, test.writeMode); !errors.Is(err, test.err) {
+			t.Errorf("%s with %s: expected %v, got %v", test.strategy, test.writeMode, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"redis-test/model"
+	"sort"
+	"time"
+)
+
+// Names of the verify modes, they decide which traces of a run are verified once it is over.
+const (
+	VerifySample = "sample"
+	VerifyAll    = "all"
+	VerifyOff    = "off"
+)
+
+var ErrUnknownVerifyMode = errors.New("unknown verify mode")
+
+// VerifyModes returns the names of the verify modes.
+func VerifyModes() []string {
+	return []string{VerifyAll, VerifyOff, VerifySample}
+}
+
+// CheckVerifyMode returns an error if the verify mode is not known. Empty is the sample mode.
+func CheckVerifyMode(mode string) error {
+	switch mode {
+	case "", VerifySample, VerifyAll, VerifyOff:
+		return nil
+	}
+	return fmt.Errorf("%w %q, verify modes are %v", ErrUnknownVerifyMode, mode, VerifyModes())
+}
+
+const hexChars = "0123456789abcdef"
+
+// runIds generates the same ids again from the same seed, so the traces need not be kept to verify them.
+type runIds struct {
+	random *rand.Rand
+}
+
+func newRunIds(seed int64) runIds {
+	return runIds{random: rand.New(rand.NewSource(seed))}
+}
+
+func (g runIds) hex(length int) string {
+	result := make([]byte, length)
+	for i := range result {
+		result[i] = hexChars[g.random.Intn(len(hexChars))]
+	}
+	return string(result)
+}
+
+// ExpectedTrace is a trace of a run as it was generated.
+type ExpectedTrace struct {
+	Index   int
+	TraceId string
+	// Codec encodes the span values, the configured codec if empty.
+	Codec string
+	// SpanIds are in the order they were generated, every span is the parent of the next one.
+	SpanIds []string
+	// TtlJitter places the ttl of the trace in the range of the jitter ttl strategy, from -1 to 1.
+	TtlJitter float64
+}
+
+// ExpectedTraces generates the traces of a run from its seed and calls visit with each of them in
+// turn, until visit returns false. The trace ids of a namespaced run start with its key prefix, and
+// the ttl jitter of the traces is drawn from the seed as well.
+func ExpectedTraces(parameters model.RunParameters, visit func(trace ExpectedTrace) bool) {
+	ids := newRunIds(parameters.Seed)
+	for traceIndex := 0; traceIndex < parameters.TraceCount; traceIndex++ {
+		trace := ExpectedTrace{Index: traceIndex, TraceId: parameters.KeyPrefix + "00-aaaa" + ids.hex(28), SpanIds: make([]string, parameters.SpansPerTrace)}
+		if len(parameters.Codecs) > 0 {
+			trace.Codec = parameters.Codecs[traceIndex%len(parameters.Codecs)]
+		}
+		for spanIndex := range trace.SpanIds {
+			trace.SpanIds[spanIndex] = ids.hex(16)
+		}
+		trace.TtlJitter = ids.random.Float64()*2 - 1
+		if !visit(trace) {
+			return
+		}
+	}
+}
+
+// TraceVerification is the result of reading a trace back and comparing it with the generated trace.
+type TraceVerification struct {
+	TraceId string
+	Found   bool
+	// Spans is the number of spans read.
+	Spans      int
+	Missing    int
+	Corrupt    int
+	Orphaned   int
+	Unexpected int
+	// Rooted is set if the spans form a single tree rooted in the first span of the trace.
+	Rooted bool
+	// TtlChecked is set if the store reported the ttl of the trace, TtlInRange if it is in the range
+	// the ttl strategy sets.
+	TtlChecked bool
+	TtlInRange bool
+	Problems   []model.SpanProblem
+}
+
+// VerifyTrace reads a trace back and checks that every generated span is found and decodes, that the
+// spans rebuild a single tree rooted in the first span and that the ttl of the trace is in the range
+// the ttl strategy of the run sets. The read latency is recorded for the run. It returns
+// ErrTraceReadUnsupported if the store cannot read traces.
+func (th *TraceHandler) VerifyTrace(runId string, expected ExpectedTrace, ttlStrategy string, runStart time.Time) (TraceVerification, error) {
+	verification := TraceVerification{TraceId: expected.TraceId}
+	reader := th.Reader()
+	if reader == nil {
+		return verification, ErrTraceReadUnsupported
+	}
+	spanCodec, err := th.Codec(expected.Codec)
+	if err != nil {
+		return verification, err
+	}
+	start := time.Now()
+	values, err := reader.ReadTrace(expected.TraceId)
+	if err != nil {
+		return verification, err
+	}
+	th.metrics.TraceRead(runId, time.Since(start))
+
+	problem := func(spanId string, kind string, detail string) {
+		verification.Problems = append(verification.Problems, model.SpanProblem{TraceId: expected.TraceId, SpanId: spanId, Problem: kind, Detail: detail})
+	}
+	verification.Spans = len(values)
+	verification.Found = len(values) > 0
+	if !verification.Found {
+		verification.Missing = len(expected.SpanIds)
+		problem("", model.ProblemMissing, "trace not found")
+		return verification, nil
+	}
+
+	generated := make(map[string]bool, len(expected.SpanIds))
+	for _, spanId := range expected.SpanIds {
+		generated[spanId] = true
+		if _, ok := values[spanId]; !ok {
+			verification.Missing++
+			problem(spanId, model.ProblemMissing, "")
+		}
+	}
+	parents := make(map[string]string, len(values))
+	for spanId, value := range values {
+		if !generated[spanId] {
+			verification.Unexpected++
+			problem(spanId, model.ProblemUnexpected, "")
+		}
+		span, err := spanCodec.Decode(value)
+		if err != nil {
+			verification.Corrupt++
+			problem(spanId, model.ProblemCorrupt, err.Error())
+			continue
+		}
+		parents[spanId] = span.ParentSpanId
+	}
+
+	var roots []string
+	for spanId, parentId := range parents {
+		if parentId == model.DefaultParentSpanId {
+			roots = append(roots, spanId)
+		} else if _, ok := values[parentId]; !ok {
+			verification.Orphaned++
+			problem(spanId, model.ProblemOrphaned, "parent "+parentId+" not found")
+		}
+	}
+	var firstSpanId string
+	if len(expected.SpanIds) > 0 {
+		firstSpanId = expected.SpanIds[0]
+	}
+	verification.Rooted = len(roots) == 1 && roots[0] == firstSpanId && reachRoot(parents)
+	if !verification.Rooted {
+		sort.Strings(roots)
+		problem("", model.ProblemUnrooted, fmt.Sprintf("roots %v, expected %s", roots, firstSpanId))
+	}
+
+	if probe := th.TtlProbe(); probe != nil {
+		ttl, err := probe.TraceTtl(expected.TraceId)
+		if err != nil {
+			return verification, err
+		}
+		minTtl, maxTtl := th.ttlRange(ttlStrategy, runStart)
+		verification.TtlChecked = true
+		verification.TtlInRange = ttl >= minTtl && ttl <= maxTtl
+		if !verification.TtlInRange {
+			problem("", model.ProblemTtl, fmt.Sprintf("ttl %v not in [%v, %v]", ttl, minTtl, maxTtl))
+		}
+	}
+
+	sort.Slice(verification.Problems, func(i, j int) bool {
+		return verification.Problems[i].SpanId < verification.Problems[j].SpanId
+	})
+	return verification, nil
+}
+
+func reachRoot(parents map[string]string) bool {
+	for spanId := range parents {
+		for steps := 0; ; steps++ {
+			parentId, ok := parents[spanId]
+			if !ok || steps > len(parents) {
+				return false
+			}
+			if parentId == model.DefaultParentSpanId {
+				break
+			}
+			spanId = parentId
+		}
+	}
+	return true
+}
+
+// ttlRange is -1 for keys without expiry, as redis TTL reports it.
+func (th *TraceHandler) ttlRange(strategy string, runStart time.Time) (time.Duration, time.Duration) {
+	if strategy == "" {
+		strategy = th.ttlStrategy
+	}
+	if th.ttl <= 0 || strategy == TtlNone {
+		return -1, -1
+	}
+	minTtl, maxTtl := th.ttl, th.ttl
+	if strategy == TtlJitter {
+		jitter := time.Duration(float64(th.ttl) * float64(th.ttlJitterPercent) / 100)
+		minTtl, maxTtl = minTtl-jitter, maxTtl+jitter
+	}
+	// the ttl counts down from when the trace was written, with a precision of a second
+	minTtl -= time.Since(runStart) + time.Second
+	if minTtl < 0 {
+		minTtl = 0
+	}
+	return minTtl, maxTtl
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"redis-test/config"
+	"redis-test/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collectTraces(parameters model.RunParameters) []ExpectedTrace {
+	var traces []ExpectedTrace
+	ExpectedTraces(parameters, func(trace ExpectedTrace) bool {
+		traces = append(traces, trace)
+		return true
+	})
+	return traces
+}
+
+func TestExpectedTracesAreGeneratedFromTheSeed(t *testing.T) {
+	parameters := model.RunParameters{TraceCount: 3, SpansPerTrace: 2, Seed: 7, KeyPrefix: "run:1:", Codecs: []string{"json", "msgpack"}}
+	first, again := collectTraces(parameters), collectTraces(parameters)
+	parameters.Seed = 8
+	other := collectTraces(parameters)
+
+	if len(first) != 3 || first[1].TraceId != again[1].TraceId || first[1].SpanIds[1] != again[1].SpanIds[1] {
+		t.Fatalf("expected the same seed to generate the same traces, got %+v and %+v", first, again)
+	}
+	if first[0].TraceId == other[0].TraceId {
+		t.Errorf("expected another seed to generate other traces, both start with %s", first[0].TraceId)
+	}
+	if !strings.HasPrefix(first[0].TraceId, "run:1:00-aaaa") || len(first[0].SpanIds[0]) != 16 {
+		t.Errorf("unexpected ids %s %v", first[0].TraceId, first[0].SpanIds)
+	}
+	if first[0].Codec != "json" || first[1].Codec != "msgpack" || first[2].Codec != "json" {
+		t.Errorf("expected the codecs to be used in turn, got %s %s %s", first[0].Codec, first[1].Codec, first[2].Codec)
+	}
+}
+
+const readerTestBackend = "reader-test"
+
+// readerStore reads back the span values set by the test.
+type readerStore struct {
+	TraceDiscardStore
+	values map[string][]byte
+}
+
+func (s *readerStore) ReadTrace(string) (map[string][]byte, error) {
+	return s.values, nil
+}
+
+func TestVerifyTrace(t *testing.T) {
+	store := &readerStore{}
+	RegisterTraceStore(readerTestBackend, func(*config.AppConfigs) (TraceStore, error) {
+		return store, nil
+	})
+	th, err := NewTraceHandler(&config.AppConfigs{Traces: config.TraceConfig{Backend: readerTestBackend}})
+	if err != nil {
+		t.Fatalf("unable to create the trace handler: %v", err)
+	}
+	defer th.Close()
+	expected := ExpectedTrace{TraceId: "trace", SpanIds: []string{"root", "child", "leaf"}}
+	spanCodec, err := th.Codec("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := func(parentSpanId string) []byte {
+		encoded, err := spanCodec.Encode(&model.OTelSpanDetails{ParentSpanId: parentSpanId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	for _, test := range []struct {
+		name   string
+		values map[string][]byte
+		check  func(v TraceVerification) bool
+	}{
+		{"complete", map[string][]byte{"root": value(model.DefaultParentSpanId), "child": value("root"), "leaf": value("child")},
+			func(v TraceVerification) bool { return v.Found && v.Rooted && len(v.Problems) == 0 }},
+		{"missing", nil,
+			func(v TraceVerification) bool { return !v.Found && v.Missing == 3 }},
+		{"orphaned", map[string][]byte{"root": value(model.DefaultParentSpanId), "leaf": value("child")},
+			func(v TraceVerification) bool { return v.Missing == 1 && v.Orphaned == 1 && !v.Rooted }},
+		{"corrupt and unexpected", map[string][]byte{"root": value(model.DefaultParentSpanId), "child": []byte("{"), "leaf": value("child"), "other": value("root")},
+			func(v TraceVerification) bool { return v.Corrupt == 1 && v.Unexpected == 1 && !v.Rooted }},
+	} {
+		store.values = test.values
+		verification, err := th.VerifyTrace("verify", expected, TtlPerSpan, time.Now())
+		if err != nil || !test.check(verification) {
+			t.Errorf("%s: unexpected verification %+v: %v", test.name, verification, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"redis-test/model"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Names of the write modes. The pipeline mode queues the commands of every span in the pipeline, the
+// tx mode wraps the commands of the spans of a trace in a flush in a MULTI/EXEC and the direct mode
+// sends them one at a time. The scripted modes send the spans of a trace in a flush to a server-side
+// script in one call.
+const (
+	WriteModePipeline = "pipeline"
+	WriteModeTx       = "tx"
+	WriteModeDirect   = "direct"
+	WriteModeLua      = "lua"
+	WriteModeFunction = "function"
+)
+
+var ErrUnknownWriteMode = errors.New("unknown write mode")
+var ErrWriteModeUnsupported = errors.New("write mode is not supported")
+
+// WriteModes returns the names of the write modes.
+func WriteModes() []string {
+	return []string{WriteModeDirect, WriteModeFunction, WriteModeLua, WriteModePipeline, WriteModeTx}
+}
+
+func isWriteMode(mode string) bool {
+	for _, writeMode := range WriteModes() {
+		if mode == writeMode {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteModeStore is implemented by the stores that can write spans in other modes than the pipeline.
+type WriteModeStore interface {
+	// CheckWriteMode prepares the store for writing spans in the mode, or returns why it cannot.
+	CheckWriteMode(mode string) error
+}
+
+func scenarioIndexKey(keyPrefix string, scenario model.ScenarioId) string {
+	return keyPrefix + "scenario:" + string(scenario) + ":traces"
+}
+
+func indexScenarios(ctx context.Context, pipeline redis.Pipeliner, span Span, ttl *TtlPolicy) {
+	for scenario := range span.SpanDetails.GroupBy {
+		key := scenarioIndexKey(span.KeyPrefix, scenario)
+		pipeline.SAdd(ctx, key, span.TraceId)
+		expire(ctx, pipeline, span, key, ttl)
+	}
+}
+
+// txSpanWriter queues the same commands as the pipeline mode for a batch of spans of a trace.
+type txSpanWriter struct {
+	layout TraceLayout
+	ttl    func(span Span) *TtlPolicy
+}
+
+func (w *txSpanWriter) Queue(ctx context.Context, pipeline redis.Pipeliner, values []interface{}) {
+	for _, value := range values {
+		span := value.(Span)
+		ttl := w.ttl(span)
+		w.layout.Write(ctx, pipeline, span, ttl)
+		indexScenarios(ctx, pipeline, span, ttl)
+	}
+}
+
+// Load does nothing, the commands call no script.
+func (w *txSpanWriter) Load(context.Context, redis.Cmdable) error {
+	return nil
+}
+
+// spanScript writes a batch of spans of a trace to its hash. The hash gets its ttl when it is created,
+// the indexes of the scenarios of the spans get theirs refreshed so they outlive their newest trace.
+//
+// KEYS[1] is the trace hash and KEYS[2..] the scenario indexes. ARGV[1] is the ttl in seconds, ARGV[2]
+// the trace id and ARGV[3..] the span ids and values.
+const spanScript = `
+local ttl = tonumber(ARGV[1])
+local created = redis.call('EXISTS', KEYS[1]) == 0
+redis.call('HSET', KEYS[1], unpack(ARGV, 3))
+if created and ttl > 0 then
+	redis.call('EXPIRE', KEYS[1], ttl)
+end
+for i = 2, #KEYS do
+	redis.call('SADD', KEYS[i], ARGV[2])
+	if ttl > 0 then
+		redis.call('EXPIRE', KEYS[i], ttl)
+	end
+end
+return (#ARGV - 2) / 2
+`
+
+const spanFunctionName = "zk_write_spans"
+
+// spanFunctionLibrary registers spanScript as a redis function.
+const spanFunctionLibrary = "#!lua name=zk_traces\n" +
+	"local function write_spans(KEYS, ARGV)" + spanScript + "end\n" +
+	"redis.register_function('" + spanFunctionName + "', write_spans)\n"
+
+func spanScriptArgs(values []interface{}, ttl time.Duration) ([]string, []interface{}) {
+	args := make([]interface{}, 2, 2+2*len(values))
+	scenarios := make(map[model.ScenarioId]bool)
+	var keyPrefix string
+	for _, value := range values {
+		span := value.(Span)
+		args[1] = span.TraceId
+		keyPrefix = span.KeyPrefix
+		args = append(args, span.SpanId, span.Value)
+		for scenario := range span.SpanDetails.GroupBy {
+			scenarios[scenario] = true
+		}
+	}
+	args[0] = strconv.FormatInt(int64(ttl/time.Second), 10)
+
+	keys := make([]string, 0, 1+len(scenarios))
+	for scenario := range scenarios {
+		keys = append(keys, scenarioIndexKey(keyPrefix, scenario))
+	}
+	sort.Strings(keys)
+	return append([]string{args[1].(string)}, keys...), args
+}
+
+// luaSpanWriter calls spanScript with EVALSHA.
+type luaSpanWriter struct {
+	script *redis.Script
+	ttl    time.Duration
+}
+
+func newLuaSpanWriter(ttl time.Duration) *luaSpanWriter {
+	return &luaSpanWriter{script: redis.NewScript(spanScript), ttl: ttl}
+}
+
+func (w *luaSpanWriter) Queue(ctx context.Context, pipeline redis.Pipeliner, values []interface{}) {
+	keys, args := spanScriptArgs(values, w.ttl)
+	w.script.EvalSha(ctx, pipeline, keys, args...)
+}
+
+func (w *luaSpanWriter) Load(ctx context.Context, client redis.Cmdable) error {
+	return w.script.Load(ctx, client).Err()
+}
+
+// functionSpanWriter calls spanScript as a redis function with FCALL.
+type functionSpanWriter struct {
+	ttl time.Duration
+}
+
+func (w *functionSpanWriter) Queue(ctx context.Context, pipeline redis.Pipeliner, values []interface{}) {
+	keys, args := spanScriptArgs(values, w.ttl)
+	cmdArgs := make([]interface{}, 0, 3+len(keys)+len(args))
+	cmdArgs = append(cmdArgs, "FCALL", spanFunctionName, len(keys))
+	for _, key := range keys {
+		cmdArgs = append(cmdArgs, key)
+	}
+	pipeline.Do(ctx, append(cmdArgs, args...)...)
+}
+
+func (w *functionSpanWriter) Load(ctx context.Context, client redis.Cmdable) error {
+	return client.FunctionLoadReplace(ctx, spanFunctionLibrary).Err()
+}
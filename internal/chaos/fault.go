@@ -0,0 +1,44 @@
+package chaos
+
+import "time"
+
+// FaultMode selects how the proxy treats the traffic of its connections.
+type FaultMode string
+
+const (
+	// FaultModeNone forwards traffic, subject to the latency and bandwidth settings of the fault.
+	FaultModeNone FaultMode = ""
+	// FaultModeReset resets the open connections and every new connection.
+	FaultModeReset FaultMode = "reset"
+	// FaultModeHalfOpen forwards requests to the server but drops its responses, like a peer that vanished.
+	FaultModeHalfOpen FaultMode = "half_open"
+	// FaultModeBlackhole keeps connections open and drops the traffic in both directions.
+	FaultModeBlackhole FaultMode = "blackhole"
+)
+
+// Fault describes the network conditions the proxy simulates.
+type Fault struct {
+	Mode                 FaultMode `json:"mode,omitempty" yaml:"mode"`
+	LatencyMS            int       `json:"latencyMS,omitempty" yaml:"latencyMS"`
+	JitterMS             int       `json:"jitterMS,omitempty" yaml:"jitterMS"`
+	BandwidthBytesPerSec int       `json:"bandwidthBytesPerSec,omitempty" yaml:"bandwidthBytesPerSec"`
+}
+
+// ScheduledFault applies a fault AfterMS milliseconds after the schedule starts, for DurationMS milliseconds.
+type ScheduledFault struct {
+	AfterMS    int   `json:"afterMS" yaml:"afterMS"`
+	DurationMS int   `json:"durationMS" yaml:"durationMS"`
+	Fault      Fault `json:"fault" yaml:"fault"`
+}
+
+// delay returns how long a chunk of the given size is held back before it is forwarded.
+func (f Fault) delay(size int, jitter func(int) int) time.Duration {
+	delay := time.Duration(f.LatencyMS) * time.Millisecond
+	if f.JitterMS > 0 {
+		delay += time.Duration(jitter(f.JitterMS+1)) * time.Millisecond
+	}
+	if f.BandwidthBytesPerSec > 0 {
+		delay += time.Duration(float64(size) / float64(f.BandwidthBytesPerSec) * float64(time.Second))
+	}
+	return delay
+}
@@ -0,0 +1,333 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+	zkconfig "github.com/zerok-ai/zk-utils-go/storage/redis/config"
+)
+
+const (
+	LogTag = "chaos"
+
+	dialTimeout = 5 * time.Second
+	bufferSize  = 32 * 1024
+)
+
+// ProxyStatus is the current state of the proxy and what it has done so far.
+type ProxyStatus struct {
+	ListenAddr       string           `json:"listenAddr"`
+	UpstreamAddr     string           `json:"upstreamAddr"`
+	Fault            Fault            `json:"fault"`
+	FaultUntil       *time.Time       `json:"faultUntil,omitempty"`
+	Schedule         []ScheduledFault `json:"schedule,omitempty"`
+	ScheduleStarted  *time.Time       `json:"scheduleStarted,omitempty"`
+	OpenConnections  int              `json:"openConnections"`
+	TotalConnections int64            `json:"totalConnections"`
+	Resets           int64            `json:"resets"`
+	BytesForwarded   int64            `json:"bytesForwarded"`
+	BytesDropped     int64            `json:"bytesDropped"`
+}
+
+// Proxy is a TCP proxy that injects faults into the traffic between a client and its upstream.
+type Proxy struct {
+	listenAddr   string
+	upstreamAddr string
+	listener     net.Listener
+
+	mutex           sync.RWMutex
+	fault           Fault
+	faultUntil      time.Time
+	faultTimer      *time.Timer
+	schedule        []ScheduledFault
+	scheduleStarted time.Time
+	scheduleCancel  context.CancelFunc
+	conns           map[*proxyConn]struct{}
+	closed          bool
+
+	totalConnections atomic.Int64
+	resets           atomic.Int64
+	bytesForwarded   atomic.Int64
+	bytesDropped     atomic.Int64
+}
+
+type proxyConn struct {
+	client   net.Conn
+	upstream net.Conn
+	once     sync.Once
+}
+
+// StartRedisProxy starts a proxy in front of the configured redis and points the redis config at it.
+func StartRedisProxy(redisConfig *zkconfig.RedisConfig, listenAddr string) (*Proxy, error) {
+	proxy := NewProxy(listenAddr, net.JoinHostPort(redisConfig.Host, redisConfig.Port))
+	if err := proxy.Start(); err != nil {
+		zkLogger.Error(LogTag, "Error while starting chaos proxy ", err)
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(proxy.Addr())
+	if err != nil {
+		_ = proxy.Close()
+		return nil, err
+	}
+	redisConfig.Host = host
+	redisConfig.Port = port
+	return proxy, nil
+}
+
+func NewProxy(listenAddr string, upstreamAddr string) *Proxy {
+	return &Proxy{
+		listenAddr:   listenAddr,
+		upstreamAddr: upstreamAddr,
+		conns:        make(map[*proxyConn]struct{}),
+	}
+}
+
+// Start listens on the configured address and accepts connections in the background.
+func (p *Proxy) Start() error {
+	listener, err := net.Listen("tcp", p.listenAddr)
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	zkLogger.InfoF(LogTag, "chaos proxy listening on %s, forwarding to %s", listener.Addr(), p.upstreamAddr)
+
+	go p.acceptConnections()
+	return nil
+}
+
+// Addr returns the address the proxy listens on.
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *Proxy) Close() error {
+	p.mutex.Lock()
+	p.closed = true
+	if p.scheduleCancel != nil {
+		p.scheduleCancel()
+	}
+	if p.faultTimer != nil {
+		p.faultTimer.Stop()
+	}
+	conns := p.conns
+	p.conns = make(map[*proxyConn]struct{})
+	p.mutex.Unlock()
+
+	for conn := range conns {
+		conn.close(false)
+	}
+	return p.listener.Close()
+}
+
+// SetFault applies a fault now. A positive duration clears it again afterwards.
+func (p *Proxy) SetFault(fault Fault, duration time.Duration) {
+	p.mutex.Lock()
+	if p.faultTimer != nil {
+		p.faultTimer.Stop()
+		p.faultTimer = nil
+	}
+	p.fault = fault
+	p.faultUntil = time.Time{}
+	if duration > 0 {
+		p.faultUntil = time.Now().Add(duration)
+		p.faultTimer = time.AfterFunc(duration, p.ClearFault)
+	}
+	var conns []*proxyConn
+	if fault.Mode == FaultModeReset {
+		for conn := range p.conns {
+			conns = append(conns, conn)
+		}
+	}
+	p.mutex.Unlock()
+
+	zkLogger.InfoF(LogTag, "fault set to %+v for %s", fault, duration)
+	for _, conn := range conns {
+		p.reset(conn)
+	}
+}
+
+func (p *Proxy) ClearFault() {
+	p.mutex.Lock()
+	if p.faultTimer != nil {
+		p.faultTimer.Stop()
+		p.faultTimer = nil
+	}
+	p.fault = Fault{}
+	p.faultUntil = time.Time{}
+	p.mutex.Unlock()
+	zkLogger.Info(LogTag, "fault cleared")
+}
+
+// RunSchedule replaces the running schedule and applies its faults relative to now.
+func (p *Proxy) RunSchedule(schedule []ScheduledFault) {
+	schedule = append([]ScheduledFault(nil), schedule...)
+	sort.SliceStable(schedule, func(i, j int) bool { return schedule[i].AfterMS < schedule[j].AfterMS })
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p.mutex.Lock()
+	if p.scheduleCancel != nil {
+		p.scheduleCancel()
+	}
+	p.schedule = schedule
+	p.scheduleStarted = time.Now()
+	p.scheduleCancel = cancel
+	started := p.scheduleStarted
+	p.mutex.Unlock()
+
+	go func() {
+		for _, scheduled := range schedule {
+			wait := time.Until(started.Add(time.Duration(scheduled.AfterMS) * time.Millisecond))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			p.SetFault(scheduled.Fault, time.Duration(scheduled.DurationMS)*time.Millisecond)
+		}
+	}()
+}
+
+func (p *Proxy) Status() ProxyStatus {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	status := ProxyStatus{
+		ListenAddr:       p.Addr(),
+		UpstreamAddr:     p.upstreamAddr,
+		Fault:            p.fault,
+		Schedule:         p.schedule,
+		OpenConnections:  len(p.conns),
+		TotalConnections: p.totalConnections.Load(),
+		Resets:           p.resets.Load(),
+		BytesForwarded:   p.bytesForwarded.Load(),
+		BytesDropped:     p.bytesDropped.Load(),
+	}
+	if !p.faultUntil.IsZero() {
+		faultUntil := p.faultUntil
+		status.FaultUntil = &faultUntil
+	}
+	if !p.scheduleStarted.IsZero() {
+		scheduleStarted := p.scheduleStarted
+		status.ScheduleStarted = &scheduleStarted
+	}
+	return status
+}
+
+func (p *Proxy) currentFault() Fault {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.fault
+}
+
+func (p *Proxy) acceptConnections() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			zkLogger.Error(LogTag, "Error while accepting connection ", err)
+			continue
+		}
+		go p.handleConnection(client)
+	}
+}
+
+func (p *Proxy) handleConnection(client net.Conn) {
+	p.totalConnections.Add(1)
+	conn := &proxyConn{client: client}
+
+	if p.currentFault().Mode == FaultModeReset {
+		p.reset(conn)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", p.upstreamAddr, dialTimeout)
+	if err != nil {
+		zkLogger.Error(LogTag, "Error while connecting to upstream ", err)
+		conn.close(false)
+		return
+	}
+	conn.upstream = upstream
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		conn.close(false)
+		return
+	}
+	p.conns[conn] = struct{}{}
+	p.mutex.Unlock()
+
+	done := make(chan struct{}, 2)
+	go p.forward(conn, client, upstream, false, done)
+	go p.forward(conn, upstream, client, true, done)
+	<-done
+
+	p.mutex.Lock()
+	delete(p.conns, conn)
+	p.mutex.Unlock()
+	conn.close(false)
+}
+
+// forward copies one direction of a connection, applying the fault active when each chunk is read.
+func (p *Proxy) forward(conn *proxyConn, src net.Conn, dst net.Conn, fromUpstream bool, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+
+	buffer := make([]byte, bufferSize)
+	for {
+		n, err := src.Read(buffer)
+		if n > 0 {
+			fault := p.currentFault()
+			switch {
+			case fault.Mode == FaultModeReset:
+				p.reset(conn)
+				return
+			case fault.Mode == FaultModeBlackhole, fault.Mode == FaultModeHalfOpen && fromUpstream:
+				p.bytesDropped.Add(int64(n))
+				continue
+			}
+
+			if delay := fault.delay(n, rand.Intn); delay > 0 {
+				time.Sleep(delay)
+			}
+			if _, writeErr := dst.Write(buffer[:n]); writeErr != nil {
+				return
+			}
+			p.bytesForwarded.Add(int64(n))
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				zkLogger.Debug(LogTag, "connection closed ", err)
+			}
+			return
+		}
+	}
+}
+
+func (p *Proxy) reset(conn *proxyConn) {
+	p.resets.Add(1)
+	conn.close(true)
+}
+
+// close closes both sides of the connection. With reset the client receives a RST instead of a FIN.
+func (c *proxyConn) close(reset bool) {
+	c.once.Do(func() {
+		if tcpConn, ok := c.client.(*net.TCPConn); ok && reset {
+			_ = tcpConn.SetLinger(0)
+		}
+		_ = c.client.Close()
+		if c.upstream != nil {
+			_ = c.upstream.Close()
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package chaos
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start echo server: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startProxy(t *testing.T) *Proxy {
+	t.Helper()
+	proxy := NewProxy("127.0.0.1:0", startEchoServer(t))
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("unable to start proxy: %v", err)
+	}
+	t.Cleanup(func() { _ = proxy.Close() })
+	return proxy
+}
+
+// roundTrip sends a line through the proxy and returns the echoed line and the time it took.
+func roundTrip(t *testing.T, conn net.Conn, timeout time.Duration) (string, time.Duration, error) {
+	t.Helper()
+	start := time.Now()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		return "", 0, err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	return line, time.Since(start), err
+}
+
+func dial(t *testing.T, proxy *Proxy) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatalf("unable to connect to proxy: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestProxyForwardsTraffic(t *testing.T) {
+	proxy := startProxy(t)
+	conn := dial(t, proxy)
+
+	line, _, err := roundTrip(t, conn, time.Second)
+	if err != nil || line != "ping\n" {
+		t.Fatalf("expected echo, got %q, %v", line, err)
+	}
+	if status := proxy.Status(); status.BytesForwarded != 10 || status.OpenConnections != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestProxyInjectsLatency(t *testing.T) {
+	proxy := startProxy(t)
+	conn := dial(t, proxy)
+
+	proxy.SetFault(Fault{LatencyMS: 50}, 0)
+	_, elapsed, err := roundTrip(t, conn, time.Second)
+	if err != nil {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("expected at least 100ms for a round trip with 50ms latency each way, took %s", elapsed)
+	}
+}
+
+func TestProxyBlackholeAndHalfOpenDropTraffic(t *testing.T) {
+	for _, mode := range []FaultMode{FaultModeBlackhole, FaultModeHalfOpen} {
+		proxy := startProxy(t)
+		conn := dial(t, proxy)
+
+		proxy.SetFault(Fault{Mode: mode}, 0)
+		if _, _, err := roundTrip(t, conn, 100*time.Millisecond); err == nil {
+			t.Errorf("%s: expected the round trip to time out", mode)
+		}
+		if proxy.Status().BytesDropped == 0 {
+			t.Errorf("%s: expected dropped bytes", mode)
+		}
+	}
+}
+
+func TestProxyResetsConnections(t *testing.T) {
+	proxy := startProxy(t)
+	conn := dial(t, proxy)
+	if _, _, err := roundTrip(t, conn, time.Second); err != nil {
+		t.Fatalf("round trip failed: %v", err)
+	}
+
+	proxy.SetFault(Fault{Mode: FaultModeReset}, 0)
+	if _, _, err := roundTrip(t, conn, time.Second); err == nil {
+		t.Errorf("expected the open connection to be reset")
+	}
+	if _, _, err := roundTrip(t, dial(t, proxy), time.Second); err == nil {
+		t.Errorf("expected a new connection to be reset")
+	}
+	if proxy.Status().Resets < 2 {
+		t.Errorf("expected at least 2 resets, got %d", proxy.Status().Resets)
+	}
+}
+
+func TestProxyRunsSchedule(t *testing.T) {
+	proxy := startProxy(t)
+
+	proxy.RunSchedule([]ScheduledFault{{AfterMS: 10, DurationMS: 50, Fault: Fault{Mode: FaultModeBlackhole}}})
+	time.Sleep(30 * time.Millisecond)
+	if mode := proxy.Status().Fault.Mode; mode != FaultModeBlackhole {
+		t.Errorf("expected the scheduled fault to be active, got %q", mode)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if mode := proxy.Status().Fault.Mode; mode != FaultModeNone {
+		t.Errorf("expected the scheduled fault to be cleared, got %q", mode)
+	}
+}
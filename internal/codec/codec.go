@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"redis-test/model"
+	"sort"
+	"strings"
+)
+
+// Names of the encodings and compressions a codec is made of.
+const (
+	JSON     = "json"
+	MsgPack  = "msgpack"
+	Protobuf = "protobuf"
+
+	Zstd   = "zstd"
+	Snappy = "snappy"
+)
+
+// compressionSeparator separates the encoding and the compression in a codec name, e.g. "json+zstd".
+const compressionSeparator = "+"
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec turns span details into the values written to the store, and back.
+type Codec interface {
+	Name() string
+	Encode(span *model.OTelSpanDetails) ([]byte, error)
+	Decode(data []byte) (model.OTelSpanDetails, error)
+}
+
+var encodings = map[string]func() Codec{
+	JSON:     func() Codec { return jsonCodec{} },
+	MsgPack:  func() Codec { return msgpackCodec{} },
+	Protobuf: func() Codec { return protobufCodec{} },
+}
+
+var compressions = map[string]func(dictionary []byte) (compressor, error){
+	Zstd:   newZstdCompressor,
+	Snappy: newSnappyCompressor,
+}
+
+// New returns the codec with the given name: an encoding, optionally followed by "+" and a compression.
+// The dictionary is shared by all the values compressed with zstd, it may be empty.
+func New(name string, dictionary []byte) (Codec, error) {
+	encodingName, compressionName, compressed := strings.Cut(name, compressionSeparator)
+	newEncoding, ok := encodings[encodingName]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, supported codecs are %v", ErrUnknownCodec, name, Names())
+	}
+	if !compressed {
+		return newEncoding(), nil
+	}
+
+	newCompression, ok := compressions[compressionName]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, supported codecs are %v", ErrUnknownCodec, name, Names())
+	}
+	compression, err := newCompression(dictionary)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create codec %s: %w", name, err)
+	}
+	return &compressedCodec{name: name, encoding: newEncoding(), compression: compression}, nil
+}
+
+// Names lists every supported codec.
+func Names() []string {
+	var names []string
+	for encoding := range encodings {
+		names = append(names, encoding)
+		for compression := range compressions {
+			names = append(names, encoding+compressionSeparator+compression)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
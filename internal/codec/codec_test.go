@@ -0,0 +1,89 @@
+package codec
+
+import (
+	"errors"
+	"redis-test/model"
+	"reflect"
+	"testing"
+)
+
+func testSpan() model.OTelSpanDetails {
+	route, status := "/orders/{id}", 200.0
+	span := model.OTelSpanDetails{
+		SpanKind:      model.SpanKindServer,
+		StartNs:       1700000000000000000,
+		LatencyNs:     1500000,
+		SchemaVersion: "1.0",
+		Errors:        []model.SpanErrorInfo{{Message: "boom", ErrorType: model.ErrorTypeException, ExceptionType: "IOError", Hash: "abc"}},
+		SpanAttributes: model.GenericMapPtrFromMap(map[string]interface{}{
+			"http.method": "GET",
+			"retries":     2.0,
+			"cached":      false,
+			"nested":      map[string]interface{}{"key": "value"},
+		}),
+		ServiceName:    "orders",
+		SpanName:       "GET /orders/{id}",
+		Protocol:       model.ProtocolTypeHTTP,
+		Route:          &route,
+		Status:         &status,
+		WorkloadIdList: []string{"w1", "w2"},
+		GroupBy:        model.GroupByMap{"s1": {{WorkloadId: "w1", Title: "orders", Hash: "h1"}}},
+	}
+	span.SetParentSpanId("")
+	return span
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	span := testSpan()
+	for _, name := range Names() {
+		codec, err := New(name, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if codec.Name() != name {
+			t.Errorf("expected codec %s, got %s", name, codec.Name())
+		}
+		data, err := codec.Encode(&span)
+		if err != nil {
+			t.Fatalf("%s: unable to encode: %v", name, err)
+		}
+		decoded, err := codec.Decode(data)
+		if err != nil {
+			t.Fatalf("%s: unable to decode: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, span) {
+			t.Errorf("%s: decoded span differs\nexpected %+v\ngot      %+v", name, span, decoded)
+		}
+	}
+}
+
+func TestZstdDictionary(t *testing.T) {
+	span := testSpan()
+	plain, _ := New("json+zstd", nil)
+	dictionary, _ := New(JSON, nil)
+	sample, _ := dictionary.Encode(&span)
+	withDictionary, err := New("json+zstd", sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainData, _ := plain.Encode(&span)
+	data, err := withDictionary.Encode(&span)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) >= len(plainData) {
+		t.Errorf("expected the dictionary to shrink the value, got %d bytes with and %d without", len(data), len(plainData))
+	}
+	if decoded, err := withDictionary.Decode(data); err != nil || !reflect.DeepEqual(decoded, span) {
+		t.Errorf("unable to decode with the dictionary: %v", err)
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	for _, name := range []string{"xml", "json+lz4"} {
+		if _, err := New(name, nil); !errors.Is(err, ErrUnknownCodec) {
+			t.Errorf("%s: expected ErrUnknownCodec, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"redis-test/model"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDictionaryId identifies the shared dictionary in the zstd frames.
+const zstdDictionaryId = 1
+
+type compressor interface {
+	compress(data []byte) []byte
+	decompress(data []byte) ([]byte, error)
+}
+
+// compressedCodec compresses the values of another codec.
+type compressedCodec struct {
+	name        string
+	encoding    Codec
+	compression compressor
+}
+
+func (c *compressedCodec) Name() string {
+	return c.name
+}
+
+func (c *compressedCodec) Encode(span *model.OTelSpanDetails) ([]byte, error) {
+	data, err := c.encoding.Encode(span)
+	if err != nil {
+		return nil, err
+	}
+	return c.compression.compress(data), nil
+}
+
+func (c *compressedCodec) Decode(data []byte) (model.OTelSpanDetails, error) {
+	decompressed, err := c.compression.decompress(data)
+	if err != nil {
+		return model.OTelSpanDetails{}, err
+	}
+	return c.encoding.Decode(decompressed)
+}
+
+// zstdCompressor compresses every value as its own frame. The encoder and decoder are safe for
+// concurrent use with EncodeAll and DecodeAll.
+type zstdCompressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// newZstdCompressor creates a zstd compressor, the dictionary is used as raw content shared by all
+// the values. The fastest level is used, it is cheap enough for the write path and, unlike the
+// default level, makes use of raw dictionaries.
+func newZstdCompressor(dictionary []byte) (compressor, error) {
+	encoderOptions := []zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest)}
+	decoderOptions := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if len(dictionary) > 0 {
+		encoderOptions = append(encoderOptions, zstd.WithEncoderDictRaw(zstdDictionaryId, dictionary))
+		decoderOptions = append(decoderOptions, zstd.WithDecoderDictRaw(zstdDictionaryId, dictionary))
+	}
+	encoder, err := zstd.NewWriter(nil, encoderOptions...)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, decoderOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCompressor{encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *zstdCompressor) compress(data []byte) []byte {
+	return c.encoder.EncodeAll(data, nil)
+}
+
+func (c *zstdCompressor) decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
+
+type snappyCompressor struct{}
+
+// newSnappyCompressor ignores the dictionary, snappy has no support for one.
+func newSnappyCompressor([]byte) (compressor, error) {
+	return snappyCompressor{}, nil
+}
+
+func (snappyCompressor) compress(data []byte) []byte {
+	return snappy.Encode(nil, data)
+}
+
+func (snappyCompressor) decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
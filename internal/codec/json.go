@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"redis-test/model"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// jsonCodec stores the span details as json, the format the spans were always written in.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSON
+}
+
+func (jsonCodec) Encode(span *model.OTelSpanDetails) ([]byte, error) {
+	return json.Marshal(span)
+}
+
+func (jsonCodec) Decode(data []byte) (model.OTelSpanDetails, error) {
+	var span model.OTelSpanDetails
+	err := json.Unmarshal(data, &span)
+	return span, err
+}
+
+// msgpackCodec stores the span details as msgpack maps keyed by the json field names.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return MsgPack
+}
+
+func (msgpackCodec) Encode(span *model.OTelSpanDetails) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(encoder)
+	encoder.Reset(&buffer)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+	if err := encoder.Encode(span); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte) (model.OTelSpanDetails, error) {
+	var span model.OTelSpanDetails
+	decoder := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(decoder)
+	decoder.Reset(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	err := decoder.Decode(&span)
+	return span, err
+}
@@ -0,0 +1,216 @@
+package codec
+
+import (
+	"encoding/json"
+	"redis-test/model"
+
+	"google.golang.org/protobuf/proto"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative span.proto
+
+// protobufCodec stores the span details as the SpanDetails message of span.proto.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return Protobuf
+}
+
+func (protobufCodec) Encode(span *model.OTelSpanDetails) ([]byte, error) {
+	message, err := toSpanDetails(span)
+	if err != nil {
+		return nil, err
+	}
+	// deterministic marshaling sorts the map entries, so that equal spans are encoded into equal values
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
+
+func (protobufCodec) Decode(data []byte) (model.OTelSpanDetails, error) {
+	var message SpanDetails
+	if err := proto.Unmarshal(data, &message); err != nil {
+		return model.OTelSpanDetails{}, err
+	}
+	return fromSpanDetails(&message)
+}
+
+func toSpanDetails(span *model.OTelSpanDetails) (*SpanDetails, error) {
+	message := &SpanDetails{
+		ParentSpanId:   span.ParentSpanId,
+		SpanKind:       string(span.SpanKind),
+		StartNs:        span.StartNs,
+		LatencyNs:      span.LatencyNs,
+		SchemaVersion:  span.SchemaVersion,
+		ServiceName:    span.ServiceName,
+		SpanName:       span.SpanName,
+		Protocol:       string(span.Protocol),
+		SourceIp:       span.SourceIp,
+		Source:         span.Source,
+		DestinationIp:  span.DestIp,
+		Destination:    span.Destination,
+		Method:         span.Method,
+		Route:          span.Route,
+		Scheme:         span.Scheme,
+		Path:           span.Path,
+		Query:          span.Query,
+		Status:         span.Status,
+		Username:       span.Username,
+		WorkloadIdList: span.WorkloadIdList,
+	}
+	for _, info := range span.Errors {
+		message.Errors = append(message.Errors, &SpanErrorInfo{
+			Message:       info.Message,
+			ErrorType:     string(info.ErrorType),
+			ExceptionType: info.ExceptionType,
+			Hash:          info.Hash,
+		})
+	}
+
+	var err error
+	if message.Attributes, err = toAttributes(span.SpanAttributes); err != nil {
+		return nil, err
+	}
+	if message.ResourceAttributes, err = toAttributes(span.ResourceAttributes); err != nil {
+		return nil, err
+	}
+	if message.ScopeAttributes, err = toAttributes(span.ScopeAttributes); err != nil {
+		return nil, err
+	}
+
+	if len(span.GroupBy) > 0 {
+		message.GroupBy = make(map[string]*GroupByValues, len(span.GroupBy))
+	}
+	for scenario, values := range span.GroupBy {
+		groupBy := &GroupByValues{}
+		for _, item := range values {
+			if item != nil {
+				groupBy.Items = append(groupBy.Items, &GroupByValueItem{WorkloadId: item.WorkloadId, Title: item.Title, Hash: item.Hash})
+			}
+		}
+		message.GroupBy[string(scenario)] = groupBy
+	}
+	return message, nil
+}
+
+func fromSpanDetails(message *SpanDetails) (model.OTelSpanDetails, error) {
+	span := model.OTelSpanDetails{
+		ParentSpanId:   message.ParentSpanId,
+		SpanKind:       model.SpanKind(message.SpanKind),
+		StartNs:        message.StartNs,
+		LatencyNs:      message.LatencyNs,
+		SchemaVersion:  message.SchemaVersion,
+		ServiceName:    message.ServiceName,
+		SpanName:       message.SpanName,
+		Protocol:       model.ProtocolType(message.Protocol),
+		SourceIp:       message.SourceIp,
+		Source:         message.Source,
+		DestIp:         message.DestinationIp,
+		Destination:    message.Destination,
+		Method:         message.Method,
+		Route:          message.Route,
+		Scheme:         message.Scheme,
+		Path:           message.Path,
+		Query:          message.Query,
+		Status:         message.Status,
+		Username:       message.Username,
+		WorkloadIdList: message.WorkloadIdList,
+	}
+	for _, info := range message.Errors {
+		span.Errors = append(span.Errors, model.SpanErrorInfo{
+			Message:       info.Message,
+			ErrorType:     model.ErrorType(info.ErrorType),
+			ExceptionType: info.ExceptionType,
+			Hash:          info.Hash,
+		})
+	}
+
+	var err error
+	if span.SpanAttributes, err = fromAttributes(message.Attributes); err != nil {
+		return span, err
+	}
+	if span.ResourceAttributes, err = fromAttributes(message.ResourceAttributes); err != nil {
+		return span, err
+	}
+	if span.ScopeAttributes, err = fromAttributes(message.ScopeAttributes); err != nil {
+		return span, err
+	}
+
+	if len(message.GroupBy) > 0 {
+		span.GroupBy = make(model.GroupByMap, len(message.GroupBy))
+	}
+	for scenario, groupBy := range message.GroupBy {
+		var values model.GroupByValues
+		for _, item := range groupBy.GetItems() {
+			values = append(values, &model.GroupByValueItem{WorkloadId: item.WorkloadId, Title: item.Title, Hash: item.Hash})
+		}
+		span.GroupBy[model.ScenarioId(scenario)] = values
+	}
+	return span, nil
+}
+
+func toAttributes(attributes *model.GenericMap) (map[string]*AttributeValue, error) {
+	if attributes == nil || len(*attributes) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]*AttributeValue, len(*attributes))
+	for key, value := range *attributes {
+		attribute, err := toAttributeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = attribute
+	}
+	return values, nil
+}
+
+// toAttributeValue keeps the scalar values in their own field, and nested values as json.
+func toAttributeValue(value interface{}) (*AttributeValue, error) {
+	switch typed := value.(type) {
+	case string:
+		return &AttributeValue{Value: &AttributeValue_StringValue{StringValue: typed}}, nil
+	case float64:
+		return &AttributeValue{Value: &AttributeValue_DoubleValue{DoubleValue: typed}}, nil
+	case float32:
+		return &AttributeValue{Value: &AttributeValue_DoubleValue{DoubleValue: float64(typed)}}, nil
+	case bool:
+		return &AttributeValue{Value: &AttributeValue_BoolValue{BoolValue: typed}}, nil
+	case int:
+		return &AttributeValue{Value: &AttributeValue_IntValue{IntValue: int64(typed)}}, nil
+	case int32:
+		return &AttributeValue{Value: &AttributeValue_IntValue{IntValue: int64(typed)}}, nil
+	case int64:
+		return &AttributeValue{Value: &AttributeValue_IntValue{IntValue: typed}}, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &AttributeValue{Value: &AttributeValue_JsonValue{JsonValue: data}}, nil
+}
+
+func fromAttributes(values map[string]*AttributeValue) (*model.GenericMap, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	attributes := make(model.GenericMap, len(values))
+	for key, value := range values {
+		switch typed := value.GetValue().(type) {
+		case *AttributeValue_StringValue:
+			attributes[key] = typed.StringValue
+		case *AttributeValue_DoubleValue:
+			attributes[key] = typed.DoubleValue
+		case *AttributeValue_BoolValue:
+			attributes[key] = typed.BoolValue
+		case *AttributeValue_IntValue:
+			attributes[key] = typed.IntValue
+		case *AttributeValue_JsonValue:
+			var decoded interface{}
+			if err := json.Unmarshal(typed.JsonValue, &decoded); err != nil {
+				return nil, err
+			}
+			attributes[key] = decoded
+		default:
+			attributes[key] = nil
+		}
+	}
+	return &attributes, nil
+}
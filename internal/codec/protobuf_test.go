@@ -0,0 +1,29 @@
+package codec
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// TestProtobufWritesSpanDetails decodes the values of the codec with the message generated from span.proto.
+func TestProtobufWritesSpanDetails(t *testing.T) {
+	span := testSpan()
+	data, err := protobufCodec{}.Encode(&span)
+	if err != nil {
+		t.Fatalf("unable to encode: %v", err)
+	}
+	var message SpanDetails
+	if err = proto.Unmarshal(data, &message); err != nil {
+		t.Fatalf("span.proto cannot decode the value: %v", err)
+	}
+	if message.StartNs != span.StartNs || message.GetRoute() != *span.Route || message.Method != nil || message.GetStatus() != *span.Status {
+		t.Errorf("unexpected span details %v", &message)
+	}
+	if got := message.Attributes["http.method"].GetStringValue(); got != "GET" {
+		t.Errorf("expected the http.method attribute to be the string GET, got %v", message.Attributes["http.method"])
+	}
+	if got := string(message.Attributes["nested"].GetJsonValue()); got != `{"key":"value"}` {
+		t.Errorf("expected the nested attribute as json, got %s", got)
+	}
+}
@@ -11,11 +11,17 @@ import (
 const LogTag = "common"
 
 func MakeHTTPCall(url string) (string, error) {
+	_, body, err := MakeHTTPCallWithStatus(url)
+	return body, err
+}
+
+// MakeHTTPCallWithStatus makes a GET request and returns the status code with the body.
+func MakeHTTPCallWithStatus(url string) (int, string, error) {
 	// Make the GET request
 	response, err := http.Get(url)
 	if err != nil {
 		fmt.Printf("Error making GET request: %v\n", err)
-		return "", err
+		return 0, "", err
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -28,7 +34,7 @@ func MakeHTTPCall(url string) (string, error) {
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		fmt.Printf("Error reading response body: %v\n", err)
-		return "", err
+		return response.StatusCode, "", err
 	}
-	return string(body), nil
+	return response.StatusCode, string(body), nil
 }
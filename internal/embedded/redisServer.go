@@ -0,0 +1,27 @@
+package embedded
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+	zkconfig "github.com/zerok-ai/zk-utils-go/storage/redis/config"
+)
+
+const LogTag = "embedded"
+
+// StartRedisServer starts an in-process redis protocol server and points the redis config at it, so the
+// application can run without a redis instance. The caller closes the server.
+func StartRedisServer(redisConfig *zkconfig.RedisConfig) (*miniredis.Miniredis, error) {
+	server := miniredis.NewMiniRedis()
+	if redisConfig.Password != "" {
+		server.RequireAuth(redisConfig.Password)
+	}
+	if err := server.Start(); err != nil {
+		zkLogger.Error(LogTag, "Error while starting embedded redis server ", err)
+		return nil, err
+	}
+
+	redisConfig.Host = server.Host()
+	redisConfig.Port = server.Port()
+	zkLogger.Info(LogTag, "embedded redis server listening on", server.Addr())
+	return server, nil
+}
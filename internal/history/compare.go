@@ -0,0 +1,72 @@
+package history
+
+import (
+	"fmt"
+	"redis-test/internal/report"
+	"sort"
+	"time"
+)
+
+// MetricDelta compares a metric of two runs.
+type MetricDelta struct {
+	Metric string  `json:"metric"`
+	Base   float64 `json:"base"`
+	Other  float64 `json:"other"`
+	Delta  float64 `json:"delta"`
+	// DeltaPercent is relative to the base run. It is omitted if the base value is 0.
+	DeltaPercent *float64 `json:"deltaPercent,omitempty"`
+}
+
+// Comparison puts two runs side by side.
+type Comparison struct {
+	Base    report.Summary `json:"base"`
+	Other   report.Summary `json:"other"`
+	Metrics []MetricDelta  `json:"metrics"`
+}
+
+// Compare computes the delta of every metric the two runs have in common.
+func Compare(base, other report.Report) Comparison {
+	baseMetrics, otherMetrics := base.Metrics(), other.Metrics()
+	comparison := Comparison{Base: base.Summary(), Other: other.Summary(), Metrics: []MetricDelta{}}
+	for metric, baseValue := range baseMetrics {
+		otherValue, ok := otherMetrics[metric]
+		if !ok {
+			continue
+		}
+		delta := MetricDelta{Metric: metric, Base: baseValue, Other: otherValue, Delta: otherValue - baseValue}
+		if baseValue != 0 {
+			percent := delta.Delta / baseValue * 100
+			delta.DeltaPercent = &percent
+		}
+		comparison.Metrics = append(comparison.Metrics, delta)
+	}
+	sort.Slice(comparison.Metrics, func(i, j int) bool { return comparison.Metrics[i].Metric < comparison.Metrics[j].Metric })
+	return comparison
+}
+
+// TrendPoint is the value of a metric in one run.
+type TrendPoint struct {
+	RunId     string    `json:"runId"`
+	StartTime time.Time `json:"startTime"`
+	Value     float64   `json:"value"`
+}
+
+// Trend returns a metric across the last runs of a profile, oldest first. Only completed runs are used.
+func (s *Store) Trend(profile string, metric string, limit int) ([]TrendPoint, error) {
+	if profile == "" {
+		return nil, fmt.Errorf("a profile is required for a trend")
+	}
+	reports, err := s.Find(Filter{Profile: profile, Status: report.StatusCompleted, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	points := []TrendPoint{}
+	for i := len(reports) - 1; i >= 0; i-- {
+		value, ok := reports[i].Metrics()[metric]
+		if !ok {
+			continue
+		}
+		points = append(points, TrendPoint{RunId: reports[i].RunId, StartTime: reports[i].StartTime, Value: value})
+	}
+	return points, nil
+}
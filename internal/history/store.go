@@ -0,0 +1,152 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"redis-test/internal/report"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	reportsBucket = []byte("reports")
+	// startedBucket indexes the run ids by start time, so runs can be listed in order.
+	startedBucket = []byte("started")
+)
+
+// startKeyLayout has a fixed width, so that the keys sort by time.
+const startKeyLayout = "20060102T150405.000000000Z"
+
+var ErrNotFound = errors.New("run not found in history")
+
+// Store keeps the final reports of runs in a bbolt file.
+type Store struct {
+	db *bolt.DB
+}
+
+// Filter selects runs from the history. Zero values match all runs.
+type Filter struct {
+	Status  report.Status
+	Profile string
+	Passed  *bool
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+func (f Filter) matches(r report.Report) bool {
+	if f.Status != "" && r.Status != f.Status {
+		return false
+	}
+	if f.Profile != "" && r.Profile != f.Profile {
+		return false
+	}
+	if f.Passed != nil && (r.Verdict == nil || r.Verdict.Passed != *f.Passed) {
+		return false
+	}
+	if !f.Since.IsZero() && r.StartTime.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.StartTime.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Open opens or creates the history file at path.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{reportsBucket, startedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Save stores the report of a finished run, replacing an earlier report of the same run.
+func (s *Store) Save(r report.Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(reportsBucket).Put([]byte(r.RunId), data); err != nil {
+			return err
+		}
+		return tx.Bucket(startedBucket).Put(startKey(r), []byte(r.RunId))
+	})
+}
+
+func startKey(r report.Report) []byte {
+	return []byte(r.StartTime.UTC().Format(startKeyLayout) + "/" + r.RunId)
+}
+
+func (s *Store) Get(runId string) (report.Report, error) {
+	var r report.Report
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(reportsBucket).Get([]byte(runId))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &r)
+	})
+	return r, err
+}
+
+// Find returns the reports matching the filter, newest first.
+func (s *Store) Find(filter Filter) ([]report.Report, error) {
+	reports := []report.Report{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(reportsBucket)
+		cursor := tx.Bucket(startedBucket).Cursor()
+		for key, runId := cursor.Last(); key != nil; key, runId = cursor.Prev() {
+			var r report.Report
+			if err := json.Unmarshal(stored.Get(runId), &r); err != nil {
+				return err
+			}
+			if !filter.matches(r) {
+				continue
+			}
+			reports = append(reports, r)
+			if filter.Limit > 0 && len(reports) >= filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return reports, err
+}
+
+// List returns the summaries of the runs matching the filter, newest first.
+func (s *Store) List(filter Filter) ([]report.Summary, error) {
+	reports, err := s.Find(filter)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]report.Summary, 0, len(reports))
+	for _, r := range reports {
+		summaries = append(summaries, r.Summary())
+	}
+	return summaries, nil
+}
@@ -0,0 +1,91 @@
+package history
+
+import (
+	"path/filepath"
+	"redis-test/internal/metrics"
+	"redis-test/internal/report"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "history", "runs.db"))
+	if err != nil {
+		t.Fatalf("unable to open history: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func saveRun(t *testing.T, store *Store, runId string, profile string, start time.Time, spansPerSecond float64) report.Report {
+	t.Helper()
+	r := report.Report{
+		RunId:          runId,
+		Status:         report.StatusCompleted,
+		Profile:        profile,
+		StartTime:      start,
+		SpansPerSecond: spansPerSecond,
+		Latency:        map[string]metrics.HistogramSnapshot{metrics.HistogramSpanAck: {P99: 1000}},
+	}
+	if err := store.Save(r); err != nil {
+		t.Fatalf("unable to save %s: %v", runId, err)
+	}
+	return r
+}
+
+func TestListNewestFirstWithFilters(t *testing.T) {
+	store := openStore(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	saveRun(t, store, "a", "small", start, 100)
+	saveRun(t, store, "b", "large", start.Add(time.Minute), 200)
+	saveRun(t, store, "c", "small", start.Add(2*time.Minute), 300)
+
+	summaries, err := store.List(Filter{Profile: "small"})
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(summaries) != 2 || summaries[0].RunId != "c" || summaries[1].RunId != "a" {
+		t.Errorf("unexpected runs %+v", summaries)
+	}
+
+	summaries, _ = store.List(Filter{Since: start.Add(30 * time.Second), Limit: 1})
+	if len(summaries) != 1 || summaries[0].RunId != "c" {
+		t.Errorf("unexpected runs %+v", summaries)
+	}
+
+	if _, err = store.Get("unknown"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCompareAndTrend(t *testing.T) {
+	store := openStore(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := saveRun(t, store, "a", "small", start, 100)
+	saveRun(t, store, "b", "large", start.Add(time.Minute), 1000)
+	other := saveRun(t, store, "c", "small", start.Add(2*time.Minute), 150)
+
+	comparison := Compare(base, other)
+	for _, delta := range comparison.Metrics {
+		if delta.Metric == "spansPerSecond" {
+			if delta.Delta != 50 || delta.DeltaPercent == nil || *delta.DeltaPercent != 50 {
+				t.Errorf("unexpected delta %+v", delta)
+			}
+		}
+		if delta.Metric == "spansFailed" && delta.DeltaPercent != nil {
+			t.Errorf("expected no percentage for a zero base, got %+v", delta)
+		}
+	}
+
+	points, err := store.Trend("small", "spansPerSecond", 10)
+	if err != nil {
+		t.Fatalf("trend failed: %v", err)
+	}
+	if len(points) != 2 || points[0].Value != 100 || points[1].Value != 150 {
+		t.Errorf("unexpected trend %+v", points)
+	}
+	if _, err = store.Trend("", "spansPerSecond", 10); err == nil {
+		t.Errorf("expected an error without a profile")
+	}
+}
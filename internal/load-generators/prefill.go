@@ -0,0 +1,155 @@
+package load_generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+	"redis-test/internal/metrics"
+	"redis-test/internal/redisstats"
+	"redis-test/internal/report"
+	"redis-test/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxMemoryField = "maxmemory"
+	// the first chunk measures the growth of used_memory per trace, the next ones aim at the target
+	prefillFirstChunk = 100
+	prefillMaxChunk   = 10000
+	// keeps the ids of the prefill traces away from the ones of the measured traces
+	prefillSeedStride = 1 << 32
+)
+
+var ErrInvalidPrefillTarget = errors.New("invalid prefill target")
+
+type memoryTarget struct {
+	bytes   int64
+	percent float64
+}
+
+// parseMemoryTarget accepts a size such as 512mb or a percentage of maxmemory such as 80%.
+func parseMemoryTarget(target string) (memoryTarget, error) {
+	if percent, ok := strings.CutSuffix(strings.TrimSpace(target), "%"); ok {
+		value, err := strconv.ParseFloat(percent, 64)
+		if err != nil || value <= 0 || value > 100 {
+			return memoryTarget{}, fmt.Errorf("%w %q, percentages of maxmemory are in (0, 100]", ErrInvalidPrefillTarget, target)
+		}
+		return memoryTarget{percent: value}, nil
+	}
+	bytes, err := redisstats.ParseMemory(target)
+	if err != nil || bytes == 0 {
+		return memoryTarget{}, fmt.Errorf("%w %q, use a size such as 512mb or a percentage of maxmemory such as 80%%", ErrInvalidPrefillTarget, target)
+	}
+	return memoryTarget{bytes: bytes}, nil
+}
+
+func (t memoryTarget) resolve(maxMemory int64) (int64, error) {
+	if t.percent == 0 {
+		return t.bytes, nil
+	}
+	if maxMemory <= 0 {
+		return 0, errors.New("maxmemory of the server is not set")
+	}
+	return int64(float64(maxMemory) * t.percent / 100), nil
+}
+
+// memoryLevel reads INFO directly, the samples of the collector are too old to stop a fill in time.
+func (redisLoadGenerator *RedisLoadGenerator) memoryLevel() (usedMemory int64, maxMemory int64, err error) {
+	if redisLoadGenerator.statsSource == nil {
+		return 0, 0, errors.New("the trace store has no server to fill")
+	}
+	info, err := redisLoadGenerator.statsSource.ServerInfo()
+	if err != nil {
+		return 0, 0, err
+	}
+	used, ok := info.Float(usedMemoryField)
+	if !ok {
+		return 0, 0, errors.New("the server does not report used_memory")
+	}
+	limit, _ := info.Float(maxMemoryField)
+	return int64(used), int64(limit), nil
+}
+
+// prefill writes as the run <runId>-prefill, so that the prefill is not part of the statistics of the run.
+func (redisLoadGenerator *RedisLoadGenerator) prefill(ctx context.Context, runId string, parameters model.RunParameters) *report.Prefill {
+	start := time.Now()
+	result := &report.Prefill{Target: parameters.PrefillMemory}
+	defer func() { result.DurationSeconds = time.Since(start).Seconds() }()
+	fail := func(err error) *report.Prefill {
+		zkLogger.ErrorF(loadGeneratorLogTag, "prefill of run %s stopped: %v", runId, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	target, err := parseMemoryTarget(parameters.PrefillMemory)
+	if err != nil {
+		return fail(err)
+	}
+	usedMemory, maxMemory, err := redisLoadGenerator.memoryLevel()
+	if err != nil {
+		return fail(err)
+	}
+	result.MaxMemoryBytes = maxMemory
+	result.UsedMemoryBefore, result.UsedMemoryAfter = usedMemory, usedMemory
+	if result.TargetBytes, err = target.resolve(maxMemory); err != nil {
+		return fail(err)
+	}
+
+	prefillRunId := runId + "-prefill"
+	recorder := redisLoadGenerator.traceHandler.Metrics().RunStarted(prefillRunId)
+	defer func() {
+		redisLoadGenerator.traceHandler.Metrics().RunFinished(prefillRunId)
+		counts := recorder.Counts()
+		result.Traces, result.Spans = counts.TracesWritten, counts.SpansWritten
+		metrics.ForgetRun(prefillRunId)
+	}()
+
+	chunkTraces := prefillFirstChunk
+	for chunk := int64(1); usedMemory < result.TargetBytes; chunk++ {
+		chunkParameters := parameters
+		chunkParameters.TraceCount = chunkTraces
+		chunkParameters.Seed = parameters.Seed + chunk*prefillSeedStride
+		redisLoadGenerator.traceHandler.PushDataToRedis(ctx, prefillRunId, chunkParameters)
+		if !redisLoadGenerator.waitForDrain(ctx, prefillRunId) {
+			if ctx.Err() != nil {
+				return fail(ctx.Err())
+			}
+			return fail(errors.New("the prefill spans were not written before the drain timeout"))
+		}
+
+		before := usedMemory
+		if usedMemory, _, err = redisLoadGenerator.memoryLevel(); err != nil {
+			return fail(err)
+		}
+		result.UsedMemoryAfter = usedMemory
+		growth := usedMemory - before
+		if growth <= 0 {
+			return fail(fmt.Errorf("used_memory stopped growing at %d bytes, keys are evicted or expire as fast as they are written", usedMemory))
+		}
+		// aim the next chunk at the target with the growth per trace of this one
+		chunkTraces = int(min(prefillMaxChunk, (result.TargetBytes-usedMemory)*int64(chunkTraces)/growth+1))
+	}
+	result.Reached = true
+	zkLogger.InfoF(loadGeneratorLogTag, "prefill of run %s reached %d bytes of used memory", runId, usedMemory)
+	return result
+}
+
+// prefilled restarts the run from now, once its prefill is written.
+func (redisLoadGenerator *RedisLoadGenerator) prefilled(runId string, prefill *report.Prefill) {
+	redisLoadGenerator.runsMutex.Lock()
+	defer redisLoadGenerator.runsMutex.Unlock()
+	state := redisLoadGenerator.runStates[runId]
+	state.report.StartTime = state.recorder.Restart()
+	state.report.Prefill = prefill
+}
+
+func checkPrefill(parameters model.RunParameters) error {
+	if parameters.PrefillMemory == "" {
+		return nil
+	}
+	_, err := parseMemoryTarget(parameters.PrefillMemory)
+	return err
+}
@@ -0,0 +1,38 @@
+package load_generators
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMemoryTarget(t *testing.T) {
+	for _, test := range []struct {
+		target    string
+		maxMemory int64
+		bytes     int64
+	}{
+		{"512mb", 0, 512 << 20},
+		{"2g", 0, 2000000000},
+		{"1048576", 0, 1 << 20},
+		{"80%", 1000, 800},
+		{" 12.5% ", 1000, 125},
+	} {
+		target, err := parseMemoryTarget(test.target)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.target, err)
+			continue
+		}
+		if bytes, err := target.resolve(test.maxMemory); err != nil || bytes != test.bytes {
+			t.Errorf("%s: expected %d bytes, got %d: %v", test.target, test.bytes, bytes, err)
+		}
+	}
+	for _, target := range []string{"", "lots", "0", "0%", "120%", "-5%"} {
+		if _, err := parseMemoryTarget(target); !errors.Is(err, ErrInvalidPrefillTarget) {
+			t.Errorf("%q: expected an invalid target, got %v", target, err)
+		}
+	}
+	percent, _ := parseMemoryTarget("50%")
+	if _, err := percent.resolve(0); err == nil {
+		t.Error("expected a percentage to need maxmemory")
+	}
+}
@@ -0,0 +1,147 @@
+package load_generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+	"math"
+	"redis-test/handlers"
+	"redis-test/internal/metrics"
+	"redis-test/internal/report"
+	"redis-test/model"
+	"sort"
+	"time"
+)
+
+const (
+	maxMemoryPolicyField = "maxmemory_policy"
+	// maxPressureTraces bounds the traces of a pressure run that has no trace count.
+	maxPressureTraces = math.MaxInt32
+	// baselineProbes is the number of probe writes the baseline latency is the median of.
+	baselineProbes = 5
+	probeTtl       = time.Minute
+	// minRecoveryLatency keeps the recovery latency above the jitter of a fast local server.
+	minRecoveryLatency = time.Millisecond
+)
+
+var ErrPressureUnsupported = errors.New("memory pressure runs are not supported")
+
+func (redisLoadGenerator *RedisLoadGenerator) checkPressure() error {
+	if redisLoadGenerator.traceHandler.Prober() == nil {
+		return fmt.Errorf("%w: the %s store cannot probe writes", ErrPressureUnsupported, redisLoadGenerator.cfg.Traces.Backend)
+	}
+	_, maxMemory, err := redisLoadGenerator.memoryLevel()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPressureUnsupported, err)
+	}
+	if maxMemory <= 0 {
+		return fmt.Errorf("%w: maxmemory of the server is not set", ErrPressureUnsupported)
+	}
+	return nil
+}
+
+// pressure stops the load once used_memory has been at maxmemory for the overload, then probes writes
+// until the server recovers.
+func (redisLoadGenerator *RedisLoadGenerator) pressure(ctx context.Context, runId string, parameters model.RunParameters) *report.Pressure {
+	pressureConfig := redisLoadGenerator.cfg.Pressure
+	overload := parameters.OverloadSeconds
+	if overload <= 0 {
+		overload = pressureConfig.OverloadSeconds
+	}
+	result := &report.Pressure{OverloadSeconds: overload}
+	recorder := metrics.Run(runId)
+	if recorder == nil {
+		result.Error = fmt.Sprintf("run %s is not recorded", runId)
+		return result
+	}
+	_, maxMemory, err := redisLoadGenerator.memoryLevel()
+	if err != nil {
+		result.Error = fmt.Sprintf("unable to read maxmemory: %v", err)
+		return result
+	}
+	result.MaxMemoryBytes = maxMemory
+	result.MaxMemoryPolicy = redisLoadGenerator.serverInfo()[maxMemoryPolicyField]
+
+	prober := redisLoadGenerator.traceHandler.Prober()
+	probeKey := parameters.KeyPrefix + "pressure:probe:" + runId
+	baseline := redisLoadGenerator.baselineProbe(prober, probeKey)
+	result.BaselineProbeUs = baseline.Microseconds()
+
+	loadCtx, stopLoad := context.WithCancel(ctx)
+	defer stopLoad()
+	loadDone := make(chan struct{})
+	go func() {
+		defer close(loadDone)
+		redisLoadGenerator.traceHandler.PushDataToRedis(loadCtx, runId, parameters)
+	}()
+
+	interval := time.Duration(pressureConfig.ProbeIntervalMS) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(time.Duration(pressureConfig.MaxSeconds) * time.Second)
+	var reachedAt time.Time
+	for loading := true; loading; {
+		select {
+		case <-loadDone:
+			loading = false
+		case <-ticker.C:
+			if usedMemory, _, err := redisLoadGenerator.memoryLevel(); err == nil && usedMemory >= maxMemory && reachedAt.IsZero() {
+				reachedAt = time.Now()
+				zkLogger.InfoF(loadGeneratorLogTag, "run %s reached maxmemory, overloading for %ds", runId, overload)
+			}
+			overloaded := !reachedAt.IsZero() && time.Since(reachedAt) >= time.Duration(overload)*time.Second
+			if overloaded || time.Now().After(deadline) {
+				stopLoad()
+				<-loadDone
+				loading = false
+			}
+		}
+	}
+	stoppedAt := time.Now()
+	result.LoadStoppedSeconds = stoppedAt.Sub(recorder.Start()).Seconds()
+	if !reachedAt.IsZero() {
+		result.Reached = true
+		result.ReachedSeconds = reachedAt.Sub(recorder.Start()).Seconds()
+	}
+	if ctx.Err() != nil {
+		return result
+	}
+
+	threshold := max(time.Duration(float64(baseline)*pressureConfig.RecoveryLatencyFactor), minRecoveryLatency)
+	recoveryDeadline := stoppedAt.Add(time.Duration(pressureConfig.RecoveryTimeoutSeconds) * time.Second)
+	for {
+		latency, err := prober.ProbeWrite(probeKey, probeTtl)
+		if err == nil && latency <= threshold {
+			result.Recovered = true
+			result.RecoverySeconds = time.Since(stoppedAt).Seconds()
+			return result
+		}
+		if time.Now().After(recoveryDeadline) {
+			zkLogger.ErrorF(loadGeneratorLogTag, "run %s did not recover within %ds, last probe took %v: %v", runId, pressureConfig.RecoveryTimeoutSeconds, latency, err)
+			return result
+		}
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (redisLoadGenerator *RedisLoadGenerator) baselineProbe(prober handlers.WriteProber, probeKey string) time.Duration {
+	latencies := make([]time.Duration, 0, baselineProbes)
+	for i := 0; i < baselineProbes; i++ {
+		latency, err := prober.ProbeWrite(probeKey, probeTtl)
+		if err != nil {
+			zkLogger.ErrorF(loadGeneratorLogTag, "unable to probe writes before the run: %v", err)
+			continue
+		}
+		latencies = append(latencies, latency)
+	}
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[len(latencies)/2]
+}
@@ -1,30 +1,128 @@
 package load_generators
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
 	"redis-test/config"
 	"redis-test/handlers"
+	"redis-test/internal/history"
+	"redis-test/internal/metrics"
+	"redis-test/internal/redisstats"
+	"redis-test/internal/report"
+	"redis-test/model"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	traceRedisHandlerLogTag = "TraceRedisHandler"
-	spansPerTrace           = 10
+	loadGeneratorLogTag = "RedisLoadGenerator"
+	spansPerTrace       = 10
+	drainPollInterval   = 10 * time.Millisecond
+	usedMemoryField     = "used_memory"
 )
 
+var ErrShuttingDown = errors.New("load generator is shutting down")
+var ErrUnknownRun = errors.New("unknown run")
+var ErrRunActive = errors.New("run is still active")
+var ErrRunNotNamespaced = errors.New("run keys are not namespaced, start the run with namespace=true")
+
+type runState struct {
+	report   *report.Report
+	recorder *metrics.RunRecorder
+	// done is closed once the final report is available.
+	done chan struct{}
+}
+
 type RedisLoadGenerator struct {
 	id           string
 	cfg          config.AppConfigs
 	traceHandler *handlers.TraceHandler
+
+	runsMutex  sync.Mutex
+	activeRuns sync.WaitGroup
+	runCancels map[string]context.CancelFunc
+	closing    bool
+
+	// runStates holds the runs in the order they were started, bounded by reports.maxRuns.
+	runStates map[string]*runState
+	runOrder  []string
+
+	// history keeps the final reports beyond the process lifetime, it is nil if disabled.
+	history *history.Store
+	// statsSource is the server behind the trace store and collector samples it, both are nil if
+	// the store has no server. collector is also nil if redisStats is disabled.
+	statsSource redisstats.Source
+	collector   *redisstats.Collector
 }
 
-func (redisLoadGenerator RedisLoadGenerator) Close() {
+// Close stops accepting new runs and waits for the active runs to finish. Runs still active after
+// the grace period are cancelled, the spans still waiting for room in the queue or for redis to come
+// back are dropped. The pending spans are then flushed and the connections closed.
+func (redisLoadGenerator *RedisLoadGenerator) Close(gracePeriod time.Duration) {
+	redisLoadGenerator.runsMutex.Lock()
+	if redisLoadGenerator.closing {
+		redisLoadGenerator.runsMutex.Unlock()
+		return
+	}
+	redisLoadGenerator.closing = true
+	activeRunCount := len(redisLoadGenerator.runCancels)
+	redisLoadGenerator.runsMutex.Unlock()
+
+	zkLogger.InfoF(loadGeneratorLogTag, "shutting down, waiting for %d active runs", activeRunCount)
+
+	drained := make(chan struct{})
+	go func() {
+		redisLoadGenerator.activeRuns.Wait()
+		close(drained)
+	}()
 
+	select {
+	case <-drained:
+	case <-time.After(gracePeriod):
+		// the writer stops waiting for redis, so the cancelled runs are not left blocked on a full queue
+		redisLoadGenerator.traceHandler.StopWaiting()
+		redisLoadGenerator.runsMutex.Lock()
+		for runId, cancel := range redisLoadGenerator.runCancels {
+			zkLogger.InfoF(loadGeneratorLogTag, "grace period over, cancelling run %s", runId)
+			cancel()
+		}
+		redisLoadGenerator.runsMutex.Unlock()
+		<-drained
+	}
+
+	if redisLoadGenerator.collector != nil {
+		redisLoadGenerator.collector.Stop()
+	}
+	redisLoadGenerator.traceHandler.Close()
+	if redisLoadGenerator.history != nil {
+		if err := redisLoadGenerator.history.Close(); err != nil {
+			zkLogger.ErrorF(loadGeneratorLogTag, "unable to close run history: %v", err)
+		}
+	}
 }
 
 func NewRedisLoadGenerator(cfg config.AppConfigs) (*RedisLoadGenerator, error) {
+	metrics.SetMaxRunLabels(cfg.Metrics.MaxRunLabels)
+
+	var runHistory *history.Store
+	if cfg.Reports.HistoryPath != "" {
+		var err error
+		runHistory, err = history.Open(cfg.Reports.HistoryPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open run history %s: %w", cfg.Reports.HistoryPath, err)
+		}
+	}
 
 	traceHandler, err := handlers.NewTraceHandler(&cfg)
 	if err != nil {
+		if runHistory != nil {
+			_ = runHistory.Close()
+		}
 		return nil, err
 	}
 
@@ -32,11 +130,500 @@ func NewRedisLoadGenerator(cfg config.AppConfigs) (*RedisLoadGenerator, error) {
 		id:           "RLG" + uuid.New().String(),
 		traceHandler: traceHandler,
 		cfg:          cfg,
+		runCancels:   make(map[string]context.CancelFunc),
+		runStates:    make(map[string]*runState),
+		history:      runHistory,
+		statsSource:  traceHandler.StatsSource(),
+	}
+	if fp.statsSource != nil && cfg.RedisStats.Enabled {
+		interval := time.Duration(cfg.RedisStats.SampleIntervalMS) * time.Millisecond
+		fp.collector = redisstats.NewCollector(fp.statsSource, metrics.ServerObserver{}, interval)
+		fp.collector.Start()
 	}
 	return &fp, nil
 }
 
-func (redisLoadGenerator RedisLoadGenerator) GenerateLoad(traceCount int) {
+// GenerateLoad starts a run in the background and returns its id.
+func (redisLoadGenerator *RedisLoadGenerator) GenerateLoad(parameters model.RunParameters) (string, error) {
+	// the parameters are checked before taking runsMutex, loading a script or a function is a round trip
+	if parameters.SpansPerTrace <= 0 {
+		parameters.SpansPerTrace = spansPerTrace
+	}
+	for _, name := range parameters.Codecs {
+		if _, err := redisLoadGenerator.traceHandler.Codec(name); err != nil {
+			return "", err
+		}
+	}
+	if err := redisLoadGenerator.traceHandler.CheckWriteMode(parameters.WriteMode); err != nil {
+		return "", err
+	}
+	if err := redisLoadGenerator.traceHandler.CheckTtlStrategy(parameters.TtlStrategy, parameters.WriteMode); err != nil {
+		return "", err
+	}
+	if err := handlers.CheckVerifyMode(parameters.Verify); err != nil {
+		return "", err
+	}
+	if err := checkPrefill(parameters); err != nil {
+		return "", err
+	}
+	if parameters.Pressure {
+		if err := redisLoadGenerator.checkPressure(); err != nil {
+			return "", err
+		}
+		if parameters.TraceCount <= 0 {
+			parameters.TraceCount = maxPressureTraces
+		}
+		// evicted traces would be missing, so they are only verified if asked for
+		if parameters.Verify == "" {
+			parameters.Verify = handlers.VerifyOff
+		}
+	}
+	if parameters.Seed == 0 {
+		parameters.Seed = time.Now().UnixNano()
+	}
+
+	redisLoadGenerator.runsMutex.Lock()
+	defer redisLoadGenerator.runsMutex.Unlock()
+	if redisLoadGenerator.closing {
+		return "", ErrShuttingDown
+	}
+
 	runId := uuid.New().String()
-	redisLoadGenerator.traceHandler.PushDataToRedis(runId, traceCount, spansPerTrace)
+	if parameters.Namespace {
+		parameters.KeyPrefix = handlers.RunKeyPrefix(runId)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	redisLoadGenerator.runCancels[runId] = cancel
+	redisLoadGenerator.activeRuns.Add(1)
+	recorder := redisLoadGenerator.traceHandler.Metrics().RunStarted(runId)
+	recorder.SetMemoryProbe(redisLoadGenerator.usedMemory)
+	redisLoadGenerator.addRunState(runId, &runState{
+		report:   report.New(runId, redisLoadGenerator.profile(parameters), parameters, redisLoadGenerator.cfg, time.Now()),
+		recorder: recorder,
+		done:     make(chan struct{}),
+	})
+
+	go redisLoadGenerator.run(ctx, runId, parameters)
+	return runId, nil
+}
+
+func (redisLoadGenerator *RedisLoadGenerator) profile(parameters model.RunParameters) string {
+	if parameters.Profile != "" {
+		return parameters.Profile
+	}
+	// the parts left empty, e.g. the backend of a config without traces.backend, are left out
+	var parts []string
+	if backend := redisLoadGenerator.cfg.Traces.Backend; backend != "" {
+		parts = append(parts, backend)
+	}
+	// the trace count of an unbounded pressure run is only a limit, the run ends at maxmemory
+	if !parameters.Pressure || parameters.TraceCount != maxPressureTraces {
+		parts = append(parts, fmt.Sprintf("traces=%d", parameters.TraceCount))
+	}
+	parts = append(parts, fmt.Sprintf("spansPerTrace=%d", parameters.SpansPerTrace))
+	if layout := redisLoadGenerator.cfg.Traces.Layout; layout != "" && layout != handlers.LayoutHash {
+		parts = append(parts, "layout="+layout)
+	}
+	if len(parameters.Codecs) > 0 {
+		parts = append(parts, "codecs="+strings.Join(parameters.Codecs, ","))
+	}
+	if parameters.WriteMode != "" && parameters.WriteMode != handlers.WriteModePipeline {
+		parts = append(parts, "writeMode="+parameters.WriteMode)
+	}
+	if parameters.Scenarios > 0 {
+		parts = append(parts, fmt.Sprintf("scenarios=%d", parameters.Scenarios))
+	}
+	if strategy := redisLoadGenerator.traceHandler.TtlStrategy(parameters); strategy != handlers.TtlPerSpan {
+		parts = append(parts, "ttl="+strategy)
+	}
+	if parameters.PrefillMemory != "" {
+		parts = append(parts, "prefill="+parameters.PrefillMemory)
+	}
+	if parameters.Pressure {
+		parts = append(parts, "pressure")
+	}
+	return strings.Join(parts, "/")
+}
+
+// addRunState forgets the oldest finished runs beyond reports.maxRuns. The caller must hold runsMutex.
+func (redisLoadGenerator *RedisLoadGenerator) addRunState(runId string, state *runState) {
+	redisLoadGenerator.runStates[runId] = state
+	redisLoadGenerator.runOrder = append(redisLoadGenerator.runOrder, runId)
+
+	maxRuns := redisLoadGenerator.cfg.Reports.MaxRuns
+	for i := 0; len(redisLoadGenerator.runOrder) > maxRuns && maxRuns > 0 && i < len(redisLoadGenerator.runOrder); {
+		candidate := redisLoadGenerator.runOrder[i]
+		if redisLoadGenerator.runStates[candidate].report.Status == report.StatusRunning {
+			i++
+			continue
+		}
+		redisLoadGenerator.runOrder = append(redisLoadGenerator.runOrder[:i], redisLoadGenerator.runOrder[i+1:]...)
+		delete(redisLoadGenerator.runStates, candidate)
+		metrics.ForgetRun(candidate)
+	}
+}
+
+func (redisLoadGenerator *RedisLoadGenerator) run(ctx context.Context, runId string, parameters model.RunParameters) {
+	defer redisLoadGenerator.activeRuns.Done()
+
+	if parameters.PrefillMemory != "" {
+		redisLoadGenerator.prefilled(runId, redisLoadGenerator.prefill(ctx, runId, parameters))
+	}
+	infoBefore := redisLoadGenerator.serverInfo()
+	var pressure *report.Pressure
+	if parameters.Pressure {
+		pressure = redisLoadGenerator.pressure(ctx, runId, parameters)
+	} else {
+		redisLoadGenerator.traceHandler.PushDataToRedis(ctx, runId, parameters)
+	}
+	drained := redisLoadGenerator.waitForDrain(ctx, runId)
+	infoAfter := redisLoadGenerator.serverInfo()
+	if redisLoadGenerator.collector != nil {
+		redisLoadGenerator.collector.Collect()
+	}
+
+	redisLoadGenerator.runsMutex.Lock()
+	status := report.StatusCompleted
+	if ctx.Err() != nil {
+		status = report.StatusCancelled
+	} else if !drained {
+		status = report.StatusIncomplete
+	}
+	redisLoadGenerator.runCancels[runId]()
+	delete(redisLoadGenerator.runCancels, runId)
+	redisLoadGenerator.runsMutex.Unlock()
+
+	redisLoadGenerator.traceHandler.Metrics().RunFinished(runId)
+	var memory *redisstats.MemoryAnalysis
+	var reads *report.ReadCheck
+	var expiry *report.ExpiryCheck
+	if status == report.StatusCompleted {
+		memory = redisLoadGenerator.analyzeMemory(runId, parameters)
+		reads = redisLoadGenerator.verifyTraces(runId, parameters)
+		expiry = redisLoadGenerator.checkExpiry(runId, parameters)
+	}
+	runReport, done := redisLoadGenerator.finishReport(runId, status, infoBefore, infoAfter, memory, reads, expiry, pressure)
+
+	if redisLoadGenerator.history != nil {
+		if err := redisLoadGenerator.history.Save(runReport); err != nil {
+			zkLogger.ErrorF(loadGeneratorLogTag, "unable to save run %s to the history: %v", runId, err)
+		}
+	}
+	if dir := redisLoadGenerator.cfg.Reports.Dir; dir != "" {
+		paths, err := runReport.WriteFiles(dir)
+		if err != nil {
+			zkLogger.ErrorF(loadGeneratorLogTag, "unable to write report of run %s: %v", runId, err)
+		} else {
+			zkLogger.InfoF(loadGeneratorLogTag, "report of run %s written to %v", runId, paths)
+		}
+	}
+	// waiters are released once the report is saved, so the history already holds the run
+	close(done)
+
+	stats := redisLoadGenerator.traceHandler.StoreStats()
+	zkLogger.InfoF(loadGeneratorLogTag, "run %s %s. store writes = %d, failed writes = %d", runId, status, stats.Writes, stats.WriteErrors)
+}
+
+// waitForDrain waits until every span generated by the run was written, failed or dropped. It returns
+// false if spans are still pending after the drain timeout or when the run is cancelled.
+func (redisLoadGenerator *RedisLoadGenerator) waitForDrain(ctx context.Context, runId string) bool {
+	recorder := metrics.Run(runId)
+	if recorder == nil {
+		return false
+	}
+	deadline := time.Now().Add(time.Duration(redisLoadGenerator.cfg.Reports.DrainTimeoutSeconds) * time.Second)
+	for recorder.Counts().Pending() > 0 {
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+func (redisLoadGenerator *RedisLoadGenerator) serverInfo() redisstats.Info {
+	if redisLoadGenerator.statsSource == nil {
+		return nil
+	}
+	info, err := redisLoadGenerator.statsSource.ServerInfo()
+	if err != nil {
+		zkLogger.ErrorF(loadGeneratorLogTag, "unable to read server info: %v", err)
+	}
+	return info
+}
+
+// analyzeMemory measures the memory taken by a sample of the traces of a run and projects it for
+// the configured ttl. It returns nil if the store cannot measure traces.
+func (redisLoadGenerator *RedisLoadGenerator) analyzeMemory(runId string, parameters model.RunParameters) *redisstats.MemoryAnalysis {
+	probe := redisLoadGenerator.traceHandler.MemoryProbe()
+	recorder := metrics.Run(runId)
+	if probe == nil || recorder == nil {
+		return nil
+	}
+	analysis := redisstats.AnalyzeMemory(probe, recorder.SampledTraces())
+
+	tracesPerSecond := parameters.ProjectTracesPerSecond
+	if tracesPerSecond <= 0 {
+		if duration := time.Since(recorder.Start()).Seconds(); duration > 0 {
+			tracesPerSecond = float64(recorder.Counts().TracesWritten) / duration
+		}
+	}
+	projection := analysis.Project(tracesPerSecond, redisLoadGenerator.cfg.Traces.Ttl)
+	analysis.Projection = &projection
+	return &analysis
+}
+
+// verifyTraces reads the traces of a run back, the sampled ones or all of them depending on its verify
+// mode, and verifies them against the traces generated again from its seed. It returns nil if the
+// traces are not verified or the store cannot read traces.
+func (redisLoadGenerator *RedisLoadGenerator) verifyTraces(runId string, parameters model.RunParameters) *report.ReadCheck {
+	recorder := metrics.Run(runId)
+	if parameters.Verify == handlers.VerifyOff || redisLoadGenerator.traceHandler.Reader() == nil || recorder == nil {
+		return nil
+	}
+	mode := parameters.Verify
+	if mode == "" {
+		mode = handlers.VerifySample
+	}
+	var sampled map[string]bool
+	if mode == handlers.VerifySample {
+		sampled = make(map[string]bool)
+		for _, sample := range recorder.SampledTraces() {
+			sampled[sample.TraceId] = true
+		}
+	}
+
+	// a pressure run stops before it generates all of its traces
+	parameters.TraceCount = int(min(int64(parameters.TraceCount), recorder.Counts().TracesGenerated))
+	reads := &report.ReadCheck{Mode: mode, Seed: parameters.Seed}
+	// the stream-bucket reader ranges over buckets shared with the other traces
+	reads.FullScan = redisLoadGenerator.cfg.Traces.Layout == handlers.LayoutStreamBucket
+	ttlStrategy := redisLoadGenerator.traceHandler.TtlStrategy(parameters)
+	handlers.ExpectedTraces(parameters, func(trace handlers.ExpectedTrace) bool {
+		if sampled != nil && !sampled[trace.TraceId] {
+			return true
+		}
+		reads.Traces++
+		verification, err := redisLoadGenerator.traceHandler.VerifyTrace(runId, trace, ttlStrategy, recorder.Start())
+		reads.Spans += verification.Spans
+		switch {
+		case err != nil:
+			zkLogger.ErrorF(loadGeneratorLogTag, "unable to verify trace %s of run %s: %v", trace.TraceId, runId, err)
+			reads.Failed++
+			return true
+		case !verification.Found:
+			reads.Missing++
+		case verification.Missing > 0:
+			reads.Incomplete++
+		}
+		reads.MissingSpans += verification.Missing
+		reads.CorruptSpans += verification.Corrupt
+		reads.OrphanedSpans += verification.Orphaned
+		reads.UnexpectedSpans += verification.Unexpected
+		if verification.Found && !verification.Rooted {
+			reads.Unrooted++
+		}
+		if verification.TtlChecked {
+			reads.TtlChecked++
+			if !verification.TtlInRange {
+				reads.TtlOutOfRange++
+			}
+		}
+		reads.AddProblems(verification.Problems)
+		return true
+	})
+	return reads
+}
+
+// checkExpiry checks that the sampled traces of a run will expire with its ttl strategy. It returns
+// nil if the store cannot tell the ttl of a trace.
+func (redisLoadGenerator *RedisLoadGenerator) checkExpiry(runId string, parameters model.RunParameters) *report.ExpiryCheck {
+	probe := redisLoadGenerator.traceHandler.TtlProbe()
+	recorder := metrics.Run(runId)
+	if probe == nil || recorder == nil {
+		return nil
+	}
+	expiry := &report.ExpiryCheck{Strategy: redisLoadGenerator.traceHandler.TtlStrategy(parameters)}
+	for _, sample := range recorder.SampledTraces() {
+		ttl, err := probe.TraceTtl(sample.TraceId)
+		switch {
+		case err != nil:
+			zkLogger.ErrorF(loadGeneratorLogTag, "unable to read the ttl of trace %s of run %s: %v", sample.TraceId, runId, err)
+			continue
+		case ttl == -2:
+			// not found, the read check counts it as missing
+			continue
+		case ttl < 0:
+			expiry.Unbounded++
+		case int64(ttl/time.Second) > expiry.MaxTtlSeconds:
+			expiry.MaxTtlSeconds = int64(ttl / time.Second)
+		}
+		expiry.Traces++
+	}
+	return expiry
+}
+
+func (redisLoadGenerator *RedisLoadGenerator) usedMemory() (int64, bool) {
+	if redisLoadGenerator.collector != nil {
+		sample, ok := redisLoadGenerator.collector.Latest()
+		usedMemory, found := sample.Fields[usedMemoryField]
+		return int64(usedMemory), ok && found
+	}
+	info := redisLoadGenerator.serverInfo()
+	usedMemory, ok := info.Float(usedMemoryField)
+	return int64(usedMemory), ok
+}
+
+// finishReport returns the channel to close once the report is saved.
+func (redisLoadGenerator *RedisLoadGenerator) finishReport(runId string, status report.Status, infoBefore, infoAfter redisstats.Info, memory *redisstats.MemoryAnalysis, reads *report.ReadCheck, expiry *report.ExpiryCheck, pressure *report.Pressure) (report.Report, chan struct{}) {
+	redisLoadGenerator.runsMutex.Lock()
+	defer redisLoadGenerator.runsMutex.Unlock()
+
+	state := redisLoadGenerator.runStates[runId]
+	end := time.Now()
+	final := redisLoadGenerator.withServerStats(state.report.WithSnapshot(state.recorder.Snapshot(), end), end)
+	final.Status = status
+	final.EndTime = end
+	if infoBefore != nil && infoAfter != nil {
+		final.RedisDelta = redisstats.Delta(infoBefore, infoAfter)
+	}
+	final.SetMemory(memory)
+	final.Reads = reads
+	final.SetExpiry(expiry)
+	final.SetPressure(pressure)
+	final.Verdict = report.Evaluate(final)
+	state.report = &final
+	return final, state.done
+}
+
+func (redisLoadGenerator *RedisLoadGenerator) withServerStats(runReport report.Report, end time.Time) report.Report {
+	if redisLoadGenerator.collector != nil {
+		window := redisLoadGenerator.collector.Window(runReport.StartTime, end)
+		runReport.Server = &window
+	}
+	return runReport
+}
+
+// ExpiryStats returns what the expiry watcher of the trace store observed.
+func (redisLoadGenerator *RedisLoadGenerator) ExpiryStats() handlers.ExpiryStats {
+	return redisLoadGenerator.traceHandler.ExpiryStats()
+}
+
+// WaitForReport waits until the run is over and returns its final report.
+func (redisLoadGenerator *RedisLoadGenerator) WaitForReport(ctx context.Context, runId string) (report.Report, error) {
+	redisLoadGenerator.runsMutex.Lock()
+	state, ok := redisLoadGenerator.runStates[runId]
+	redisLoadGenerator.runsMutex.Unlock()
+	if !ok {
+		return report.Report{}, ErrUnknownRun
+	}
+
+	select {
+	case <-state.done:
+	case <-ctx.Done():
+		return report.Report{}, ctx.Err()
+	}
+	return redisLoadGenerator.Report(runId)
+}
+
+// Report returns the report of a run. The report of an active run covers the run so far, runs no
+// longer kept in memory are looked up in the history.
+func (redisLoadGenerator *RedisLoadGenerator) Report(runId string) (report.Report, error) {
+	redisLoadGenerator.runsMutex.Lock()
+	defer redisLoadGenerator.runsMutex.Unlock()
+
+	state, ok := redisLoadGenerator.runStates[runId]
+	if !ok {
+		if redisLoadGenerator.history != nil {
+			if stored, err := redisLoadGenerator.history.Get(runId); err == nil {
+				return stored, nil
+			}
+		}
+		return report.Report{}, ErrUnknownRun
+	}
+	if state.report.Status == report.StatusRunning {
+		now := time.Now()
+		return redisLoadGenerator.withServerStats(state.report.WithSnapshot(state.recorder.Snapshot(), now), now), nil
+	}
+	return *state.report, nil
+}
+
+// History returns the run history, or nil if reports.historyPath is not set.
+func (redisLoadGenerator *RedisLoadGenerator) History() *history.Store {
+	return redisLoadGenerator.history
+}
+
+// Runs lists the known runs, oldest first.
+func (redisLoadGenerator *RedisLoadGenerator) Runs() []report.Summary {
+	redisLoadGenerator.runsMutex.Lock()
+	defer redisLoadGenerator.runsMutex.Unlock()
+
+	summaries := make([]report.Summary, 0, len(redisLoadGenerator.runStates))
+	for _, state := range redisLoadGenerator.runStates {
+		summaries = append(summaries, state.report.Summary())
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].StartTime.Before(summaries[j].StartTime) })
+	return summaries
+}
+
+// CleanupRun deletes the keys of a namespaced run once it is over, at most keysPerSecond a second or
+// cleanup.keysPerSecond if it is not set.
+func (redisLoadGenerator *RedisLoadGenerator) CleanupRun(ctx context.Context, runId string, keysPerSecond int) (handlers.CleanupResult, error) {
+	runReport, err := redisLoadGenerator.Report(runId)
+	if err != nil {
+		return handlers.CleanupResult{}, err
+	}
+	switch {
+	case runReport.Status == report.StatusRunning:
+		return handlers.CleanupResult{}, ErrRunActive
+	case runReport.Parameters.KeyPrefix == "":
+		return handlers.CleanupResult{}, ErrRunNotNamespaced
+	}
+	cleaner := redisLoadGenerator.traceHandler.Cleaner()
+	if cleaner == nil {
+		return handlers.CleanupResult{}, handlers.ErrCleanupUnsupported
+	}
+	if keysPerSecond <= 0 {
+		keysPerSecond = redisLoadGenerator.cfg.Cleanup.KeysPerSecond
+	}
+
+	result, err := cleaner.DeleteKeys(ctx, runReport.Parameters.KeyPrefix, keysPerSecond, redisLoadGenerator.cfg.Cleanup.ScanCount)
+	if err != nil {
+		zkLogger.ErrorF(loadGeneratorLogTag, "cleanup of run %s stopped after removing %d keys: %v", runId, result.Removed, err)
+		return result, err
+	}
+	zkLogger.InfoF(loadGeneratorLogTag, "cleanup of run %s removed %d keys in %.1fs", runId, result.Removed, result.DurationSeconds)
+	return result, nil
+}
+
+// IsClosing reports whether Close has been called.
+func (redisLoadGenerator *RedisLoadGenerator) IsClosing() bool {
+	redisLoadGenerator.runsMutex.Lock()
+	defer redisLoadGenerator.runsMutex.Unlock()
+	return redisLoadGenerator.closing
+}
+
+// StoreStats returns the health of the trace store and the writes of all the runs so far.
+func (redisLoadGenerator *RedisLoadGenerator) StoreStats() handlers.TraceStoreStats {
+	return redisLoadGenerator.traceHandler.StoreStats()
+}
+
+// StoreHealth returns the health of the connection of the trace store, or nil if it does not monitor one.
+func (redisLoadGenerator *RedisLoadGenerator) StoreHealth() *handlers.RedisHealthStatus {
+	reporter := redisLoadGenerator.traceHandler.Health()
+	if reporter == nil {
+		return nil
+	}
+	health := reporter.HealthStatus()
+	return &health
+}
+
+// CommandStats returns the command and error counts of all the runs so far, false if the trace store
+// does not send redis commands.
+func (redisLoadGenerator *RedisLoadGenerator) CommandStats() (handlers.CommandStatsSnapshot, bool) {
+	reporter := redisLoadGenerator.traceHandler.CommandStats()
+	if reporter == nil {
+		return handlers.CommandStatsSnapshot{}, false
+	}
+	return reporter.CommandStats(), true
 }
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	// latencies are recorded in microseconds, up to a minute
+	maxLatencyMicros = int64(time.Minute / time.Microsecond)
+	// sizes are recorded as counts, e.g. spans per flush
+	maxSize           = int64(10_000_000)
+	significantDigits = 3
+)
+
+// Unit of the values of a histogram.
+type Unit string
+
+const (
+	UnitMicroseconds Unit = "us"
+	UnitMilliseconds Unit = "ms"
+	UnitCount        Unit = "count"
+)
+
+// HistogramSnapshot summarizes an HDR histogram.
+type HistogramSnapshot struct {
+	Unit  Unit    `json:"unit"`
+	Count int64   `json:"count"`
+	Min   int64   `json:"min"`
+	Max   int64   `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   int64   `json:"p50"`
+	P90   int64   `json:"p90"`
+	P99   int64   `json:"p99"`
+	P999  int64   `json:"p999"`
+}
+
+type namedHistogram struct {
+	unit      Unit
+	histogram *hdrhistogram.Histogram
+}
+
+// HdrRecorder keeps HDR histograms by name in memory, so exact percentiles can be put in reports.
+type HdrRecorder struct {
+	mutex      sync.Mutex
+	histograms map[string]*namedHistogram
+}
+
+func NewHdrRecorder() *HdrRecorder {
+	return &HdrRecorder{histograms: make(map[string]*namedHistogram)}
+}
+
+// RecordLatency records a duration in microseconds.
+func (r *HdrRecorder) RecordLatency(name string, duration time.Duration) {
+	r.record(name, UnitMicroseconds, maxLatencyMicros, duration.Microseconds())
+}
+
+// RecordMillis records a duration in milliseconds, for durations that can exceed a minute.
+func (r *HdrRecorder) RecordMillis(name string, duration time.Duration) {
+	r.record(name, UnitMilliseconds, maxSize, duration.Milliseconds())
+}
+
+// RecordSize records a count.
+func (r *HdrRecorder) RecordSize(name string, size int) {
+	r.record(name, UnitCount, maxSize, int64(size))
+}
+
+func (r *HdrRecorder) record(name string, unit Unit, max int64, value int64) {
+	if value > max {
+		value = max
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	h, ok := r.histograms[name]
+	if !ok {
+		h = &namedHistogram{unit: unit, histogram: hdrhistogram.New(0, max, significantDigits)}
+		r.histograms[name] = h
+	}
+	_ = h.histogram.RecordValue(value)
+}
+
+// Snapshot summarizes all the histograms recorded so far.
+func (r *HdrRecorder) Snapshot() map[string]HistogramSnapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	snapshot := make(map[string]HistogramSnapshot, len(r.histograms))
+	for name, h := range r.histograms {
+		snapshot[name] = HistogramSnapshot{
+			Unit:  h.unit,
+			Count: h.histogram.TotalCount(),
+			Min:   h.histogram.Min(),
+			Max:   h.histogram.Max(),
+			Mean:  h.histogram.Mean(),
+			P50:   h.histogram.ValueAtQuantile(50),
+			P90:   h.histogram.ValueAtQuantile(90),
+			P99:   h.histogram.ValueAtQuantile(99),
+			P999:  h.histogram.ValueAtQuantile(99.9),
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHdrRecorderPercentiles(t *testing.T) {
+	recorder := NewHdrRecorder()
+	for i := 1; i <= 1000; i++ {
+		recorder.RecordLatency("latency", time.Duration(i)*time.Millisecond)
+		recorder.RecordSize("size", i)
+	}
+
+	snapshot := recorder.Snapshot()
+	latency := snapshot["latency"]
+	if latency.Unit != UnitMicroseconds || latency.Count != 1000 {
+		t.Fatalf("unexpected latency snapshot %+v", latency)
+	}
+	// values are kept with 3 significant digits
+	assertWithin(t, "p50", latency.P50, 500_000)
+	assertWithin(t, "p99", latency.P99, 990_000)
+	assertWithin(t, "p999", latency.P999, 999_000)
+
+	size := snapshot["size"]
+	if size.Unit != UnitCount || size.Min != 1 || size.Max != 1000 {
+		t.Errorf("unexpected size snapshot %+v", size)
+	}
+}
+
+func TestHdrRecorderClampsLargeValues(t *testing.T) {
+	recorder := NewHdrRecorder()
+	recorder.RecordLatency("latency", time.Hour)
+	assertWithin(t, "max", recorder.Snapshot()["latency"].Max, maxLatencyMicros)
+}
+
+func assertWithin(t *testing.T, name string, actual int64, expected int64) {
+	t.Helper()
+	if diff := actual - expected; diff < -expected/100 || diff > expected/100 {
+		t.Errorf("expected %s to be about %d, got %d", name, expected, actual)
+	}
+}
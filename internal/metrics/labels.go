@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "zk_db_test"
+
+	// overflowRunLabel is used for the runs that exceed the run label limit.
+	overflowRunLabel    = "other"
+	defaultMaxRunLabels = 20
+)
+
+// podLabels is attached to every metric, so that the series of the pods of a deployment can be told apart.
+var podLabels = prometheus.Labels{"pod": podName()}
+
+func podName() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// runLabels deletes the series of the oldest finished run to make room for a new run, or counts the
+// new run under the overflow label if all of them are still active.
+type runLabels struct {
+	mutex    sync.Mutex
+	max      int
+	order    []string
+	active   map[string]bool
+	admitted map[string]bool
+}
+
+var runs = &runLabels{
+	max:      defaultMaxRunLabels,
+	active:   make(map[string]bool),
+	admitted: make(map[string]bool),
+}
+
+// SetMaxRunLabels sets how many runs keep their own series.
+func SetMaxRunLabels(max int) {
+	if max <= 0 {
+		max = defaultMaxRunLabels
+	}
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	runs.max = max
+}
+
+func (l *runLabels) start(runId string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.active[runId] = true
+	if l.admitted[runId] {
+		return
+	}
+	for len(l.order) >= l.max {
+		evicted := false
+		for i, candidate := range l.order {
+			if !l.active[candidate] {
+				l.order = append(l.order[:i], l.order[i+1:]...)
+				delete(l.admitted, candidate)
+				deleteRunSeries(candidate)
+				evicted = true
+				break
+			}
+		}
+		if !evicted {
+			return
+		}
+	}
+	l.order = append(l.order, runId)
+	l.admitted[runId] = true
+}
+
+func (l *runLabels) finish(runId string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.active, runId)
+}
+
+func (l *runLabels) label(runId string) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.admitted[runId] {
+		return runId
+	}
+	return overflowRunLabel
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import "testing"
+
+func newRunLabels(max int) *runLabels {
+	return &runLabels{max: max, active: make(map[string]bool), admitted: make(map[string]bool)}
+}
+
+func TestRunLabelsEvictFinishedRuns(t *testing.T) {
+	labels := newRunLabels(2)
+	labels.start("run-1")
+	labels.start("run-2")
+	labels.finish("run-1")
+
+	labels.start("run-3")
+	if got := labels.label("run-1"); got != overflowRunLabel {
+		t.Errorf("expected the finished run to be evicted, got %q", got)
+	}
+	for _, runId := range []string{"run-2", "run-3"} {
+		if got := labels.label(runId); got != runId {
+			t.Errorf("expected %s to keep its label, got %q", runId, got)
+		}
+	}
+}
+
+func TestRunLabelsOverflowWhileAllRunsActive(t *testing.T) {
+	labels := newRunLabels(1)
+	labels.start("run-1")
+	labels.start("run-2")
+
+	if got := labels.label("run-2"); got != overflowRunLabel {
+		t.Errorf("expected the run beyond the limit to use the overflow label, got %q", got)
+	}
+	if got := labels.label("run-1"); got != "run-1" {
+		t.Errorf("expected the active run to keep its label, got %q", got)
+	}
+}
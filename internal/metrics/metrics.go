@@ -0,0 +1,465 @@
+package metrics
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Names of the HDR histograms kept for reports.
+const (
+	HistogramPipelineExec   = "pipeline_exec"
+	HistogramFlushBatchSize = "flush_batch_size"
+	HistogramSpanAck        = "span_ack"
+	HistogramTraceRead      = "trace_read"
+	HistogramCommandPrefix  = "command_"
+)
+
+// nativeBucketFactor lets prometheus keep exponential buckets growing by at most 10% each.
+const nativeBucketFactor = 1.1
+
+var (
+	spanLabels    = []string{"run_id", "db", "backend"}
+	storageLabels = []string{"db", "backend"}
+
+	spansGeneratedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "spans_generated_total",
+			Help:        "Total number of spans generated",
+			ConstLabels: podLabels,
+		},
+		spanLabels,
+	)
+	spansWrittenCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "spans_written_total",
+			Help:        "Total number of spans acknowledged by the store",
+			ConstLabels: podLabels,
+		},
+		spanLabels,
+	)
+	bytesWrittenCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "bytes_written_total",
+			Help:        "Total size of the span values acknowledged by the store",
+			ConstLabels: podLabels,
+		},
+		spanLabels,
+	)
+	spansFailedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "spans_failed_total",
+			Help:        "Total number of spans the store failed to write, by error class",
+			ConstLabels: podLabels,
+		},
+		[]string{"run_id", "db", "backend", "class"},
+	)
+	spansDroppedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "spans_dropped_total",
+			Help:        "Total number of spans dropped because the span queue was full or the store unavailable",
+			ConstLabels: podLabels,
+		},
+		[]string{"run_id", "db", "backend", "policy"},
+	)
+	spansEncodedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "spans_encoded_total",
+			Help:        "Total number of span values encoded, by codec",
+			ConstLabels: podLabels,
+		},
+		[]string{"codec"},
+	)
+	encodedBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "encoded_bytes_total",
+			Help:        "Total size of the encoded span values, by codec",
+			ConstLabels: podLabels,
+		},
+		[]string{"codec"},
+	)
+	encodeSecondsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "encode_seconds_total",
+			Help:        "Total time spent encoding span values, by codec",
+			ConstLabels: podLabels,
+		},
+		[]string{"codec"},
+	)
+	flushCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "flushes_total",
+			Help:        "Total number of pipeline flushes, by run with spans in the flush",
+			ConstLabels: podLabels,
+		},
+		spanLabels,
+	)
+	commandsSentCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "commands_sent_total",
+			Help:        "Total number of commands sent to write spans, by write mode",
+			ConstLabels: podLabels,
+		},
+		[]string{"db", "backend", "write_mode"},
+	)
+	expiryCommandsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "expiry_commands_total",
+			Help:        "Total number of expiry commands sent or skipped compared to expiring every key for every span, by ttl strategy",
+			ConstLabels: podLabels,
+		},
+		[]string{"db", "backend", "strategy", "result"},
+	)
+	keysExpiredCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "keys_expired_total",
+			Help:        "Total number of keys the server reported expired, by whether they expired before their intended ttl, unknown if it is not known",
+			ConstLabels: podLabels,
+		},
+		[]string{"db", "backend", "early"},
+	)
+	keysEvictedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "keys_evicted_total",
+			Help:        "Total number of keys the server reported evicted, by whether they were evicted before their intended ttl, unknown if it is not known",
+			ConstLabels: podLabels,
+		},
+		[]string{"db", "backend", "before_ttl"},
+	)
+	errorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "errors_total",
+			Help:        "Total number of failed commands by command type and error class",
+			ConstLabels: podLabels,
+		},
+		[]string{"run_id", "db", "backend", "command", "class"},
+	)
+	activeRunsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "active_runs",
+			Help:        "Number of runs generating spans",
+			ConstLabels: podLabels,
+		},
+		storageLabels,
+	)
+	queueDepthGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "queue_depth",
+			Help:        "Number of spans of a run waiting to be written",
+			ConstLabels: podLabels,
+		},
+		spanLabels,
+	)
+	queueBlockedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "queue_blocked_seconds_total",
+			Help:        "Total time the generator spent blocked on a full span queue",
+			ConstLabels: podLabels,
+		},
+		storageLabels,
+	)
+
+	pipelineExecHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "pipeline_exec_seconds",
+			Help:                        "Latency of pipeline executions",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(0.0001, 2, 18),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+		storageLabels,
+	)
+	flushBatchSizeHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "flush_batch_size",
+			Help:                        "Number of spans written per pipeline flush",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(1, 2, 16),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+		storageLabels,
+	)
+	expiryLagHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "expiry_lag_seconds",
+			Help:                        "Time from the intended expiry of a key until the server reported it expired",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(0.01, 2, 18),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+		storageLabels,
+	)
+	evictionTtlLeftHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "eviction_ttl_left_seconds",
+			Help:                        "Time left until the intended expiry of a key when the server evicted it",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(0.01, 2, 18),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+		storageLabels,
+	)
+	commandHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "command_seconds",
+			Help:                        "Latency of commands sent outside of a pipeline",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(0.0001, 2, 18),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+		[]string{"db", "backend", "command"},
+	)
+	spanAckHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "span_ack_latency_seconds",
+			Help:                        "Time from enqueueing a span until the store acknowledged its write",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(0.0001, 2, 20),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+		storageLabels,
+	)
+	traceReadHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "trace_read_seconds",
+			Help:                        "Latency of reading a whole trace back from the store",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(0.0001, 2, 18),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+		storageLabels,
+	)
+
+	// runVecs are the metrics with a run_id label, their series are deleted when a run label is evicted.
+	runVecs = []interface {
+		DeletePartialMatch(labels prometheus.Labels) int
+	}{spansGeneratedCounter, spansWrittenCounter, bytesWrittenCounter, spansFailedCounter, spansDroppedCounter, flushCounter, errorCounter, queueDepthGauge}
+)
+
+func init() {
+	prometheus.MustRegister(spansGeneratedCounter, spansWrittenCounter, bytesWrittenCounter, spansFailedCounter, spansDroppedCounter,
+		spansEncodedCounter, encodedBytesCounter, encodeSecondsCounter, flushCounter, commandsSentCounter, expiryCommandsCounter, keysExpiredCounter, keysEvictedCounter, errorCounter, activeRunsGauge, queueDepthGauge, queueBlockedCounter,
+		pipelineExecHistogram, flushBatchSizeHistogram, commandHistogram, spanAckHistogram, traceReadHistogram, expiryLagHistogram, evictionTtlLeftHistogram)
+}
+
+func deleteRunSeries(runId string) {
+	for _, vec := range runVecs {
+		vec.DeletePartialMatch(prometheus.Labels{"run_id": runId})
+	}
+}
+
+// Histograms holds the HDR histograms of the whole process.
+var Histograms = NewHdrRecorder()
+
+// RunStarted gives the run its own series, within the run label limit, and its own RunRecorder.
+func (t Target) RunStarted(runId string) *RunRecorder {
+	runs.start(runId)
+	activeRunsGauge.WithLabelValues(t.Db, t.Backend).Inc()
+
+	recorder := newRunRecorder()
+	runRecordersMutex.Lock()
+	runRecorders[runId] = recorder
+	runRecordersMutex.Unlock()
+	return recorder
+}
+
+// RunFinished stops the timeline of the run. Its recorder is kept until ForgetRun is called.
+func (t Target) RunFinished(runId string) {
+	runs.finish(runId)
+	activeRunsGauge.WithLabelValues(t.Db, t.Backend).Dec()
+	if recorder := Run(runId); recorder != nil {
+		recorder.stop()
+	}
+}
+
+// queueDepth is the last queue depth, for the run timelines.
+var queueDepth atomic.Int64
+
+// Target identifies the store and database the metrics are recorded for.
+type Target struct {
+	Db      string
+	Backend string
+}
+
+// QueueDepth records the number of spans of a run waiting in the span queue of the store, out of depth
+// spans of all runs.
+func (t Target) QueueDepth(runId string, runDepth int, depth int) {
+	queueDepthGauge.WithLabelValues(runs.label(runId), t.Db, t.Backend).Set(float64(runDepth))
+	queueDepth.Store(int64(depth))
+}
+
+// QueueBlocked records the time the generator waited for room in the span queue of the store.
+func (t Target) QueueBlocked(duration time.Duration) {
+	queueBlockedCounter.WithLabelValues(t.Db, t.Backend).Add(duration.Seconds())
+}
+
+func (t Target) SpanGenerated(runId string, traceId string) {
+	spansGeneratedCounter.WithLabelValues(runs.label(runId), t.Db, t.Backend).Inc()
+	if recorder := Run(runId); recorder != nil {
+		recorder.spanGenerated(traceId)
+	}
+}
+
+// SpanEncoded records the size of a span value and the time it took to encode it with a codec.
+func (t Target) SpanEncoded(runId string, traceId string, codec string, bytes int, duration time.Duration) {
+	spansEncodedCounter.WithLabelValues(codec).Inc()
+	encodedBytesCounter.WithLabelValues(codec).Add(float64(bytes))
+	encodeSecondsCounter.WithLabelValues(codec).Add(duration.Seconds())
+	if recorder := Run(runId); recorder != nil {
+		recorder.spanEncoded(traceId, codec, bytes, duration)
+	}
+}
+
+// SpanWritten records a span and the size of its value once the store acknowledged it.
+func (t Target) SpanWritten(runId string, traceId string, bytes int) {
+	runLabel := runs.label(runId)
+	spansWrittenCounter.WithLabelValues(runLabel, t.Db, t.Backend).Inc()
+	bytesWrittenCounter.WithLabelValues(runLabel, t.Db, t.Backend).Add(float64(bytes))
+	if recorder := Run(runId); recorder != nil {
+		recorder.spanWritten(traceId, bytes)
+	}
+}
+
+// SpanDropped records a span discarded before it reached the store, policy tells why.
+func (t Target) SpanDropped(runId string, traceId string, policy string) {
+	spansDroppedCounter.WithLabelValues(runs.label(runId), t.Db, t.Backend, policy).Inc()
+	if recorder := Run(runId); recorder != nil {
+		recorder.spanDropped(traceId)
+	}
+}
+
+// SpanFailed records a span the store was unable to write.
+func (t Target) SpanFailed(runId string, traceId string, class string) {
+	spansFailedCounter.WithLabelValues(runs.label(runId), t.Db, t.Backend, class).Inc()
+	if recorder := Run(runId); recorder != nil {
+		recorder.spanFailed(traceId, class)
+	}
+}
+
+// Flushed records the latency of a pipeline execution and the number of spans it wrote. The flush and
+// its latency are also recorded for each of the runs with spans in the batch.
+func (t Target) Flushed(latency time.Duration, batchSize int, runIds []string) {
+	if len(runIds) == 0 {
+		flushCounter.WithLabelValues("", t.Db, t.Backend).Inc()
+	}
+	for _, runId := range runIds {
+		flushCounter.WithLabelValues(runs.label(runId), t.Db, t.Backend).Inc()
+	}
+	pipelineExecHistogram.WithLabelValues(t.Db, t.Backend).Observe(latency.Seconds())
+	flushBatchSizeHistogram.WithLabelValues(t.Db, t.Backend).Observe(float64(batchSize))
+	Histograms.RecordLatency(HistogramPipelineExec, latency)
+	Histograms.RecordSize(HistogramFlushBatchSize, batchSize)
+	for _, runId := range runIds {
+		if recorder := Run(runId); recorder != nil {
+			recorder.histograms.RecordLatency(HistogramPipelineExec, latency)
+			recorder.histograms.RecordSize(HistogramFlushBatchSize, batchSize)
+		}
+	}
+}
+
+// CommandsSent counts the commands queued to write spans of a run in a write mode.
+func (t Target) CommandsSent(runId string, writeMode string, count int) {
+	commandsSentCounter.WithLabelValues(t.Db, t.Backend, writeMode).Add(float64(count))
+	if recorder := Run(runId); recorder != nil {
+		recorder.commandsSent(count)
+	}
+}
+
+// ExpiryQueued counts an expiry command a ttl strategy sent for a key of a span of a run, or skipped if
+// sent is false.
+func (t Target) ExpiryQueued(runId string, strategy string, sent bool) {
+	result := "sent"
+	if !sent {
+		result = "skipped"
+	}
+	expiryCommandsCounter.WithLabelValues(t.Db, t.Backend, strategy, result).Inc()
+	if recorder := Run(runId); recorder != nil {
+		recorder.expiryQueued(sent)
+	}
+}
+
+// KeyExpired records a key the server reported expired, lag after its intended expiry. A negative lag
+// is an expiry before the intended ttl.
+func (t Target) KeyExpired(lag time.Duration, early bool) {
+	keysExpiredCounter.WithLabelValues(t.Db, t.Backend, strconv.FormatBool(early)).Inc()
+	if lag >= 0 {
+		expiryLagHistogram.WithLabelValues(t.Db, t.Backend).Observe(lag.Seconds())
+	}
+}
+
+// KeyEvicted records a key the server reported evicted with ttlLeft until its intended expiry.
+func (t Target) KeyEvicted(ttlLeft time.Duration, beforeTtl bool) {
+	keysEvictedCounter.WithLabelValues(t.Db, t.Backend, strconv.FormatBool(beforeTtl)).Inc()
+	if beforeTtl {
+		evictionTtlLeftHistogram.WithLabelValues(t.Db, t.Backend).Observe(ttlLeft.Seconds())
+	}
+}
+
+// KeyUntracked records a key the server reported expired, or evicted, without a known intended expiry.
+func (t Target) KeyUntracked(evicted bool) {
+	if evicted {
+		keysEvictedCounter.WithLabelValues(t.Db, t.Backend, "unknown").Inc()
+		return
+	}
+	keysExpiredCounter.WithLabelValues(t.Db, t.Backend, "unknown").Inc()
+}
+
+// CommandFailed counts a failed command of a run by its type and error class. The run id is empty
+// for the commands sent outside of a run.
+func (t Target) CommandFailed(runId string, command string, class string) {
+	runLabel := runId
+	if runId != "" {
+		runLabel = runs.label(runId)
+	}
+	errorCounter.WithLabelValues(runLabel, t.Db, t.Backend, command, class).Inc()
+}
+
+// CommandExecuted records the latency of a single command sent outside of a pipeline.
+func (t Target) CommandExecuted(command string, latency time.Duration) {
+	commandHistogram.WithLabelValues(t.Db, t.Backend, command).Observe(latency.Seconds())
+	Histograms.RecordLatency(HistogramCommandPrefix+command, latency)
+}
+
+// SpanAcknowledged records the time from enqueueing a span until its write was acknowledged.
+func (t Target) SpanAcknowledged(runId string, latency time.Duration) {
+	spanAckHistogram.WithLabelValues(t.Db, t.Backend).Observe(latency.Seconds())
+	Histograms.RecordLatency(HistogramSpanAck, latency)
+	if recorder := Run(runId); recorder != nil {
+		recorder.spanAcknowledged(latency)
+	}
+}
+
+// TraceRead records the latency of reading a trace of a run back from the store.
+func (t Target) TraceRead(runId string, latency time.Duration) {
+	traceReadHistogram.WithLabelValues(t.Db, t.Backend).Observe(latency.Seconds())
+	Histograms.RecordLatency(HistogramTraceRead, latency)
+	if recorder := Run(runId); recorder != nil {
+		recorder.histograms.RecordLatency(HistogramTraceRead, latency)
+	}
+}
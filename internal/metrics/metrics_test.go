@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCommandFailedLabelsTheRun(t *testing.T) {
+	target := Target{Db: "traces", Backend: "command-failed-test"}
+	target.RunStarted("command-failed")
+	target.CommandFailed("command-failed", "hset", "timeout")
+	target.CommandFailed("", "ping", "timeout")
+
+	if got := testutil.ToFloat64(errorCounter.WithLabelValues("command-failed", target.Db, target.Backend, "hset", "timeout")); got != 1 {
+		t.Errorf("expected the failed command to be counted for its run, got %v", got)
+	}
+	if got := testutil.ToFloat64(errorCounter.WithLabelValues("", target.Db, target.Backend, "ping", "timeout")); got != 1 {
+		t.Errorf("expected the command sent outside of a run to have an empty run id, got %v", got)
+	}
+
+	target.RunFinished("command-failed")
+	ForgetRun("command-failed")
+	deleteRunSeries("command-failed")
+	if got := testutil.CollectAndCount(errorCounter, namespace+"_errors_total"); got != 1 {
+		t.Errorf("expected the series of the run to be deleted with it, %d series left", got)
+	}
+}
+
+func TestQueueDepthIsLabelledByTarget(t *testing.T) {
+	traces := Target{Db: "traces", Backend: "queue-depth-test"}
+	other := Target{Db: "traces", Backend: "queue-depth-other"}
+	traces.RunStarted("queue-depth")
+	defer ForgetRun("queue-depth")
+	traces.QueueDepth("queue-depth", 3, 4)
+	other.QueueDepth("queue-depth", 5, 5)
+
+	if got := testutil.ToFloat64(queueDepthGauge.WithLabelValues("queue-depth", traces.Db, traces.Backend)); got != 3 {
+		t.Errorf("expected the depth of the run in the queue of the target, got %v", got)
+	}
+	if got := testutil.ToFloat64(queueDepthGauge.WithLabelValues("queue-depth", other.Db, other.Backend)); got != 5 {
+		t.Errorf("expected the depth of the other queue apart, got %v", got)
+	}
+	if got := queueDepth.Load(); got != 5 {
+		t.Errorf("expected the timelines to see the depth of the last queue, got %v", got)
+	}
+	traces.RunFinished("queue-depth")
+}
+
+func TestFailedAndDroppedSpansSettleTheirTrace(t *testing.T) {
+	target := Target{Db: "traces", Backend: "settle-test"}
+	recorder := target.RunStarted("settle")
+	defer ForgetRun("settle")
+	for _, traceId := range []string{"written", "failed", "dropped"} {
+		target.SpanGenerated("settle", traceId)
+		target.SpanGenerated("settle", traceId)
+	}
+	target.SpanWritten("settle", "written", 10)
+	target.SpanWritten("settle", "written", 10)
+	target.SpanWritten("settle", "failed", 10)
+	target.SpanFailed("settle", "failed", "timeout")
+	target.SpanDropped("settle", "dropped", "drop_newest")
+	target.SpanFailed("settle", "dropped", "unavailable")
+
+	recorder.mutex.Lock()
+	pending := len(recorder.pendingTraces)
+	recorder.mutex.Unlock()
+	if pending != 0 {
+		t.Errorf("expected the traces to be forgotten once all their spans settled, %d left", pending)
+	}
+	if counts := recorder.Counts(); counts.TracesWritten != 1 || counts.SpansFailed != 2 || counts.SpansDropped != 1 {
+		t.Errorf("expected only the trace with all its spans written to count, got %+v", counts)
+	}
+	if samples := recorder.SampledTraces(); len(samples) != 1 || samples[0].TraceId != "written" {
+		t.Errorf("expected only the written trace to be sampled, got %+v", samples)
+	}
+	target.RunFinished("settle")
+}
@@ -0,0 +1,389 @@
+package metrics
+
+import (
+	"math/rand"
+	"redis-test/internal/redisstats"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	timelineInterval = time.Second
+	// traceSampleSize is the number of written traces sampled per run for the memory analysis.
+	traceSampleSize = 100
+)
+
+type pendingTrace struct {
+	spans   int
+	written int
+	// lost counts the spans of the trace that failed or were dropped
+	lost     int
+	rawBytes int64
+	codec    string
+}
+
+// TimelinePoint holds what a run did during one interval of its timeline.
+type TimelinePoint struct {
+	OffsetSeconds  float64 `json:"offsetSeconds"`
+	SpansGenerated int64   `json:"spansGenerated"`
+	SpansWritten   int64   `json:"spansWritten"`
+	BytesWritten   int64   `json:"bytesWritten"`
+	SpansFailed    int64   `json:"spansFailed"`
+	SpansDropped   int64   `json:"spansDropped"`
+	// AckLatencyP50Us and AckLatencyP99Us are the span ack latencies of the spans acknowledged in the interval.
+	AckLatencyP50Us int64 `json:"ackLatencyP50Us"`
+	AckLatencyP99Us int64 `json:"ackLatencyP99Us"`
+	// QueueDepth is the depth of the span queue at the end of the interval.
+	QueueDepth int64 `json:"queueDepth"`
+	// UsedMemoryBytes is the memory used by the server at the end of the interval, if known.
+	UsedMemoryBytes *int64 `json:"usedMemoryBytes,omitempty"`
+}
+
+// RunCounts are the totals of a run.
+type RunCounts struct {
+	TracesGenerated int64 `json:"tracesGenerated"`
+	TracesWritten   int64 `json:"tracesWritten"`
+	SpansGenerated  int64 `json:"spansGenerated"`
+	SpansWritten    int64 `json:"spansWritten"`
+	BytesWritten    int64 `json:"bytesWritten"`
+	SpansFailed     int64 `json:"spansFailed"`
+	SpansDropped    int64 `json:"spansDropped"`
+	// Commands is the number of redis commands sent to write the spans of the run, retries included.
+	Commands int64 `json:"commands"`
+	// ExpiryCommands is the number of those commands that expired keys, ExpirySkipped the number of
+	// expiry commands the ttl strategy saved compared to expiring every key for every span.
+	ExpiryCommands int64 `json:"expiryCommands"`
+	ExpirySkipped  int64 `json:"expirySkipped"`
+}
+
+// Pending is the number of generated spans whose write has not been acknowledged, failed or dropped yet.
+func (c RunCounts) Pending() int64 {
+	return c.SpansGenerated - c.SpansWritten - c.SpansFailed - c.SpansDropped
+}
+
+func (c RunCounts) minus(other RunCounts) RunCounts {
+	return RunCounts{
+		TracesGenerated: c.TracesGenerated - other.TracesGenerated,
+		TracesWritten:   c.TracesWritten - other.TracesWritten,
+		SpansGenerated:  c.SpansGenerated - other.SpansGenerated,
+		SpansWritten:    c.SpansWritten - other.SpansWritten,
+		BytesWritten:    c.BytesWritten - other.BytesWritten,
+		SpansFailed:     c.SpansFailed - other.SpansFailed,
+		SpansDropped:    c.SpansDropped - other.SpansDropped,
+		Commands:        c.Commands - other.Commands,
+		ExpiryCommands:  c.ExpiryCommands - other.ExpiryCommands,
+		ExpirySkipped:   c.ExpirySkipped - other.ExpirySkipped,
+	}
+}
+
+// CodecCounts are the span values a run encoded with a codec.
+type CodecCounts struct {
+	Spans       int64 `json:"spans"`
+	Bytes       int64 `json:"bytes"`
+	EncodeNanos int64 `json:"encodeNanos"`
+}
+
+// RunSnapshot is everything recorded for a run so far.
+type RunSnapshot struct {
+	Counts     RunCounts                    `json:"counts"`
+	Codecs     map[string]CodecCounts       `json:"codecs"`
+	Errors     map[string]int64             `json:"errors"`
+	Histograms map[string]HistogramSnapshot `json:"histograms"`
+	Timeline   []TimelinePoint              `json:"timeline"`
+}
+
+// RunRecorder keeps the counters, error classes, latency histograms and throughput timeline of one run.
+type RunRecorder struct {
+	mutex         sync.Mutex
+	counts        RunCounts
+	pendingTraces map[string]*pendingTrace
+	// traceSample is a uniform sample of the written traces, kept by reservoir sampling.
+	traceSample []redisstats.TraceSample
+	random      *rand.Rand
+	codecs      map[string]*CodecCounts
+	errors      map[string]int64
+	histograms  *HdrRecorder
+	// intervalAck holds the ack latencies of the current timeline interval.
+	intervalAck *hdrhistogram.Histogram
+	memoryProbe func() (int64, bool)
+
+	start        time.Time
+	timeline     []TimelinePoint
+	lastSample   RunCounts
+	stopSampling chan struct{}
+	sampled      chan struct{}
+	stopOnce     sync.Once
+}
+
+func newRunRecorder() *RunRecorder {
+	recorder := &RunRecorder{
+		pendingTraces: make(map[string]*pendingTrace),
+		random:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		codecs:        make(map[string]*CodecCounts),
+		errors:        make(map[string]int64),
+		histograms:    NewHdrRecorder(),
+		intervalAck:   hdrhistogram.New(0, maxLatencyMicros, significantDigits),
+		start:         time.Now(),
+		stopSampling:  make(chan struct{}),
+		sampled:       make(chan struct{}),
+	}
+	go recorder.sample()
+	return recorder
+}
+
+func (r *RunRecorder) sample() {
+	defer close(r.sampled)
+	ticker := time.NewTicker(timelineInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopSampling:
+			r.addTimelinePoint()
+			return
+		case <-ticker.C:
+			r.addTimelinePoint()
+		}
+	}
+}
+
+// SetMemoryProbe sets how the memory used by the server is read for the timeline.
+func (r *RunRecorder) SetMemoryProbe(probe func() (int64, bool)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.memoryProbe = probe
+}
+
+func (r *RunRecorder) addTimelinePoint() {
+	r.mutex.Lock()
+	probe := r.memoryProbe
+	r.mutex.Unlock()
+
+	point := TimelinePoint{QueueDepth: queueDepth.Load()}
+	if probe != nil {
+		if usedMemory, ok := probe(); ok {
+			point.UsedMemoryBytes = &usedMemory
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delta := r.counts.minus(r.lastSample)
+	r.lastSample = r.counts
+	point.OffsetSeconds = time.Since(r.start).Seconds()
+	point.SpansGenerated = delta.SpansGenerated
+	point.SpansWritten = delta.SpansWritten
+	point.BytesWritten = delta.BytesWritten
+	point.SpansFailed = delta.SpansFailed
+	point.SpansDropped = delta.SpansDropped
+	point.AckLatencyP50Us = r.intervalAck.ValueAtQuantile(50)
+	point.AckLatencyP99Us = r.intervalAck.ValueAtQuantile(99)
+	r.intervalAck.Reset()
+	r.timeline = append(r.timeline, point)
+}
+
+// stop ends the timeline with a last, possibly shorter, interval.
+func (r *RunRecorder) stop() {
+	r.stopOnce.Do(func() { close(r.stopSampling) })
+	<-r.sampled
+}
+
+func (r *RunRecorder) spanGenerated(traceId string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	trace, ok := r.pendingTraces[traceId]
+	if !ok {
+		trace = &pendingTrace{}
+		r.pendingTraces[traceId] = trace
+		r.counts.TracesGenerated++
+	}
+	trace.spans++
+	r.counts.SpansGenerated++
+}
+
+func (r *RunRecorder) spanEncoded(traceId string, codec string, bytes int, duration time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	counts, ok := r.codecs[codec]
+	if !ok {
+		counts = &CodecCounts{}
+		r.codecs[codec] = counts
+	}
+	counts.Spans++
+	counts.Bytes += int64(bytes)
+	counts.EncodeNanos += duration.Nanoseconds()
+	if trace, ok := r.pendingTraces[traceId]; ok {
+		trace.codec = codec
+	}
+}
+
+func (r *RunRecorder) spanWritten(traceId string, bytes int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.counts.SpansWritten++
+	r.counts.BytesWritten += int64(bytes)
+	trace, ok := r.pendingTraces[traceId]
+	if !ok {
+		return
+	}
+	trace.written++
+	trace.rawBytes += int64(bytes)
+	r.settle(traceId, trace)
+}
+
+// spanLost counts a failed or dropped span against its trace, under mutex.
+func (r *RunRecorder) spanLost(traceId string) {
+	if trace, ok := r.pendingTraces[traceId]; ok {
+		trace.lost++
+		r.settle(traceId, trace)
+	}
+}
+
+// settle forgets a trace once all its spans were written, failed or dropped, under mutex. Only a trace
+// with all its spans written counts as written.
+func (r *RunRecorder) settle(traceId string, trace *pendingTrace) {
+	if trace.written+trace.lost < trace.spans {
+		return
+	}
+	delete(r.pendingTraces, traceId)
+	if trace.lost > 0 {
+		return
+	}
+	r.counts.TracesWritten++
+	r.sampleTrace(redisstats.TraceSample{TraceId: traceId, Codec: trace.codec, Spans: trace.spans, RawBytes: trace.rawBytes})
+}
+
+// sampleTrace keeps a trace with a probability of traceSampleSize / traces written, under mutex.
+func (r *RunRecorder) sampleTrace(sample redisstats.TraceSample) {
+	if len(r.traceSample) < traceSampleSize {
+		r.traceSample = append(r.traceSample, sample)
+		return
+	}
+	if i := r.random.Int63n(r.counts.TracesWritten); i < traceSampleSize {
+		r.traceSample[i] = sample
+	}
+}
+
+// SampledTraces returns a uniform sample of the written traces.
+func (r *RunRecorder) SampledTraces() []redisstats.TraceSample {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]redisstats.TraceSample(nil), r.traceSample...)
+}
+
+func (r *RunRecorder) spanAcknowledged(latency time.Duration) {
+	r.histograms.RecordLatency(HistogramSpanAck, latency)
+
+	micros := latency.Microseconds()
+	if micros > maxLatencyMicros {
+		micros = maxLatencyMicros
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_ = r.intervalAck.RecordValue(micros)
+}
+
+func (r *RunRecorder) spanFailed(traceId string, class string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.counts.SpansFailed++
+	r.errors[class]++
+	r.spanLost(traceId)
+}
+
+func (r *RunRecorder) commandsSent(count int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.counts.Commands += int64(count)
+}
+
+func (r *RunRecorder) expiryQueued(sent bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if sent {
+		r.counts.ExpiryCommands++
+	} else {
+		r.counts.ExpirySkipped++
+	}
+}
+
+func (r *RunRecorder) spanDropped(traceId string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.counts.SpansDropped++
+	r.spanLost(traceId)
+}
+
+// Start returns when the run started.
+func (r *RunRecorder) Start() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.start
+}
+
+// Restart starts the timeline of the run again from now and drops the points recorded so far, e.g.
+// once the prefill of the run is written.
+func (r *RunRecorder) Restart() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.start = time.Now()
+	r.timeline = nil
+	r.lastSample = r.counts
+	r.intervalAck.Reset()
+	return r.start
+}
+
+// Counts returns the totals recorded so far.
+func (r *RunRecorder) Counts() RunCounts {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.counts
+}
+
+// Snapshot copies everything recorded so far.
+func (r *RunRecorder) Snapshot() RunSnapshot {
+	histograms := r.histograms.Snapshot()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	codecs := make(map[string]CodecCounts, len(r.codecs))
+	for codec, counts := range r.codecs {
+		codecs[codec] = *counts
+	}
+	errors := make(map[string]int64, len(r.errors))
+	for class, count := range r.errors {
+		errors[class] = count
+	}
+	return RunSnapshot{
+		Counts:     r.counts,
+		Codecs:     codecs,
+		Errors:     errors,
+		Histograms: histograms,
+		Timeline:   append([]TimelinePoint(nil), r.timeline...),
+	}
+}
+
+var (
+	runRecordersMutex sync.RWMutex
+	runRecorders      = make(map[string]*RunRecorder)
+)
+
+// Run returns the recorder of a run, or nil if the run is unknown or forgotten.
+func Run(runId string) *RunRecorder {
+	runRecordersMutex.RLock()
+	defer runRecordersMutex.RUnlock()
+	return runRecorders[runId]
+}
+
+// ForgetRun drops the recorder of a finished run.
+func ForgetRun(runId string) {
+	runRecordersMutex.Lock()
+	defer runRecordersMutex.Unlock()
+	if recorder, ok := runRecorders[runId]; ok {
+		recorder.stop()
+		delete(runRecorders, runId)
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"redis-test/internal/redisstats"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	redisServerGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "redis_server",
+			Help:        "Fields of the INFO output of the target redis, sampled during runs",
+			ConstLabels: podLabels,
+		},
+		[]string{"field"},
+	)
+	redisLatencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "redis_latency_latest_ms",
+			Help:        "Latest latency spike per event reported by LATENCY LATEST",
+			ConstLabels: podLabels,
+		},
+		[]string{"event"},
+	)
+	redisSlowLogCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "redis_slowlog_entries_total",
+			Help:        "Total number of commands in the SLOWLOG of the target redis",
+			ConstLabels: podLabels,
+		},
+		[]string{"command"},
+	)
+	redisSlowLogHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "redis_slowlog_duration_seconds",
+			Help:                        "Execution time of the commands in the SLOWLOG of the target redis",
+			ConstLabels:                 podLabels,
+			Buckets:                     prometheus.ExponentialBuckets(0.001, 2, 14),
+			NativeHistogramBucketFactor: nativeBucketFactor,
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(redisServerGauge, redisLatencyGauge, redisSlowLogCounter, redisSlowLogHistogram)
+}
+
+// ServerObserver exports the samples of a redisstats.Collector as prometheus metrics.
+type ServerObserver struct{}
+
+var _ redisstats.Observer = ServerObserver{}
+
+func (ServerObserver) Sampled(sample redisstats.Sample) {
+	for field, value := range sample.Fields {
+		redisServerGauge.WithLabelValues(field).Set(value)
+	}
+	for _, event := range sample.Latency {
+		redisLatencyGauge.WithLabelValues(event.Event).Set(float64(event.LatestMS))
+	}
+}
+
+func (ServerObserver) SlowLogged(entry redisstats.SlowLogEntry) {
+	command, _, _ := strings.Cut(entry.Command, " ")
+	redisSlowLogCounter.WithLabelValues(strings.ToLower(command)).Inc()
+	redisSlowLogHistogram.Observe((time.Duration(entry.DurationUs) * time.Microsecond).Seconds())
+}
@@ -0,0 +1,251 @@
+package redisstats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	zkLogger "github.com/zerok-ai/zk-utils-go/logs"
+)
+
+var collectorLogTag = "RedisStatsCollector"
+
+const (
+	// maxSamples bounds the samples kept in memory, an hour at the default interval.
+	maxSamples       = 3600
+	maxSlowLogs      = 1024
+	slowLogFetchSize = 128
+)
+
+// SampledFields are the INFO fields kept in the samples, next to the keys and expires of each db.
+var SampledFields = []string{
+	"instantaneous_ops_per_sec", "total_commands_processed", "used_memory", "used_memory_rss",
+	"mem_fragmentation_ratio", "evicted_keys", "expired_keys", "keyspace_hits", "keyspace_misses",
+	"connected_clients", "blocked_clients", "used_cpu_sys", "used_cpu_user",
+}
+
+// SlowLogEntry is a command logged by SLOWLOG.
+type SlowLogEntry struct {
+	Id         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	DurationUs int64     `json:"durationUs"`
+	Command    string    `json:"command"`
+	ClientAddr string    `json:"clientAddr,omitempty"`
+}
+
+// LatencyEvent is a line of LATENCY LATEST.
+type LatencyEvent struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	LatestMS int64     `json:"latestMS"`
+	MaxMS    int64     `json:"maxMS"`
+}
+
+// Source is a redis server the collector samples.
+type Source interface {
+	ServerInfo() (Info, error)
+	SlowLog(count int64) ([]SlowLogEntry, error)
+	LatencyLatest() ([]LatencyEvent, error)
+}
+
+// Sample is the state of the server at a point in time.
+type Sample struct {
+	Time    time.Time          `json:"time"`
+	Fields  map[string]float64 `json:"fields"`
+	Latency []LatencyEvent     `json:"latency,omitempty"`
+}
+
+// Window is what the collector observed between two points in time.
+type Window struct {
+	Samples []Sample       `json:"samples"`
+	SlowLog []SlowLogEntry `json:"slowLog"`
+	// Latency holds the latest spike of each event that happened in the window.
+	Latency []LatencyEvent `json:"latency"`
+}
+
+// Observer is told about every sample and new slow log entry, e.g. to export them as metrics.
+type Observer interface {
+	Sampled(sample Sample)
+	SlowLogged(entry SlowLogEntry)
+}
+
+// Collector samples INFO, SLOWLOG GET and LATENCY LATEST of a server at a fixed interval. Commands
+// the server does not support are no longer sent after their first failure.
+type Collector struct {
+	source   Source
+	observer Observer
+	interval time.Duration
+
+	mutex         sync.Mutex
+	samples       []Sample
+	slowLog       []SlowLogEntry
+	lastSlowLogId int64
+	noSlowLog     bool
+	noLatency     bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewCollector(source Source, observer Observer, interval time.Duration) *Collector {
+	return &Collector{
+		source:        source,
+		observer:      observer,
+		interval:      interval,
+		lastSlowLogId: -1,
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+}
+
+func (c *Collector) Start() {
+	go func() {
+		defer close(c.stopped)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		c.Collect()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.Collect()
+			}
+		}
+	}()
+}
+
+func (c *Collector) Stop() {
+	close(c.stop)
+	<-c.stopped
+}
+
+// Collect takes a sample now.
+func (c *Collector) Collect() {
+	info, err := c.source.ServerInfo()
+	if err != nil {
+		zkLogger.Debug(collectorLogTag, "unable to read INFO ", err)
+		return
+	}
+	sample := Sample{Time: time.Now(), Fields: sampledFields(info)}
+
+	c.mutex.Lock()
+	noSlowLog, noLatency := c.noSlowLog, c.noLatency
+	c.mutex.Unlock()
+
+	var slowLog []SlowLogEntry
+	if !noSlowLog {
+		slowLog, err = c.source.SlowLog(slowLogFetchSize)
+		if err != nil {
+			c.disableIfUnsupported("SLOWLOG", err, &c.noSlowLog)
+		}
+	}
+	if !noLatency {
+		sample.Latency, err = c.source.LatencyLatest()
+		if err != nil {
+			c.disableIfUnsupported("LATENCY", err, &c.noLatency)
+		}
+	}
+
+	c.mutex.Lock()
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > maxSamples {
+		c.samples = c.samples[len(c.samples)-maxSamples:]
+	}
+	newEntries := c.addSlowLog(slowLog)
+	c.mutex.Unlock()
+
+	if c.observer != nil {
+		c.observer.Sampled(sample)
+		for _, entry := range newEntries {
+			c.observer.SlowLogged(entry)
+		}
+	}
+}
+
+// addSlowLog keeps the entries not seen before. SLOWLOG GET returns the newest entries first.
+// The caller must hold mutex.
+func (c *Collector) addSlowLog(entries []SlowLogEntry) []SlowLogEntry {
+	var newEntries []SlowLogEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Id > c.lastSlowLogId {
+			newEntries = append(newEntries, entries[i])
+			c.lastSlowLogId = entries[i].Id
+		}
+	}
+	c.slowLog = append(c.slowLog, newEntries...)
+	if len(c.slowLog) > maxSlowLogs {
+		c.slowLog = c.slowLog[len(c.slowLog)-maxSlowLogs:]
+	}
+	return newEntries
+}
+
+func (c *Collector) disableIfUnsupported(command string, err error, disabled *bool) {
+	message := strings.ToLower(err.Error())
+	if !strings.Contains(message, "unknown command") && !strings.Contains(message, "unknown subcommand") {
+		zkLogger.Debug(collectorLogTag, "unable to read ", command, " ", err)
+		return
+	}
+	zkLogger.Info(collectorLogTag, command, " is not supported by the server, it is no longer sampled")
+	c.mutex.Lock()
+	*disabled = true
+	c.mutex.Unlock()
+}
+
+// Latest returns the last sample, if any.
+func (c *Collector) Latest() (Sample, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.samples) == 0 {
+		return Sample{}, false
+	}
+	return c.samples[len(c.samples)-1], true
+}
+
+// Window returns the samples, slow log entries and latency spikes between from and to.
+func (c *Collector) Window(from, to time.Time) Window {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	window := Window{Samples: []Sample{}, SlowLog: []SlowLogEntry{}, Latency: []LatencyEvent{}}
+	latest := make(map[string]LatencyEvent)
+	for _, sample := range c.samples {
+		if sample.Time.Before(from) || sample.Time.After(to) {
+			continue
+		}
+		window.Samples = append(window.Samples, sample)
+		for _, event := range sample.Latency {
+			if !event.Time.Before(from) && !event.Time.After(to) {
+				latest[event.Event] = event
+			}
+		}
+	}
+	for _, entry := range c.slowLog {
+		if !entry.Time.Before(from.Truncate(time.Second)) && !entry.Time.After(to) {
+			window.SlowLog = append(window.SlowLog, entry)
+		}
+	}
+	for _, event := range latest {
+		window.Latency = append(window.Latency, event)
+	}
+	sort.Slice(window.Latency, func(i, j int) bool { return window.Latency[i].Event < window.Latency[j].Event })
+	return window
+}
+
+func sampledFields(info Info) map[string]float64 {
+	fields := make(map[string]float64)
+	for _, field := range SampledFields {
+		if value, ok := info.Float(field); ok {
+			fields[field] = value
+		}
+	}
+	for key := range info {
+		if strings.HasPrefix(key, "db") && (strings.HasSuffix(key, ".keys") || strings.HasSuffix(key, ".expires")) {
+			if value, ok := info.Float(key); ok {
+				fields[key] = value
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,75 @@
+package redisstats
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	usedMemory string
+	slowLog    []SlowLogEntry
+	slowLogErr error
+	slowLogs   int
+}
+
+func (s *fakeSource) ServerInfo() (Info, error) {
+	return ParseInfo("used_memory:" + s.usedMemory + "\r\ndb0:keys=5,expires=5\r\nrole:master\r\n"), nil
+}
+
+func (s *fakeSource) SlowLog(int64) ([]SlowLogEntry, error) {
+	s.slowLogs++
+	return s.slowLog, s.slowLogErr
+}
+
+func (s *fakeSource) LatencyLatest() ([]LatencyEvent, error) {
+	return []LatencyEvent{{Event: "command", Time: time.Now(), LatestMS: 12, MaxMS: 40}}, nil
+}
+
+type countingObserver struct {
+	samples, slowLogged int
+}
+
+func (o *countingObserver) Sampled(Sample)          { o.samples++ }
+func (o *countingObserver) SlowLogged(SlowLogEntry) { o.slowLogged++ }
+
+func TestCollectorKeepsNewSlowLogEntriesOnce(t *testing.T) {
+	now := time.Now()
+	source := &fakeSource{usedMemory: "100", slowLog: []SlowLogEntry{{Id: 2, Time: now}, {Id: 1, Time: now}}}
+	observer := &countingObserver{}
+	collector := NewCollector(source, observer, time.Second)
+
+	collector.Collect()
+	source.slowLog = []SlowLogEntry{{Id: 3, Time: now}, {Id: 2, Time: now}}
+	source.usedMemory = "150"
+	collector.Collect()
+
+	if observer.samples != 2 || observer.slowLogged != 3 {
+		t.Errorf("expected 2 samples and 3 slow log entries, got %+v", observer)
+	}
+	window := collector.Window(now.Add(-time.Second), time.Now())
+	if len(window.Samples) != 2 || len(window.SlowLog) != 3 || len(window.Latency) != 1 {
+		t.Errorf("unexpected window %+v", window)
+	}
+	latest, _ := collector.Latest()
+	if latest.Fields["used_memory"] != 150 || latest.Fields["db0.keys"] != 5 {
+		t.Errorf("unexpected fields %v", latest.Fields)
+	}
+	if _, ok := latest.Fields["role"]; ok {
+		t.Errorf("only the sampled fields must be kept")
+	}
+}
+
+func TestCollectorStopsSendingUnsupportedCommands(t *testing.T) {
+	source := &fakeSource{usedMemory: "100", slowLogErr: errors.New("ERR unknown command 'slowlog'")}
+	collector := NewCollector(source, nil, time.Second)
+
+	collector.Collect()
+	collector.Collect()
+	if source.slowLogs != 1 {
+		t.Errorf("expected SLOWLOG to be sent once, was sent %d times", source.slowLogs)
+	}
+	if _, ok := collector.Latest(); !ok {
+		t.Errorf("expected INFO to be sampled without SLOWLOG")
+	}
+}
@@ -0,0 +1,96 @@
+package redisstats
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// memoryUnits are the units of the memory sizes of the redis config, e.g. maxmemory 10000mb.
+var memoryUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses a memory size the way the redis config does: a number of bytes with an optional
+// unit, k, m and g for powers of 1000 and kb, mb and gb for powers of 1024.
+func ParseMemory(size string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSuffix(value, unit.suffix), unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", size)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// Info is the output of the redis INFO command, keyed by field name.
+type Info map[string]string
+
+// ParseInfo parses the output of INFO. Section headers and blank lines are skipped, keyspace lines
+// like "db0:keys=1,expires=0" are split into "db0.keys" and "db0.expires".
+func ParseInfo(text string) Info {
+	info := make(Info)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(key, "db") && strings.Contains(value, "=") {
+			for _, pair := range strings.Split(value, ",") {
+				if subKey, subValue, ok := strings.Cut(pair, "="); ok {
+					info[key+"."+subKey] = subValue
+				}
+			}
+			continue
+		}
+		info[key] = value
+	}
+	return info
+}
+
+// Float returns a numeric field of the info.
+func (info Info) Float(key string) (float64, bool) {
+	value, ok := info[key]
+	if !ok {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return number, true
+}
+
+// Delta returns after - before for every numeric field present in both.
+func Delta(before, after Info) map[string]float64 {
+	delta := make(map[string]float64)
+	for key := range after {
+		afterValue, ok := after.Float(key)
+		if !ok {
+			continue
+		}
+		beforeValue, ok := before.Float(key)
+		if !ok {
+			continue
+		}
+		delta[key] = afterValue - beforeValue
+	}
+	return delta
+}
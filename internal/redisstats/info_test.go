@@ -0,0 +1,39 @@
+package redisstats
+
+import "testing"
+
+const infoBefore = "# Memory\r\nused_memory:1000\r\nmaxmemory_policy:noeviction\r\n\r\n# Keyspace\r\ndb3:keys=10,expires=10,avg_ttl=0\r\n"
+const infoAfter = "# Memory\r\nused_memory:1500\r\nmaxmemory_policy:noeviction\r\n\r\n# Keyspace\r\ndb3:keys=25,expires=25,avg_ttl=0\r\n"
+
+func TestParseInfo(t *testing.T) {
+	info := ParseInfo(infoBefore)
+	if info["used_memory"] != "1000" || info["maxmemory_policy"] != "noeviction" {
+		t.Errorf("unexpected fields %v", info)
+	}
+	if info["db3.keys"] != "10" || info["db3.expires"] != "10" {
+		t.Errorf("expected the keyspace line to be split, got %v", info)
+	}
+}
+
+func TestDeltaOfNumericFields(t *testing.T) {
+	delta := Delta(ParseInfo(infoBefore), ParseInfo(infoAfter))
+	if delta["used_memory"] != 500 || delta["db3.keys"] != 15 {
+		t.Errorf("unexpected delta %v", delta)
+	}
+	if _, ok := delta["maxmemory_policy"]; ok {
+		t.Errorf("non numeric fields must not be in the delta")
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	for size, expected := range map[string]int64{"1024": 1024, "10000mb": 10000 << 20, "2GB": 2 << 30, "1.5k": 1500, "3m": 3000000, "512b": 512} {
+		if bytes, err := ParseMemory(size); err != nil || bytes != expected {
+			t.Errorf("%s: expected %d bytes, got %d %v", size, expected, bytes, err)
+		}
+	}
+	for _, size := range []string{"", "mb", "ten", "-1gb"} {
+		if _, err := ParseMemory(size); err == nil {
+			t.Errorf("%q: expected an error", size)
+		}
+	}
+}
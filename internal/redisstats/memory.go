@@ -0,0 +1,148 @@
+package redisstats
+
+import "sort"
+
+// UnknownEncoding is used when the server does not report the encoding of a key.
+const UnknownEncoding = "unknown"
+
+// TraceMemory is the memory a trace takes in the server.
+type TraceMemory struct {
+	Found bool
+	Bytes int64
+	// Encoding is the OBJECT ENCODING of the trace, e.g. listpack or hashtable for a hash.
+	Encoding string
+}
+
+// TraceMemoryProbe is implemented by the stores that can tell how much memory a trace takes.
+type TraceMemoryProbe interface {
+	TraceMemory(traceId string) (TraceMemory, error)
+}
+
+// TraceSample is a written trace with the codec, number and raw size of its spans.
+type TraceSample struct {
+	TraceId  string `json:"traceId"`
+	Codec    string `json:"codec,omitempty"`
+	Spans    int    `json:"spans"`
+	RawBytes int64  `json:"rawBytes"`
+}
+
+// MemoryAnalysis is the memory footprint of a sample of the written traces.
+type MemoryAnalysis struct {
+	SampledTraces int `json:"sampledTraces"`
+	// MissingTraces were not found anymore, e.g. because they expired or were evicted.
+	MissingTraces int            `json:"missingTraces"`
+	Encodings     map[string]int `json:"encodings"`
+
+	BytesPerTrace    float64 `json:"bytesPerTrace"`
+	BytesPerSpan     float64 `json:"bytesPerSpan"`
+	RawBytesPerTrace float64 `json:"rawBytesPerTrace"`
+	RawBytesPerSpan  float64 `json:"rawBytesPerSpan"`
+	// OverheadRatio is the memory used over the raw size of the span values.
+	OverheadRatio float64 `json:"overheadRatio"`
+
+	Projection *MemoryProjection `json:"projection,omitempty"`
+	// Codecs breaks the analysis down by the codec the traces were written with.
+	Codecs map[string]MemoryAnalysis `json:"codecs,omitempty"`
+}
+
+// MemoryProjection is the memory needed to keep the traces of a steady load until they expire.
+type MemoryProjection struct {
+	TracesPerSecond float64 `json:"tracesPerSecond"`
+	TtlSeconds      int     `json:"ttlSeconds"`
+	LiveTraces      float64 `json:"liveTraces"`
+	Bytes           float64 `json:"bytes"`
+}
+
+type traceMeasurement struct {
+	sample TraceSample
+	memory TraceMemory
+}
+
+// AnalyzeMemory measures the sampled traces. Traces the probe fails on are counted as missing.
+func AnalyzeMemory(probe TraceMemoryProbe, samples []TraceSample) MemoryAnalysis {
+	measurements := make([]traceMeasurement, 0, len(samples))
+	byCodec := make(map[string][]traceMeasurement)
+	for _, sample := range samples {
+		memory, err := probe.TraceMemory(sample.TraceId)
+		if err != nil {
+			memory = TraceMemory{}
+		}
+		measurement := traceMeasurement{sample: sample, memory: memory}
+		measurements = append(measurements, measurement)
+		if sample.Codec != "" {
+			byCodec[sample.Codec] = append(byCodec[sample.Codec], measurement)
+		}
+	}
+
+	analysis := summarize(measurements)
+	if len(byCodec) > 0 {
+		analysis.Codecs = make(map[string]MemoryAnalysis, len(byCodec))
+		for codec, codecMeasurements := range byCodec {
+			analysis.Codecs[codec] = summarize(codecMeasurements)
+		}
+	}
+	return analysis
+}
+
+func summarize(measurements []traceMeasurement) MemoryAnalysis {
+	analysis := MemoryAnalysis{Encodings: make(map[string]int)}
+	var bytes, rawBytes, spans int64
+	for _, measurement := range measurements {
+		analysis.SampledTraces++
+		memory := measurement.memory
+		if !memory.Found {
+			analysis.MissingTraces++
+			continue
+		}
+		encoding := memory.Encoding
+		if encoding == "" {
+			encoding = UnknownEncoding
+		}
+		analysis.Encodings[encoding]++
+		bytes += memory.Bytes
+		rawBytes += measurement.sample.RawBytes
+		spans += int64(measurement.sample.Spans)
+	}
+
+	measured := analysis.SampledTraces - analysis.MissingTraces
+	if measured == 0 || spans == 0 {
+		return analysis
+	}
+	analysis.BytesPerTrace = float64(bytes) / float64(measured)
+	analysis.BytesPerSpan = float64(bytes) / float64(spans)
+	analysis.RawBytesPerTrace = float64(rawBytes) / float64(measured)
+	analysis.RawBytesPerSpan = float64(rawBytes) / float64(spans)
+	if rawBytes > 0 {
+		analysis.OverheadRatio = float64(bytes) / float64(rawBytes)
+	}
+	return analysis
+}
+
+// Project returns the memory needed for tracesPerSecond traces kept for ttlSeconds.
+func (a MemoryAnalysis) Project(tracesPerSecond float64, ttlSeconds int) MemoryProjection {
+	liveTraces := tracesPerSecond * float64(ttlSeconds)
+	return MemoryProjection{
+		TracesPerSecond: tracesPerSecond,
+		TtlSeconds:      ttlSeconds,
+		LiveTraces:      liveTraces,
+		Bytes:           liveTraces * a.BytesPerTrace,
+	}
+}
+
+// DominantEncoding returns the most frequent encoding of the sampled traces.
+func (a MemoryAnalysis) DominantEncoding() string {
+	encodings := make([]string, 0, len(a.Encodings))
+	for encoding := range a.Encodings {
+		encodings = append(encodings, encoding)
+	}
+	sort.Slice(encodings, func(i, j int) bool {
+		if a.Encodings[encodings[i]] != a.Encodings[encodings[j]] {
+			return a.Encodings[encodings[i]] > a.Encodings[encodings[j]]
+		}
+		return encodings[i] < encodings[j]
+	})
+	if len(encodings) == 0 {
+		return ""
+	}
+	return encodings[0]
+}
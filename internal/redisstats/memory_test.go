@@ -0,0 +1,63 @@
+package redisstats
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeMemoryProbe map[string]TraceMemory
+
+func (p fakeMemoryProbe) TraceMemory(traceId string) (TraceMemory, error) {
+	if traceId == "broken" {
+		return TraceMemory{}, errors.New("connection reset")
+	}
+	return p[traceId], nil
+}
+
+func TestAnalyzeMemory(t *testing.T) {
+	probe := fakeMemoryProbe{
+		"a": {Found: true, Bytes: 3000, Encoding: "listpack"},
+		"b": {Found: true, Bytes: 5000, Encoding: "hashtable"},
+		"c": {Found: true, Bytes: 4000, Encoding: "listpack"},
+	}
+	analysis := AnalyzeMemory(probe, []TraceSample{
+		{TraceId: "a", Spans: 10, RawBytes: 2000},
+		{TraceId: "b", Spans: 10, RawBytes: 2000},
+		{TraceId: "c", Spans: 10, RawBytes: 2000},
+		{TraceId: "expired", Spans: 10, RawBytes: 2000},
+		{TraceId: "broken", Spans: 10, RawBytes: 2000},
+	})
+
+	if analysis.SampledTraces != 5 || analysis.MissingTraces != 2 {
+		t.Errorf("unexpected sample counts %+v", analysis)
+	}
+	if analysis.BytesPerTrace != 4000 || analysis.BytesPerSpan != 400 || analysis.RawBytesPerSpan != 200 || analysis.OverheadRatio != 2 {
+		t.Errorf("unexpected footprint %+v", analysis)
+	}
+	if analysis.DominantEncoding() != "listpack" {
+		t.Errorf("expected listpack to dominate, got %v", analysis.Encodings)
+	}
+
+	projection := analysis.Project(100, 600)
+	if projection.LiveTraces != 60000 || projection.Bytes != 240_000_000 {
+		t.Errorf("unexpected projection %+v", projection)
+	}
+}
+
+func TestAnalyzeMemoryByCodec(t *testing.T) {
+	probe := fakeMemoryProbe{
+		"a": {Found: true, Bytes: 3000, Encoding: "listpack"},
+		"b": {Found: true, Bytes: 1000, Encoding: "listpack"},
+	}
+	analysis := AnalyzeMemory(probe, []TraceSample{
+		{TraceId: "a", Codec: "json", Spans: 10, RawBytes: 2000},
+		{TraceId: "b", Codec: "msgpack+zstd", Spans: 10, RawBytes: 500},
+	})
+
+	if analysis.BytesPerTrace != 2000 || len(analysis.Codecs) != 2 {
+		t.Fatalf("unexpected analysis %+v", analysis)
+	}
+	if analysis.Codecs["json"].BytesPerSpan != 300 || analysis.Codecs["msgpack+zstd"].OverheadRatio != 2 {
+		t.Errorf("unexpected codec breakdown %+v", analysis.Codecs)
+	}
+}
@@ -0,0 +1,298 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"redis-test/internal/metrics"
+	"sort"
+	"strings"
+)
+
+const (
+	chartWidth   = 720
+	chartHeight  = 220
+	chartMarginX = 60
+	chartMarginY = 24
+)
+
+var seriesColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e"}
+
+type chartSeries struct {
+	name   string
+	values []float64
+}
+
+// chart is a line chart over the run timeline, rendered as inline SVG.
+type chart struct {
+	title  string
+	unit   string
+	x      []float64
+	series []chartSeries
+}
+
+func (c chart) svg() template.HTML {
+	maxY := 0.0
+	for _, s := range c.series {
+		for _, value := range s.values {
+			if value > maxY {
+				maxY = value
+			}
+		}
+	}
+	if maxY == 0 {
+		maxY = 1
+	}
+	maxX := 1.0
+	if len(c.x) > 0 && c.x[len(c.x)-1] > 0 {
+		maxX = c.x[len(c.x)-1]
+	}
+	plotWidth := float64(chartWidth - 2*chartMarginX)
+	plotHeight := float64(chartHeight - 2*chartMarginY)
+	scaleX := func(x float64) float64 { return chartMarginX + x/maxX*plotWidth }
+	scaleY := func(y float64) float64 { return chartMarginY + plotHeight - y/maxY*plotHeight }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<title>%s</title>`, html.EscapeString(c.title))
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`,
+		chartMarginX, chartHeight-chartMarginY, chartWidth-chartMarginX, chartHeight-chartMarginY)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`,
+		chartMarginX, chartMarginY, chartMarginX, chartHeight-chartMarginY)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">%s</text>`,
+		chartMarginX-4, chartMarginY+4, formatValue(maxY))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">0</text>`,
+		chartMarginX-4, chartHeight-chartMarginY)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">%.0fs</text>`,
+		chartWidth-chartMarginX, chartHeight-chartMarginY+14, maxX)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11">%s</text>`,
+		chartMarginX, chartMarginY-8, html.EscapeString(c.unit))
+
+	for i, s := range c.series {
+		color := seriesColors[i%len(seriesColors)]
+		points := make([]string, 0, len(s.values))
+		for j, value := range s.values {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", scaleX(c.x[j]), scaleY(value)))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`, color, strings.Join(points, " "))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="%s">%s</text>`,
+			chartWidth-chartMarginX+6, chartMarginY+12+14*i, color, html.EscapeString(s.name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func formatValue(value float64) string {
+	switch {
+	case value >= 1e9:
+		return fmt.Sprintf("%.1fG", value/1e9)
+	case value >= 1e6:
+		return fmt.Sprintf("%.1fM", value/1e6)
+	case value >= 1e3:
+		return fmt.Sprintf("%.1fk", value/1e3)
+	}
+	return fmt.Sprintf("%.3g", value)
+}
+
+// charts builds the timeline charts of the report. The used memory chart is left out if the server
+// did not report it.
+func (r Report) charts() []chart {
+	timeline := r.Timeline
+	x := make([]float64, len(timeline))
+	generated := make([]float64, len(timeline))
+	written := make([]float64, len(timeline))
+	p50 := make([]float64, len(timeline))
+	p99 := make([]float64, len(timeline))
+	depth := make([]float64, len(timeline))
+	var usedMemory []float64
+
+	previousOffset := 0.0
+	for i, point := range timeline {
+		x[i] = point.OffsetSeconds
+		interval := point.OffsetSeconds - previousOffset
+		previousOffset = point.OffsetSeconds
+		if interval > 0 {
+			generated[i] = float64(point.SpansGenerated) / interval
+			written[i] = float64(point.SpansWritten) / interval
+		}
+		p50[i] = float64(point.AckLatencyP50Us) / 1000
+		p99[i] = float64(point.AckLatencyP99Us) / 1000
+		depth[i] = float64(point.QueueDepth)
+		if point.UsedMemoryBytes != nil {
+			usedMemory = append(usedMemory, float64(*point.UsedMemoryBytes)/(1<<20))
+		}
+	}
+
+	charts := []chart{
+		{title: "Throughput", unit: "spans/s", x: x, series: []chartSeries{{"generated", generated}, {"written", written}}},
+		{title: "Span ack latency", unit: "ms", x: x, series: []chartSeries{{"p50", p50}, {"p99", p99}}},
+		{title: "Queue depth", unit: "spans", x: x, series: []chartSeries{{"depth", depth}}},
+	}
+	if len(usedMemory) == len(timeline) && len(timeline) > 0 {
+		charts = append(charts, chart{title: "Redis used memory", unit: "MiB", x: x, series: []chartSeries{{"used_memory", usedMemory}}})
+	}
+	if opsChart, ok := r.serverOpsChart(); ok {
+		charts = append(charts, opsChart)
+	}
+	return charts
+}
+
+// serverOpsChart charts the instantaneous_ops_per_sec samples of the server, if there are any.
+func (r Report) serverOpsChart() (chart, bool) {
+	if r.Server == nil {
+		return chart{}, false
+	}
+	var x, ops []float64
+	for _, sample := range r.Server.Samples {
+		if value, ok := sample.Fields["instantaneous_ops_per_sec"]; ok {
+			x = append(x, sample.Time.Sub(r.StartTime).Seconds())
+			ops = append(ops, value)
+		}
+	}
+	if len(ops) < 2 {
+		return chart{}, false
+	}
+	return chart{title: "Redis operations", unit: "ops/s", x: x, series: []chartSeries{{"ops/s", ops}}}, true
+}
+
+type htmlChart struct {
+	Title string
+	SVG   template.HTML
+}
+
+type htmlLatency struct {
+	Name string
+	metrics.HistogramSnapshot
+}
+
+type htmlData struct {
+	Report
+	Charts    []htmlChart
+	Latencies []htmlLatency
+}
+
+var htmlFuncs = template.FuncMap{
+	"mib":     mib,
+	"percent": func(ratio float64) float64 { return ratio * 100 },
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Run {{.RunId}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th { background: #f3f3f3; }
+td:first-child, th:first-child { text-align: left; }
+.passed { color: #2ca02c; } .failed { color: #d62728; }
+</style>
+</head>
+<body>
+<h1>Run {{.RunId}}</h1>
+<p>Profile <b>{{.Profile}}</b>, status <b>{{.Status}}</b>, started {{.StartTime.Format "2006-01-02 15:04:05 MST"}}, {{printf "%.1f" .DurationSeconds}}s</p>
+{{with .Verdict}}
+<h2 class="{{if .Passed}}passed{{else}}failed{{end}}">Verdict: {{if .Passed}}passed{{else}}failed{{end}}</h2>
+<table>
+<tr><th>Assertion</th><th>Threshold</th><th>Actual</th><th>Result</th></tr>
+{{range .Assertions}}<tr><td>{{.Name}}</td><td>{{.Threshold}}</td><td>{{printf "%.4g" .Actual}}</td><td class="{{if .Passed}}passed{{else}}failed{{end}}">{{if .Passed}}ok{{else}}{{.Message}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Totals</h2>
+<table>
+<tr><th></th><th>Generated</th><th>Written</th><th>Failed</th><th>Dropped</th></tr>
+<tr><td>Traces</td><td>{{.Totals.TracesGenerated}}</td><td>{{.Totals.TracesWritten}}</td><td></td><td></td></tr>
+<tr><td>Spans</td><td>{{.Totals.SpansGenerated}}</td><td>{{.Totals.SpansWritten}}</td><td>{{.Totals.SpansFailed}}</td><td>{{.Totals.SpansDropped}}</td></tr>
+</table>
+<p>{{printf "%.1f" .SpansPerSecond}} spans/s, {{printf "%.0f" .BytesPerSecond}} bytes/s, {{.Totals.BytesWritten}} bytes written, {{printf "%.2f" .CommandsPerSpan}} commands/span</p>
+{{range .Charts}}<h2>{{.Title}}</h2>
+{{.SVG}}
+{{end}}
+<h2>Latency percentiles</h2>
+<table>
+<tr><th>Histogram</th><th>Unit</th><th>Count</th><th>p50</th><th>p90</th><th>p99</th><th>p99.9</th><th>Max</th></tr>
+{{range .Latencies}}<tr><td>{{.Name}}</td><td>{{.Unit}}</td><td>{{.Count}}</td><td>{{.P50}}</td><td>{{.P90}}</td><td>{{.P99}}</td><td>{{.P999}}</td><td>{{.Max}}</td></tr>
+{{end}}</table>
+{{with .Memory}}<h2>Memory footprint</h2>
+<table>
+<tr><th></th><th>Per trace</th><th>Per span</th></tr>
+<tr><td>Redis memory (bytes)</td><td>{{printf "%.0f" .BytesPerTrace}}</td><td>{{printf "%.0f" .BytesPerSpan}}</td></tr>
+<tr><td>Span values (bytes)</td><td>{{printf "%.0f" .RawBytesPerTrace}}</td><td>{{printf "%.0f" .RawBytesPerSpan}}</td></tr>
+</table>
+<p>Overhead ratio {{printf "%.2f" .OverheadRatio}}, {{.SampledTraces}} traces sampled ({{.MissingTraces}} missing), encodings {{range $encoding, $count := .Encodings}}{{$encoding}}: {{$count}} {{end}}</p>
+{{with .Projection}}<p>At {{printf "%.1f" .TracesPerSecond}} traces/s with a {{.TtlSeconds}}s ttl, {{printf "%.0f" .LiveTraces}} live traces need about {{printf "%.1f" (mib .Bytes)}} MiB.</p>{{end}}
+{{end}}
+{{with .Pressure}}<h2>Memory pressure</h2>
+<p>{{if .Reached}}used_memory reached maxmemory ({{printf "%.1f" (mib .MaxMemoryBytes)}} MiB, {{.MaxMemoryPolicy}}) after {{printf "%.1f" .ReachedSeconds}}s{{else}}used_memory did not reach maxmemory ({{printf "%.1f" (mib .MaxMemoryBytes)}} MiB){{end}}, the load stopped after {{printf "%.1f" .LoadStoppedSeconds}}s. {{.EvictedKeys}} keys evicted ({{printf "%.1f" .EvictionsPerSecond}}/s during the overload), {{.OomErrors}} spans rejected with OOM. Span ack p99 went from {{.FillAckP99Us}}us to {{.OverloadAckP99Us}}us{{if .LatencyDegradation}} ({{printf "%.1f" .LatencyDegradation}}x){{end}}. {{if .Recovered}}Writes recovered {{printf "%.1f" .RecoverySeconds}}s after the load stopped.{{else}}Writes did not recover before the timeout.{{end}}{{with .Error}} {{.}}{{end}}</p>
+<table>
+<tr><th>Offset (s)</th><th>Phase</th><th>Used memory (%)</th><th>Ack p50 (us)</th><th>Ack p99 (us)</th><th>Spans written</th><th>Spans failed</th><th>Evictions/s</th></tr>
+{{range .Curve}}<tr><td>{{printf "%.1f" .OffsetSeconds}}</td><td>{{.Phase}}</td><td>{{printf "%.0f" (percent .UsedMemoryRatio)}}</td><td>{{.AckLatencyP50Us}}</td><td>{{.AckLatencyP99Us}}</td><td>{{.SpansWritten}}</td><td>{{.SpansFailed}}</td><td>{{printf "%.1f" .EvictionsPerSecond}}</td></tr>
+{{end}}</table>
+{{end}}{{with .Prefill}}<p>Prefilled {{.Traces}} traces in {{printf "%.1f" .DurationSeconds}}s, used memory went from {{printf "%.1f" (mib .UsedMemoryBefore)}} to {{printf "%.1f" (mib .UsedMemoryAfter)}} MiB for a target of {{.Target}} ({{printf "%.1f" (mib .TargetBytes)}} MiB){{if not .Reached}}, the target was not reached: {{.Error}}{{end}}.</p>
+{{end}}{{with .Reads}}<h2>Verification</h2>
+<p>Read back {{.Spans}} spans of {{.Traces}} traces verified in the {{.Mode}} mode with seed {{.Seed}}: {{.Missing}} missing, {{.Incomplete}} incomplete, {{.Failed}} failed. {{.MissingSpans}} spans missing, {{.CorruptSpans}} corrupt, {{.OrphanedSpans}} orphaned, {{.UnexpectedSpans}} unexpected; {{.Unrooted}} traces unrooted, {{.TtlOutOfRange}} of {{.TtlChecked}} with a ttl out of range.{{if .FullScan}} Each read ranged over the whole buckets of its trace, the trace_read latencies are full-scan costs.{{end}}</p>
+{{if .Problems}}<table>
+<tr><th>Trace</th><th>Span</th><th>Problem</th><th>Detail</th></tr>
+{{range .Problems}}<tr><td>{{.TraceId}}</td><td>{{.SpanId}}</td><td>{{.Problem}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>
+{{if gt .ProblemCount (len .Problems)}}<p>{{.ProblemCount}} problems found, the first {{len .Problems}} are listed.</p>
+{{end}}{{end}}{{end}}{{with .Expiry}}<p>The {{.Strategy}} ttl strategy sent {{.Commands}} expiry commands and saved {{.Skipped}} ({{printf "%.0f" (percent .SavedRatio)}}%). {{if .Bounded}}All {{.Traces}} sampled traces expire within {{.MaxTtlSeconds}}s.{{else}}{{.Unbounded}} of {{.Traces}} sampled traces never expire.{{end}}</p>
+{{end}}{{if .Codecs}}<h2>Codecs</h2>
+<table>
+<tr><th>Codec</th><th>Spans</th><th>Encode (ns/span)</th><th>Encode total (s)</th><th>Stored (bytes/span)</th><th>Redis memory (bytes/span)</th><th>Redis memory (bytes/trace)</th></tr>
+{{range .Codecs}}<tr><td>{{.Codec}}</td><td>{{.Spans}}</td><td>{{printf "%.0f" .EncodeNsPerSpan}}</td><td>{{printf "%.3f" .EncodeSeconds}}</td><td>{{printf "%.1f" .StoredBytesPerSpan}}</td><td>{{if .MemoryBytesPerSpan}}{{printf "%.0f" .MemoryBytesPerSpan}}{{end}}</td><td>{{if .MemoryBytesPerTrace}}{{printf "%.0f" .MemoryBytesPerTrace}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .Server}}{{if .SlowLog}}<h2>Slow log</h2>
+<table>
+<tr><th>Time</th><th>Duration (us)</th><th>Command</th></tr>
+{{range .SlowLog}}<tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.DurationUs}}</td><td>{{.Command}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Latency}}<h2>Latency spikes</h2>
+<table>
+<tr><th>Event</th><th>Latest (ms)</th><th>Max (ms)</th></tr>
+{{range .Latency}}<tr><td>{{.Event}}</td><td>{{.LatestMS}}</td><td>{{.MaxMS}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+{{if .Errors}}<h2>Errors</h2>
+<table>
+<tr><th>Class</th><th>Spans</th></tr>
+{{range $class, $count := .Errors}}<tr><td>{{$class}}</td><td>{{$count}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// HTML renders the report as a self-contained page with inline SVG charts.
+func (r Report) HTML() ([]byte, error) {
+	data := htmlData{Report: r}
+	for _, c := range r.charts() {
+		data.Charts = append(data.Charts, htmlChart{Title: c.title, SVG: c.svg()})
+	}
+	for name, histogram := range r.Latency {
+		data.Latencies = append(data.Latencies, htmlLatency{Name: name, HistogramSnapshot: histogram})
+	}
+	sort.Slice(data.Latencies, func(i, j int) bool { return data.Latencies[i].Name < data.Latencies[j].Name })
+
+	var buffer bytes.Buffer
+	if err := htmlTemplate.Execute(&buffer, data); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// mib converts the bytes of the report, float or integer, to MiB.
+func mib(bytes interface{}) float64 {
+	switch value := bytes.(type) {
+	case int64:
+		return float64(value) / (1 << 20)
+	case float64:
+		return value / (1 << 20)
+	}
+	return 0
+}
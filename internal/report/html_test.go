@@ -0,0 +1,45 @@
+package report
+
+import (
+	"redis-test/internal/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func timelineReport(usedMemory bool) Report {
+	r := Report{RunId: "run", Profile: "<profile>", Status: StatusCompleted, StartTime: time.Now(), DurationSeconds: 2}
+	for i := 1; i <= 2; i++ {
+		point := metrics.TimelinePoint{OffsetSeconds: float64(i), SpansGenerated: 100, SpansWritten: 90, AckLatencyP99Us: 2000}
+		if usedMemory {
+			memory := int64(i << 20)
+			point.UsedMemoryBytes = &memory
+		}
+		r.Timeline = append(r.Timeline, point)
+	}
+	return r
+}
+
+func TestHTMLHasAChartPerTimelineSeries(t *testing.T) {
+	page, err := timelineReport(true).HTML()
+	if err != nil {
+		t.Fatalf("unable to render html: %v", err)
+	}
+	text := string(page)
+	if count := strings.Count(text, "<svg"); count != 4 {
+		t.Errorf("expected 4 charts, found %d", count)
+	}
+	if !strings.Contains(text, "Redis used memory") || !strings.Contains(text, "&lt;profile&gt;") {
+		t.Errorf("expected the memory chart and an escaped profile in %s", text)
+	}
+}
+
+func TestHTMLLeavesOutUnknownMemory(t *testing.T) {
+	page, err := timelineReport(false).HTML()
+	if err != nil {
+		t.Fatalf("unable to render html: %v", err)
+	}
+	if count := strings.Count(string(page), "<svg"); count != 3 {
+		t.Errorf("expected 3 charts without used memory, found %d", count)
+	}
+}
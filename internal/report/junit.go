@@ -0,0 +1,68 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnit renders the verdict of the report as a JUnit XML test suite with a test case per assertion.
+// A report without thresholds has a single test case for the completion of the run.
+func (r Report) JUnit() ([]byte, error) {
+	verdict := r.Verdict
+	if verdict == nil {
+		completed := Assertion{Name: AssertionCompleted, Passed: r.Status == StatusCompleted}
+		if !completed.Passed {
+			completed.Message = fmt.Sprintf("run ended with status %s", r.Status)
+		}
+		verdict = &Verdict{Passed: completed.Passed, Assertions: []Assertion{completed}}
+	}
+
+	suite := junitTestSuite{
+		Name:      "run " + r.RunId,
+		Tests:     len(verdict.Assertions),
+		Time:      r.DurationSeconds,
+		Timestamp: r.StartTime.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for _, assertion := range verdict.Assertions {
+		testCase := junitTestCase{Name: assertion.Name, ClassName: "zk-redis-test.slo"}
+		if !assertion.Passed {
+			suite.Failures++
+			testCase.Failure = &junitFailure{
+				Message: assertion.Message,
+				Text:    fmt.Sprintf("threshold=%g actual=%g", assertion.Threshold, assertion.Actual),
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
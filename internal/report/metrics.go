@@ -0,0 +1,74 @@
+package report
+
+import (
+	"redis-test/internal/metrics"
+)
+
+// Metrics flattens the numbers of a report into named metrics, for comparisons and trends. Latency
+// percentiles are named latency.<histogram>.<percentile>, redis deltas redis.<field> and the costs of
+// a codec codec.<codec>.<metric>.
+func (r Report) Metrics() map[string]float64 {
+	values := map[string]float64{
+		"durationSeconds": r.DurationSeconds,
+		"spansPerSecond":  r.SpansPerSecond,
+		"bytesPerSecond":  r.BytesPerSecond,
+		"spansGenerated":  float64(r.Totals.SpansGenerated),
+		"spansWritten":    float64(r.Totals.SpansWritten),
+		"spansFailed":     float64(r.Totals.SpansFailed),
+		"spansDropped":    float64(r.Totals.SpansDropped),
+		"bytesWritten":    float64(r.Totals.BytesWritten),
+		"commands":        float64(r.Totals.Commands),
+		"commandsPerSpan": r.CommandsPerSpan,
+		"errorRate":       errorRate(r.Totals),
+	}
+	for name, histogram := range r.Latency {
+		addHistogram(values, "latency."+name, histogram)
+	}
+	for field, delta := range r.RedisDelta {
+		values["redis."+field] = delta
+	}
+	for _, codec := range r.Codecs {
+		prefix := "codec." + codec.Codec
+		values[prefix+".encodeNsPerSpan"] = codec.EncodeNsPerSpan
+		values[prefix+".storedBytesPerSpan"] = codec.StoredBytesPerSpan
+		if codec.MemoryBytesPerSpan > 0 {
+			values[prefix+".memoryBytesPerSpan"] = codec.MemoryBytesPerSpan
+		}
+	}
+	if r.Memory != nil && r.Memory.SampledTraces > r.Memory.MissingTraces {
+		values["memory.bytesPerTrace"] = r.Memory.BytesPerTrace
+		values["memory.bytesPerSpan"] = r.Memory.BytesPerSpan
+		values["memory.overheadRatio"] = r.Memory.OverheadRatio
+	}
+	if r.Reads != nil {
+		values["reads.traces"] = float64(r.Reads.Traces)
+		values["reads.missingSpans"] = float64(r.Reads.MissingSpans)
+		values["reads.corruptSpans"] = float64(r.Reads.CorruptSpans)
+		values["reads.orphanedSpans"] = float64(r.Reads.OrphanedSpans)
+		values["reads.problems"] = float64(r.Reads.ProblemCount)
+	}
+	if r.Pressure != nil {
+		values["pressure.evictionsPerSecond"] = r.Pressure.EvictionsPerSecond
+		values["pressure.oomErrors"] = float64(r.Pressure.OomErrors)
+		values["pressure.overloadAckP99Us"] = float64(r.Pressure.OverloadAckP99Us)
+		values["pressure.latencyDegradation"] = r.Pressure.LatencyDegradation
+		if r.Pressure.Recovered {
+			values["pressure.recoverySeconds"] = r.Pressure.RecoverySeconds
+		}
+	}
+	if r.Expiry != nil {
+		values["expiry.commands"] = float64(r.Expiry.Commands)
+		values["expiry.savedRatio"] = r.Expiry.SavedRatio
+		values["expiry.unboundedTraces"] = float64(r.Expiry.Unbounded)
+	}
+	return values
+}
+
+func addHistogram(values map[string]float64, prefix string, histogram metrics.HistogramSnapshot) {
+	values[prefix+".mean"] = histogram.Mean
+	values[prefix+".p50"] = float64(histogram.P50)
+	values[prefix+".p90"] = float64(histogram.P90)
+	values[prefix+".p99"] = float64(histogram.P99)
+	values[prefix+".p999"] = float64(histogram.P999)
+	values[prefix+".max"] = float64(histogram.Max)
+}
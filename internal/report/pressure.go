@@ -0,0 +1,178 @@
+package report
+
+import (
+	"redis-test/internal/redisstats"
+	"sort"
+	"time"
+)
+
+const (
+	// oomErrorClass is the class of the commands rejected with OOM, see handlers.ErrorClassOOM.
+	oomErrorClass    = "oom"
+	evictedKeysField = "evicted_keys"
+)
+
+// Phases of a memory pressure run.
+const (
+	PhaseFill     = "fill"
+	PhaseOverload = "overload"
+	PhaseRecovery = "recovery"
+)
+
+// Pressure is how the server behaved while a run wrote past maxmemory and once it stopped. The offsets
+// are from the start of the run.
+type Pressure struct {
+	MaxMemoryBytes  int64  `json:"maxMemoryBytes"`
+	MaxMemoryPolicy string `json:"maxMemoryPolicy,omitempty"`
+	// Reached is set if used_memory reached maxmemory, at ReachedSeconds. The load was stopped at
+	// LoadStoppedSeconds, OverloadSeconds after it was reached or when the run ran out of traces or time.
+	Reached            bool    `json:"reached"`
+	ReachedSeconds     float64 `json:"reachedSeconds,omitempty"`
+	OverloadSeconds    int     `json:"overloadSeconds"`
+	LoadStoppedSeconds float64 `json:"loadStoppedSeconds"`
+	// EvictedKeys is the number of keys the server evicted during the run, EvictionsPerSecond their rate
+	// during the overload. Both need the collector.
+	EvictedKeys        int64   `json:"evictedKeys"`
+	EvictionsPerSecond float64 `json:"evictionsPerSecond"`
+	// OomErrors counts the spans whose commands were rejected with OOM.
+	OomErrors int64 `json:"oomErrors"`
+	// FillAckP99Us and OverloadAckP99Us are the median of the span ack p99 of the timeline points of
+	// the phases, LatencyDegradation the ratio of the overload to the fill.
+	FillAckP99Us       int64   `json:"fillAckP99Us"`
+	OverloadAckP99Us   int64   `json:"overloadAckP99Us"`
+	LatencyDegradation float64 `json:"latencyDegradation,omitempty"`
+	// BaselineProbeUs is the latency of a probe write before the run. The server recovered when a probe
+	// write succeeded within the recovery latency, RecoverySeconds after the load stopped.
+	BaselineProbeUs int64   `json:"baselineProbeUs"`
+	Recovered       bool    `json:"recovered"`
+	RecoverySeconds float64 `json:"recoverySeconds,omitempty"`
+	// Curve is the timeline of the run with its phases and the state of the server.
+	Curve []PressurePoint `json:"curve"`
+	Error string          `json:"error,omitempty"`
+}
+
+// PressurePoint is a timeline point of a memory pressure run.
+type PressurePoint struct {
+	OffsetSeconds float64 `json:"offsetSeconds"`
+	Phase         string  `json:"phase"`
+	// UsedMemoryRatio is used_memory over maxmemory at the end of the interval, if known.
+	UsedMemoryRatio    float64 `json:"usedMemoryRatio,omitempty"`
+	AckLatencyP50Us    int64   `json:"ackLatencyP50Us"`
+	AckLatencyP99Us    int64   `json:"ackLatencyP99Us"`
+	SpansWritten       int64   `json:"spansWritten"`
+	SpansFailed        int64   `json:"spansFailed"`
+	EvictionsPerSecond float64 `json:"evictionsPerSecond"`
+}
+
+// phase returns the phase of the run at an offset.
+func (p Pressure) phase(offsetSeconds float64) string {
+	switch {
+	case offsetSeconds > p.LoadStoppedSeconds:
+		return PhaseRecovery
+	case p.Reached && offsetSeconds > p.ReachedSeconds:
+		return PhaseOverload
+	}
+	return PhaseFill
+}
+
+// SetPressure sets the memory pressure of the run, with the curve, evictions, OOM errors and latency
+// degradation derived from its timeline, errors and server samples.
+func (r *Report) SetPressure(pressure *Pressure) {
+	if pressure == nil {
+		r.Pressure = nil
+		return
+	}
+	copied := *pressure
+	pressure = &copied
+	r.Pressure = pressure
+
+	var samples []redisstats.Sample
+	if r.Server != nil {
+		samples = r.Server.Samples
+	}
+	at := func(offsetSeconds float64) time.Time {
+		return r.StartTime.Add(time.Duration(offsetSeconds * float64(time.Second)))
+	}
+
+	pressure.Curve = make([]PressurePoint, 0, len(r.Timeline))
+	var fillP99, overloadP99 []int64
+	previous := 0.0
+	for _, point := range r.Timeline {
+		curvePoint := PressurePoint{
+			OffsetSeconds:   point.OffsetSeconds,
+			Phase:           pressure.phase(point.OffsetSeconds),
+			AckLatencyP50Us: point.AckLatencyP50Us,
+			AckLatencyP99Us: point.AckLatencyP99Us,
+			SpansWritten:    point.SpansWritten,
+			SpansFailed:     point.SpansFailed,
+		}
+		if point.UsedMemoryBytes != nil && pressure.MaxMemoryBytes > 0 {
+			curvePoint.UsedMemoryRatio = float64(*point.UsedMemoryBytes) / float64(pressure.MaxMemoryBytes)
+		}
+		curvePoint.EvictionsPerSecond, _ = fieldRate(samples, evictedKeysField, at(previous), at(point.OffsetSeconds))
+		if point.SpansWritten > 0 {
+			switch curvePoint.Phase {
+			case PhaseFill:
+				fillP99 = append(fillP99, point.AckLatencyP99Us)
+			case PhaseOverload:
+				overloadP99 = append(overloadP99, point.AckLatencyP99Us)
+			}
+		}
+		pressure.Curve = append(pressure.Curve, curvePoint)
+		previous = point.OffsetSeconds
+	}
+
+	pressure.OomErrors = r.Errors[oomErrorClass]
+	pressure.FillAckP99Us = median(fillP99)
+	pressure.OverloadAckP99Us = median(overloadP99)
+	pressure.LatencyDegradation = 0
+	if pressure.FillAckP99Us > 0 && pressure.OverloadAckP99Us > 0 {
+		pressure.LatencyDegradation = float64(pressure.OverloadAckP99Us) / float64(pressure.FillAckP99Us)
+	}
+	pressure.EvictedKeys, pressure.EvictionsPerSecond = 0, 0
+	if len(samples) > 0 {
+		first, firstOk := samples[0].Fields[evictedKeysField]
+		last, lastOk := samples[len(samples)-1].Fields[evictedKeysField]
+		if firstOk && lastOk {
+			pressure.EvictedKeys = int64(last - first)
+		}
+	}
+	if pressure.Reached {
+		pressure.EvictionsPerSecond, _ = fieldRate(samples, evictedKeysField, at(pressure.ReachedSeconds), at(pressure.LoadStoppedSeconds))
+	}
+}
+
+// fieldRate uses the last sample at or before each point in time.
+func fieldRate(samples []redisstats.Sample, field string, from, to time.Time) (float64, bool) {
+	fromValue, fromTime, fromOk := fieldAt(samples, field, from)
+	toValue, toTime, toOk := fieldAt(samples, field, to)
+	seconds := toTime.Sub(fromTime).Seconds()
+	if !fromOk || !toOk || seconds <= 0 {
+		return 0, false
+	}
+	return (toValue - fromValue) / seconds, true
+}
+
+func fieldAt(samples []redisstats.Sample, field string, at time.Time) (float64, time.Time, bool) {
+	var value float64
+	var sampled time.Time
+	found := false
+	for _, sample := range samples {
+		if sample.Time.After(at) {
+			break
+		}
+		if fieldValue, ok := sample.Fields[field]; ok {
+			value, sampled, found = fieldValue, sample.Time, true
+		}
+	}
+	return value, sampled, found
+}
+
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
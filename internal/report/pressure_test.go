@@ -0,0 +1,51 @@
+package report
+
+import (
+	"redis-test/internal/metrics"
+	"redis-test/internal/redisstats"
+	"redis-test/model"
+	"testing"
+	"time"
+)
+
+func TestSetPressureDerivesTheCurve(t *testing.T) {
+	start := time.Now()
+	usedMemory := func(bytes int64) *int64 { return &bytes }
+	r := New("run", "profile", model.RunParameters{}, nil, start)
+	r.Timeline = []metrics.TimelinePoint{
+		{OffsetSeconds: 1, SpansWritten: 100, AckLatencyP99Us: 100, UsedMemoryBytes: usedMemory(500)},
+		{OffsetSeconds: 2, SpansWritten: 100, AckLatencyP99Us: 120, UsedMemoryBytes: usedMemory(1000)},
+		{OffsetSeconds: 3, SpansWritten: 50, AckLatencyP99Us: 500, UsedMemoryBytes: usedMemory(1000)},
+		{OffsetSeconds: 4, SpansWritten: 40, AckLatencyP99Us: 700, UsedMemoryBytes: usedMemory(1000)},
+		{OffsetSeconds: 5, UsedMemoryBytes: usedMemory(900)},
+	}
+	r.Errors = map[string]int64{"oom": 7, "timeout": 1}
+	sample := func(offsetSeconds float64, evicted float64) redisstats.Sample {
+		return redisstats.Sample{Time: start.Add(time.Duration(offsetSeconds * float64(time.Second))), Fields: map[string]float64{"evicted_keys": evicted}}
+	}
+	r.Server = &redisstats.Window{Samples: []redisstats.Sample{sample(0, 10), sample(1, 10), sample(2, 10), sample(3, 40), sample(4, 90), sample(5, 90)}}
+
+	pressure := &Pressure{MaxMemoryBytes: 1000, Reached: true, ReachedSeconds: 2, LoadStoppedSeconds: 4}
+	r.SetPressure(pressure)
+
+	got := r.Pressure
+	if pressure.Curve != nil {
+		t.Errorf("the given pressure must not be modified")
+	}
+	phases := ""
+	for _, point := range got.Curve {
+		phases += point.Phase[:1]
+	}
+	if phases != "ffoor" || got.Curve[1].UsedMemoryRatio != 1 || got.Curve[3].EvictionsPerSecond != 50 {
+		t.Errorf("unexpected curve %+v", got.Curve)
+	}
+	if got.EvictedKeys != 80 || got.EvictionsPerSecond != 40 || got.OomErrors != 7 {
+		t.Errorf("unexpected evictions and errors %+v", got)
+	}
+	if got.FillAckP99Us != 120 || got.OverloadAckP99Us != 700 || got.LatencyDegradation != 700.0/120 {
+		t.Errorf("unexpected latency degradation %+v", got)
+	}
+	if metrics := r.Metrics(); metrics["pressure.oomErrors"] != 7 {
+		t.Errorf("expected the pressure in the metrics, got %v", metrics)
+	}
+}
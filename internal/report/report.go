@@ -0,0 +1,337 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"redis-test/internal/metrics"
+	"redis-test/internal/redisstats"
+	"redis-test/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status of a run.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusCancelled Status = "cancelled"
+	// StatusIncomplete is used when not all generated spans were acknowledged before the drain timeout.
+	StatusIncomplete Status = "incomplete"
+)
+
+const redacted = "REDACTED"
+
+// secretKeys are the config field names whose values are redacted in reports.
+var secretKeys = []string{"password", "secret", "token"}
+
+// Report summarizes a run.
+type Report struct {
+	RunId  string `json:"runId"`
+	Status Status `json:"status"`
+	// Profile groups the runs that are comparable with each other.
+	Profile    string                 `json:"profile"`
+	Parameters model.RunParameters    `json:"parameters"`
+	Config     map[string]interface{} `json:"config"`
+	StartTime  time.Time              `json:"startTime"`
+	EndTime    time.Time              `json:"endTime,omitempty"`
+
+	DurationSeconds float64           `json:"durationSeconds"`
+	Totals          metrics.RunCounts `json:"totals"`
+	SpansPerSecond  float64           `json:"spansPerSecond"`
+	BytesPerSecond  float64           `json:"bytesPerSecond"`
+	// CommandsPerSpan is the number of redis commands sent per written span, it tells the write modes apart.
+	CommandsPerSpan float64                              `json:"commandsPerSpan"`
+	Timeline        []metrics.TimelinePoint              `json:"timeline"`
+	Latency         map[string]metrics.HistogramSnapshot `json:"latency"`
+	Errors          map[string]int64                     `json:"errors"`
+	// Codecs compares the codecs the span values of the run were encoded with.
+	Codecs []CodecStats `json:"codecs,omitempty"`
+	// RedisDelta holds the change of the numeric INFO fields between the start and the end of the run.
+	RedisDelta map[string]float64 `json:"redisDelta,omitempty"`
+	// Server holds the INFO samples, slow commands and latency spikes of the server during the run.
+	Server *redisstats.Window `json:"server,omitempty"`
+	// Memory is the footprint of a sample of the written traces, measured when the run is over.
+	Memory *redisstats.MemoryAnalysis `json:"memory,omitempty"`
+	// Pressure is how the server behaved past maxmemory, for the runs of the memory pressure mode.
+	Pressure *Pressure `json:"pressure,omitempty"`
+	// Prefill is what was written before the run was measured, if it had a prefill target.
+	Prefill *Prefill `json:"prefill,omitempty"`
+	// Reads is the result of reading the sampled traces back once the run is over, the read latency is
+	// in Latency.
+	Reads *ReadCheck `json:"reads,omitempty"`
+	// Expiry is what the ttl strategy of the run cost and whether the sampled traces will expire.
+	Expiry *ExpiryCheck `json:"expiry,omitempty"`
+	// Verdict is set once the run is over if the run has thresholds.
+	Verdict *Verdict `json:"verdict,omitempty"`
+}
+
+// CodecStats is what encoding the span values of a run with one codec cost and took in the store.
+type CodecStats struct {
+	Codec              string  `json:"codec"`
+	Spans              int64   `json:"spans"`
+	EncodeSeconds      float64 `json:"encodeSeconds"`
+	EncodeNsPerSpan    float64 `json:"encodeNsPerSpan"`
+	StoredBytes        int64   `json:"storedBytes"`
+	StoredBytesPerSpan float64 `json:"storedBytesPerSpan"`
+	// MemoryBytesPerSpan and MemoryBytesPerTrace are measured on the sampled traces of the codec, they
+	// are zero if none were measured.
+	MemoryBytesPerSpan  float64 `json:"memoryBytesPerSpan,omitempty"`
+	MemoryBytesPerTrace float64 `json:"memoryBytesPerTrace,omitempty"`
+}
+
+// Prefill is what was written to bring the server to a used_memory level before a run was measured. It
+// is not part of the totals, the timeline or the latencies of the run.
+type Prefill struct {
+	// Target is the prefill target of the run, TargetBytes the used_memory it was resolved to.
+	Target           string `json:"target"`
+	TargetBytes      int64  `json:"targetBytes"`
+	MaxMemoryBytes   int64  `json:"maxMemoryBytes,omitempty"`
+	UsedMemoryBefore int64  `json:"usedMemoryBefore"`
+	UsedMemoryAfter  int64  `json:"usedMemoryAfter"`
+	Traces           int64  `json:"traces"`
+	Spans            int64  `json:"spans"`
+	// Reached is false if the prefill stopped before the target, Error tells why.
+	Reached         bool    `json:"reached"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// MaxProblems is the number of problems a read check lists, the others are only counted.
+const MaxProblems = 100
+
+// ReadCheck counts the traces of a run read back from the store and verified against the traces
+// generated again from the seed of the run, and what was wrong with them.
+type ReadCheck struct {
+	// Mode is which traces were verified, see handlers.VerifyModes.
+	Mode   string `json:"mode"`
+	Seed   int64  `json:"seed"`
+	Traces int    `json:"traces"`
+	Spans  int    `json:"spans"`
+	// FullScan is set when a trace read ranges over every entry of the keys it was written to, so the
+	// trace_read latencies are full-scan costs, e.g. the buckets of the stream-bucket layout.
+	FullScan bool `json:"fullScan,omitempty"`
+	// Missing traces were not found, Incomplete traces lacked some of their spans and Failed traces
+	// could not be read.
+	Missing    int `json:"missing"`
+	Incomplete int `json:"incomplete"`
+	Failed     int `json:"failed"`
+	// MissingSpans were generated but not found, CorruptSpans do not decode, OrphanedSpans have a
+	// parent that is not found and UnexpectedSpans were found but not generated.
+	MissingSpans    int `json:"missingSpans"`
+	CorruptSpans    int `json:"corruptSpans"`
+	OrphanedSpans   int `json:"orphanedSpans"`
+	UnexpectedSpans int `json:"unexpectedSpans"`
+	// Unrooted traces do not rebuild into a single tree rooted in their first span. TtlChecked traces
+	// had their ttl compared with the range of the ttl strategy, TtlOutOfRange were out of it.
+	Unrooted      int `json:"unrooted"`
+	TtlChecked    int `json:"ttlChecked"`
+	TtlOutOfRange int `json:"ttlOutOfRange"`
+	// Problems lists the first MaxProblems problems found, ProblemCount counts all of them.
+	Problems     []model.SpanProblem `json:"problems,omitempty"`
+	ProblemCount int                 `json:"problemCount"`
+}
+
+// AddProblems counts the problems found in a trace and lists them up to MaxProblems.
+func (c *ReadCheck) AddProblems(problems []model.SpanProblem) {
+	c.ProblemCount += len(problems)
+	if room := MaxProblems - len(c.Problems); room > 0 {
+		c.Problems = append(c.Problems, problems[:min(room, len(problems))]...)
+	}
+}
+
+// Passed reports whether every verified trace was read and nothing was wrong with it.
+func (c ReadCheck) Passed() bool {
+	return c.Failed == 0 && c.ProblemCount == 0
+}
+
+// ExpiryCheck compares the expiry commands of a ttl strategy with expiring every key for every span,
+// and checks the ttl of the sampled traces once the run is over.
+type ExpiryCheck struct {
+	Strategy string `json:"strategy"`
+	Commands int64  `json:"commands"`
+	Skipped  int64  `json:"skipped"`
+	// SavedRatio is the share of the expiry commands of expiring every key for every span that were
+	// not sent.
+	SavedRatio float64 `json:"savedRatio"`
+	// Traces is the number of sampled traces found, Unbounded the ones whose keys have no ttl.
+	Traces        int   `json:"traces"`
+	Unbounded     int   `json:"unbounded"`
+	MaxTtlSeconds int64 `json:"maxTtlSeconds"`
+}
+
+// Bounded reports whether all the sampled traces will expire.
+func (e ExpiryCheck) Bounded() bool {
+	return e.Unbounded == 0
+}
+
+// Summary identifies a report in run listings.
+type Summary struct {
+	RunId     string    `json:"runId"`
+	Status    Status    `json:"status"`
+	Profile   string    `json:"profile"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime,omitempty"`
+	Passed    *bool     `json:"passed,omitempty"`
+}
+
+// New starts the report of a run. The config is copied with its secrets redacted.
+func New(runId string, profile string, parameters model.RunParameters, config interface{}, start time.Time) *Report {
+	return &Report{
+		RunId:      runId,
+		Status:     StatusRunning,
+		Profile:    profile,
+		Parameters: parameters,
+		Config:     RedactConfig(config),
+		StartTime:  start,
+	}
+}
+
+// WithSnapshot returns a copy of the report filled in with what was recorded for the run until end.
+func (r Report) WithSnapshot(snapshot metrics.RunSnapshot, end time.Time) Report {
+	r.DurationSeconds = end.Sub(r.StartTime).Seconds()
+	r.Totals = snapshot.Counts
+	if r.DurationSeconds > 0 {
+		r.SpansPerSecond = float64(snapshot.Counts.SpansWritten) / r.DurationSeconds
+		r.BytesPerSecond = float64(snapshot.Counts.BytesWritten) / r.DurationSeconds
+	}
+	if snapshot.Counts.SpansWritten > 0 {
+		r.CommandsPerSpan = float64(snapshot.Counts.Commands) / float64(snapshot.Counts.SpansWritten)
+	}
+	r.Timeline = snapshot.Timeline
+	r.Latency = snapshot.Histograms
+	r.Errors = snapshot.Errors
+	r.Codecs = codecStats(snapshot.Codecs)
+	r.SetMemory(r.Memory)
+	r.SetExpiry(r.Expiry)
+	return r
+}
+
+// SetExpiry sets the expiry check of the run, with the expiry commands of its totals.
+func (r *Report) SetExpiry(expiry *ExpiryCheck) {
+	if expiry == nil {
+		r.Expiry = nil
+		return
+	}
+	copied := *expiry
+	expiry = &copied
+	r.Expiry = expiry
+	expiry.Commands = r.Totals.ExpiryCommands
+	expiry.Skipped = r.Totals.ExpirySkipped
+	expiry.SavedRatio = 0
+	if total := expiry.Commands + expiry.Skipped; total > 0 {
+		expiry.SavedRatio = float64(expiry.Skipped) / float64(total)
+	}
+}
+
+func codecStats(codecs map[string]metrics.CodecCounts) []CodecStats {
+	stats := make([]CodecStats, 0, len(codecs))
+	for name, counts := range codecs {
+		codecStats := CodecStats{
+			Codec:         name,
+			Spans:         counts.Spans,
+			EncodeSeconds: time.Duration(counts.EncodeNanos).Seconds(),
+			StoredBytes:   counts.Bytes,
+		}
+		if counts.Spans > 0 {
+			codecStats.EncodeNsPerSpan = float64(counts.EncodeNanos) / float64(counts.Spans)
+			codecStats.StoredBytesPerSpan = float64(counts.Bytes) / float64(counts.Spans)
+		}
+		stats = append(stats, codecStats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Codec < stats[j].Codec })
+	return stats
+}
+
+// SetMemory sets the memory analysis of the run and adds the memory taken by each codec to Codecs.
+func (r *Report) SetMemory(memory *redisstats.MemoryAnalysis) {
+	r.Memory = memory
+	if memory == nil {
+		return
+	}
+	for i := range r.Codecs {
+		if analysis, ok := memory.Codecs[r.Codecs[i].Codec]; ok {
+			r.Codecs[i].MemoryBytesPerSpan = analysis.BytesPerSpan
+			r.Codecs[i].MemoryBytesPerTrace = analysis.BytesPerTrace
+		}
+	}
+}
+
+func (r Report) Summary() Summary {
+	summary := Summary{RunId: r.RunId, Status: r.Status, Profile: r.Profile, StartTime: r.StartTime, EndTime: r.EndTime}
+	if r.Verdict != nil {
+		summary.Passed = &r.Verdict.Passed
+	}
+	return summary
+}
+
+// WriteFiles writes the report as <dir>/<runId>.json and <dir>/<runId>.html and returns the paths.
+func (r Report) WriteFiles(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	page, err := r.HTML()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for extension, content := range map[string][]byte{".json": data, ".html": page} {
+		path := filepath.Join(dir, r.RunId+extension)
+		if err = os.WriteFile(path, content, 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// RedactConfig converts a config struct to a map and replaces the values of secret fields.
+func RedactConfig(config interface{}) map[string]interface{} {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var values map[string]interface{}
+	if err = json.Unmarshal(data, &values); err != nil {
+		return nil
+	}
+	redact(values)
+	return values
+}
+
+func redact(values map[string]interface{}) {
+	for key, value := range values {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			redact(typed)
+		case []interface{}:
+			for _, item := range typed {
+				if nested, ok := item.(map[string]interface{}); ok {
+					redact(nested)
+				}
+			}
+		case string:
+			if typed != "" && isSecretKey(key) {
+				values[key] = redacted
+			}
+		}
+	}
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package report
+
+import (
+	"redis-test/internal/metrics"
+	"redis-test/internal/redisstats"
+	"redis-test/model"
+	"testing"
+	"time"
+)
+
+func TestRedactConfig(t *testing.T) {
+	config := struct {
+		Redis struct {
+			Host     string
+			Password string
+		}
+		ApiToken string `json:"apiToken"`
+	}{}
+	config.Redis.Host = "localhost"
+	config.Redis.Password = "hunter2"
+	config.ApiToken = "abc"
+
+	values := RedactConfig(config)
+	redis := values["Redis"].(map[string]interface{})
+	if redis["Password"] != redacted || values["apiToken"] != redacted {
+		t.Errorf("expected secrets to be redacted, got %v", values)
+	}
+	if redis["Host"] != "localhost" {
+		t.Errorf("expected other fields to be kept, got %v", values)
+	}
+}
+
+func TestWithSnapshotComputesThroughput(t *testing.T) {
+	start := time.Now()
+	r := New("run", "profile", model.RunParameters{TraceCount: 2, SpansPerTrace: 10}, nil, start)
+	filled := r.WithSnapshot(metrics.RunSnapshot{Counts: metrics.RunCounts{SpansWritten: 20, BytesWritten: 4000}}, start.Add(2*time.Second))
+
+	if filled.SpansPerSecond != 10 || filled.BytesPerSecond != 2000 {
+		t.Errorf("unexpected throughput %v spans/s, %v bytes/s", filled.SpansPerSecond, filled.BytesPerSecond)
+	}
+	if r.Totals.SpansWritten != 0 {
+		t.Errorf("the original report must not be modified")
+	}
+}
+
+func TestCodecStats(t *testing.T) {
+	start := time.Now()
+	r := New("run", "profile", model.RunParameters{}, nil, start).WithSnapshot(metrics.RunSnapshot{Codecs: map[string]metrics.CodecCounts{
+		"msgpack": {Spans: 10, Bytes: 600, EncodeNanos: 20_000},
+		"json":    {Spans: 10, Bytes: 1000, EncodeNanos: 10_000},
+	}}, start.Add(time.Second))
+	r.SetMemory(&redisstats.MemoryAnalysis{Codecs: map[string]redisstats.MemoryAnalysis{"json": {BytesPerSpan: 150, BytesPerTrace: 1500}}})
+
+	if len(r.Codecs) != 2 || r.Codecs[0].Codec != "json" {
+		t.Fatalf("expected the codecs sorted by name, got %+v", r.Codecs)
+	}
+	json, msgpack := r.Codecs[0], r.Codecs[1]
+	if json.EncodeNsPerSpan != 1000 || json.StoredBytesPerSpan != 100 || json.MemoryBytesPerSpan != 150 {
+		t.Errorf("unexpected json stats %+v", json)
+	}
+	if msgpack.MemoryBytesPerSpan != 0 || r.Metrics()["codec.msgpack.storedBytesPerSpan"] != 60 {
+		t.Errorf("unexpected msgpack stats %+v", msgpack)
+	}
+}
+
+func TestSetExpiryComputesSavings(t *testing.T) {
+	start := time.Now()
+	r := New("run", "profile", model.RunParameters{}, nil, start).WithSnapshot(metrics.RunSnapshot{Counts: metrics.RunCounts{ExpiryCommands: 20, ExpirySkipped: 60}}, start.Add(time.Second))
+	check := &ExpiryCheck{Strategy: "per-batch", Traces: 4}
+	r.SetExpiry(check)
+
+	if r.Expiry.Commands != 20 || r.Expiry.Skipped != 60 || r.Expiry.SavedRatio != 0.75 || !r.Expiry.Bounded() {
+		t.Errorf("unexpected expiry %+v", r.Expiry)
+	}
+	if check.Commands != 0 {
+		t.Errorf("the given check must not be modified")
+	}
+	if metrics := r.Metrics(); metrics["expiry.savedRatio"] != 0.75 {
+		t.Errorf("expected the savings in the metrics, got %v", metrics["expiry.savedRatio"])
+	}
+}
+
+func TestReadCheckListsTheFirstProblems(t *testing.T) {
+	check := ReadCheck{}
+	check.AddProblems([]model.SpanProblem{{TraceId: "trace", Problem: model.ProblemTtl}})
+	if check.Passed() {
+		t.Errorf("expected a check with problems to fail")
+	}
+	check.AddProblems(make([]model.SpanProblem, MaxProblems))
+	if check.ProblemCount != MaxProblems+1 || len(check.Problems) != MaxProblems || check.Problems[0].Problem != model.ProblemTtl {
+		t.Errorf("expected %d problems listed of %d, got %d of %d", MaxProblems, MaxProblems+1, len(check.Problems), check.ProblemCount)
+	}
+}
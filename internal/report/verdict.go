@@ -0,0 +1,107 @@
+package report
+
+import (
+	"fmt"
+	"redis-test/internal/metrics"
+)
+
+// Names of the assertions made by the run thresholds.
+const (
+	AssertionCompleted       = "run_completed"
+	AssertionMinThroughput   = "min_spans_per_second"
+	AssertionMaxP99Flush     = "max_p99_flush_ms"
+	AssertionMaxErrorRate    = "max_error_rate"
+	AssertionMaxMemoryGrowth = "max_memory_growth_bytes"
+)
+
+const usedMemoryField = "used_memory"
+
+// Assertion is the outcome of checking one threshold.
+type Assertion struct {
+	Name      string  `json:"name"`
+	Threshold float64 `json:"threshold"`
+	Actual    float64 `json:"actual"`
+	Passed    bool    `json:"passed"`
+	Message   string  `json:"message,omitempty"`
+}
+
+// Verdict tells whether a run met its thresholds.
+type Verdict struct {
+	Passed     bool        `json:"passed"`
+	Assertions []Assertion `json:"assertions"`
+	Violations []Assertion `json:"violations"`
+}
+
+// Evaluate checks the report against the thresholds of its run. It returns nil if the run has no
+// thresholds. A run that did not complete, or a metric the server did not report, fails the verdict.
+func Evaluate(r Report) *Verdict {
+	thresholds := r.Parameters.Thresholds
+	if thresholds.IsEmpty() {
+		return nil
+	}
+
+	verdict := &Verdict{Passed: true, Violations: []Assertion{}}
+	add := func(assertion Assertion) {
+		verdict.Assertions = append(verdict.Assertions, assertion)
+		if !assertion.Passed {
+			verdict.Passed = false
+			verdict.Violations = append(verdict.Violations, assertion)
+		}
+	}
+
+	completed := Assertion{Name: AssertionCompleted, Passed: r.Status == StatusCompleted}
+	if !completed.Passed {
+		completed.Message = fmt.Sprintf("run ended with status %s", r.Status)
+	}
+	add(completed)
+
+	if thresholds.MinSpansPerSecond != nil {
+		add(atLeast(AssertionMinThroughput, *thresholds.MinSpansPerSecond, r.SpansPerSecond))
+	}
+	if thresholds.MaxP99FlushMS != nil {
+		if flush, ok := r.Latency[metrics.HistogramPipelineExec]; ok && flush.Count > 0 {
+			add(atMost(AssertionMaxP99Flush, *thresholds.MaxP99FlushMS, float64(flush.P99)/1000))
+		} else {
+			add(unavailable(AssertionMaxP99Flush, *thresholds.MaxP99FlushMS, "no pipeline flush was recorded"))
+		}
+	}
+	if thresholds.MaxErrorRate != nil {
+		add(atMost(AssertionMaxErrorRate, *thresholds.MaxErrorRate, errorRate(r.Totals)))
+	}
+	if thresholds.MaxMemoryGrowthBytes != nil {
+		if growth, ok := r.RedisDelta[usedMemoryField]; ok {
+			add(atMost(AssertionMaxMemoryGrowth, *thresholds.MaxMemoryGrowthBytes, growth))
+		} else {
+			add(unavailable(AssertionMaxMemoryGrowth, *thresholds.MaxMemoryGrowthBytes, "the server did not report "+usedMemoryField))
+		}
+	}
+	return verdict
+}
+
+// errorRate is the share of generated spans that failed or were dropped.
+func errorRate(totals metrics.RunCounts) float64 {
+	if totals.SpansGenerated == 0 {
+		return 0
+	}
+	return float64(totals.SpansFailed+totals.SpansDropped) / float64(totals.SpansGenerated)
+}
+
+func atLeast(name string, threshold, actual float64) Assertion {
+	assertion := Assertion{Name: name, Threshold: threshold, Actual: actual, Passed: actual >= threshold}
+	if !assertion.Passed {
+		assertion.Message = fmt.Sprintf("%g is below the minimum of %g", actual, threshold)
+	}
+	return assertion
+}
+
+func atMost(name string, threshold, actual float64) Assertion {
+	assertion := Assertion{Name: name, Threshold: threshold, Actual: actual, Passed: actual <= threshold}
+	if !assertion.Passed {
+		assertion.Message = fmt.Sprintf("%g is above the maximum of %g", actual, threshold)
+	}
+	return assertion
+}
+
+func unavailable(name string, threshold float64, reason string) Assertion {
+	return Assertion{Name: name, Threshold: threshold, Message: reason}
+}
@@ -0,0 +1,71 @@
+package report
+
+import (
+	"redis-test/internal/metrics"
+	"redis-test/model"
+	"strings"
+	"testing"
+)
+
+func threshold(value float64) *float64 {
+	return &value
+}
+
+func completedReport(thresholds model.RunThresholds) Report {
+	return Report{
+		RunId:          "run",
+		Status:         StatusCompleted,
+		Parameters:     model.RunParameters{Thresholds: thresholds},
+		SpansPerSecond: 500,
+		Totals:         metrics.RunCounts{SpansGenerated: 100, SpansWritten: 98, SpansFailed: 2},
+		Latency:        map[string]metrics.HistogramSnapshot{metrics.HistogramPipelineExec: {Count: 10, P99: 4000}},
+	}
+}
+
+func TestEvaluateWithoutThresholds(t *testing.T) {
+	if verdict := Evaluate(completedReport(model.RunThresholds{})); verdict != nil {
+		t.Errorf("expected no verdict, got %+v", verdict)
+	}
+}
+
+func TestEvaluateListsViolations(t *testing.T) {
+	verdict := Evaluate(completedReport(model.RunThresholds{
+		MinSpansPerSecond:    threshold(100),
+		MaxP99FlushMS:        threshold(2),
+		MaxErrorRate:         threshold(0.05),
+		MaxMemoryGrowthBytes: threshold(1 << 20),
+	}))
+
+	if verdict.Passed || len(verdict.Assertions) != 5 {
+		t.Fatalf("unexpected verdict %+v", verdict)
+	}
+	var violated []string
+	for _, violation := range verdict.Violations {
+		violated = append(violated, violation.Name)
+	}
+	// the p99 of 4ms exceeds 2ms and used_memory was not reported
+	if strings.Join(violated, ",") != AssertionMaxP99Flush+","+AssertionMaxMemoryGrowth {
+		t.Errorf("unexpected violations %v", violated)
+	}
+}
+
+func TestEvaluateFailsIncompleteRuns(t *testing.T) {
+	r := completedReport(model.RunThresholds{MinSpansPerSecond: threshold(100)})
+	r.Status = StatusCancelled
+	if verdict := Evaluate(r); verdict.Passed || verdict.Violations[0].Name != AssertionCompleted {
+		t.Errorf("expected a cancelled run to fail, got %+v", verdict)
+	}
+}
+
+func TestJUnitHasAFailurePerViolation(t *testing.T) {
+	r := completedReport(model.RunThresholds{MinSpansPerSecond: threshold(1000), MaxErrorRate: threshold(0.05)})
+	r.Verdict = Evaluate(r)
+	data, err := r.JUnit()
+	if err != nil {
+		t.Fatalf("unable to render junit: %v", err)
+	}
+	xml := string(data)
+	if !strings.Contains(xml, `tests="3" failures="1"`) || strings.Count(xml, "<failure") != 1 {
+		t.Errorf("unexpected junit output %s", xml)
+	}
+}
@@ -0,0 +1,59 @@
+package model
+
+// RunParameters are the parameters a run was started with.
+type RunParameters struct {
+	// Profile names the kind of run, runs of the same profile are compared in trends. A profile is
+	// derived from the other parameters if it is not given.
+	Profile       string `json:"profile,omitempty"`
+	TraceCount    int    `json:"traceCount"`
+	SpansPerTrace int    `json:"spansPerTrace"`
+	// Codecs encode the span values, the traces are spread over them in turn. The configured codec
+	// is used if none are given.
+	Codecs []string `json:"codecs,omitempty"`
+	// WriteMode is how the spans are written, see handlers.WriteModes. The pipeline is used if it is
+	// empty.
+	WriteMode string `json:"writeMode,omitempty"`
+	// TtlStrategy is how the keys of the spans are expired, see handlers.TtlStrategies. The configured
+	// strategy is used if it is empty.
+	TtlStrategy string `json:"ttlStrategy,omitempty"`
+	// Scenarios is the number of scenarios the traces are matched by in turn. The traces of each
+	// scenario are indexed in a set.
+	Scenarios int `json:"scenarios,omitempty"`
+	// Seed generates the trace and span ids, so that they can be generated again to verify the run. A
+	// seed is picked if it is not given.
+	Seed int64 `json:"seed,omitempty"`
+	// Verify is which traces are read back and verified once the run is over, see handlers.VerifyModes.
+	// The sampled traces are verified if it is empty.
+	Verify string `json:"verify,omitempty"`
+	// Namespace starts every key the run writes with KeyPrefix, run:<runId>:, so that its keys can be
+	// deleted once it is over without touching the keys of other runs.
+	Namespace bool   `json:"namespace,omitempty"`
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+	// PrefillMemory is the used_memory the server is filled to before the run is measured, a size such
+	// as 512mb or a percentage of maxmemory such as 80%. The prefill traces have the same profile as
+	// the run but are not part of its statistics.
+	PrefillMemory string `json:"prefillMemory,omitempty"`
+	// Pressure keeps writing past maxmemory for OverloadSeconds, or pressure.overloadSeconds if it is
+	// not set, then stops the load and measures how long the server takes to recover. TraceCount bounds
+	// the traces written, a pressure run without one writes until the overload is over. The traces of a
+	// pressure run are only verified if Verify is set, evicted traces would be missing.
+	Pressure        bool          `json:"pressure,omitempty"`
+	OverloadSeconds int           `json:"overloadSeconds,omitempty"`
+	Thresholds      RunThresholds `json:"thresholds"`
+	// ProjectTracesPerSecond is the load the memory needs are projected for. The throughput of the run
+	// is used if it is not set.
+	ProjectTracesPerSecond float64 `json:"projectTracesPerSecond,omitempty"`
+}
+
+// RunThresholds are the assertions a run has to meet to pass. Unset thresholds are not checked.
+type RunThresholds struct {
+	MinSpansPerSecond    *float64 `json:"minSpansPerSecond,omitempty"`
+	MaxP99FlushMS        *float64 `json:"maxP99FlushMS,omitempty"`
+	MaxErrorRate         *float64 `json:"maxErrorRate,omitempty"`
+	MaxMemoryGrowthBytes *float64 `json:"maxMemoryGrowthBytes,omitempty"`
+}
+
+// IsEmpty reports whether no threshold is set.
+func (t RunThresholds) IsEmpty() bool {
+	return t.MinSpansPerSecond == nil && t.MaxP99FlushMS == nil && t.MaxErrorRate == nil && t.MaxMemoryGrowthBytes == nil
+}
@@ -0,0 +1,25 @@
+package model
+
+// Problems found by verifying the traces of a run.
+const (
+	// ProblemMissing is a generated span that was not found.
+	ProblemMissing = "missing"
+	// ProblemCorrupt is a span whose value does not decode.
+	ProblemCorrupt = "corrupt"
+	// ProblemOrphaned is a span whose parent is not in the trace.
+	ProblemOrphaned = "orphaned"
+	// ProblemUnexpected is a span found in a trace that the run did not generate.
+	ProblemUnexpected = "unexpected"
+	// ProblemUnrooted is a trace whose spans do not form a single tree rooted in its first span.
+	ProblemUnrooted = "unrooted"
+	// ProblemTtl is a trace whose ttl is not in the range its ttl strategy sets.
+	ProblemTtl = "ttl"
+)
+
+// SpanProblem is something wrong with a span, or a whole trace, found by verifying a run.
+type SpanProblem struct {
+	TraceId string `json:"traceId"`
+	SpanId  string `json:"spanId,omitempty"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}